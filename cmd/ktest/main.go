@@ -1,17 +1,20 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
+	"io"
 	"log"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/cespare/subcmd"
-	"github.com/quasilyte/ktest/internal/bench"
-	"github.com/quasilyte/ktest/internal/kenv"
-	"github.com/quasilyte/ktest/internal/phpunit"
+	"github.com/quasilyte/ktest/pkg/ktest"
 )
 
 func main() {
@@ -24,6 +27,18 @@ func main() {
 			Do:          phpunitMain,
 		},
 
+		{
+			Name:        "check",
+			Description: "check phpunit tests and their sources for KPHP compatibility issues",
+			Do:          checkMain,
+		},
+
+		{
+			Name:        "mutate",
+			Description: "run mutation testing of phpunit tests using KPHP",
+			Do:          mutateMain,
+		},
+
 		{
 			Name:        "benchstat",
 			Description: "compute and compare statistics about benchmark results",
@@ -48,6 +63,12 @@ func main() {
 			Do:          benchVsPHPMain,
 		},
 
+		{
+			Name:        "fuzz-diff",
+			Description: "find the inputs a method handles differently in PHP and KPHP",
+			Do:          fuzzDiffMain,
+		},
+
 		{
 			Name:        "env",
 			Description: "print ktest-related env variables",
@@ -99,7 +120,7 @@ func cmdBenchVsPHP(args []string) error {
 }
 
 func cmdBenchPHP(args []string) error {
-	conf := &bench.RunConfig{}
+	conf := &ktest.BenchConfig{}
 
 	workdir, err := os.Getwd()
 	if err != nil {
@@ -117,6 +138,8 @@ func cmdBenchPHP(args []string) error {
 		`project root directory`)
 	fs.StringVar(&conf.PhpCommand, "php", "php",
 		`PHP command to run the benchmarks`)
+	fs.StringVar(&conf.PHPVersion, "php-version", ktest.DefaultPHPVersion,
+		`PHP language version used to parse the benchmark files (supported: `+ktest.SupportedPHPVersions+`)`)
 	fs.Parse(args)
 
 	if len(fs.Args()) == 0 {
@@ -141,7 +164,7 @@ func cmdBenchPHP(args []string) error {
 }
 
 func cmdBench(args []string) error {
-	conf := &bench.RunConfig{}
+	conf := &ktest.BenchConfig{}
 
 	workdir, err := os.Getwd()
 	if err != nil {
@@ -159,6 +182,8 @@ func cmdBench(args []string) error {
 		`project root directory`)
 	fs.StringVar(&conf.KphpCommand, "kphp2cpp-binary", "",
 		`kphp binary path; if empty, $KPHP_ROOT/objs/kphp2cpp is used`)
+	fs.StringVar(&conf.PHPVersion, "php-version", ktest.DefaultPHPVersion,
+		`PHP language version used to parse the benchmark files (supported: `+ktest.SupportedPHPVersions+`)`)
 	fs.Parse(args)
 
 	if len(fs.Args()) == 0 {
@@ -182,31 +207,282 @@ func cmdBench(args []string) error {
 	return benchCmdImpl(conf)
 }
 
-func benchCmdImpl(conf *bench.RunConfig) error {
+func benchCmdImpl(conf *ktest.BenchConfig) error {
 	var err error
-	conf.ProjectRoot, err = filepath.Abs(conf.ProjectRoot)
+	conf.ProjectRoot, err = resolveProjectRoot(conf.ProjectRoot)
+	if err != nil {
+		return err
+	}
+
+	if conf.KphpCommand == "" {
+		kphpBinary := ktest.FindKphpBinary()
+		if kphpBinary == "" {
+			return fmt.Errorf("can't locate kphp2cpp binary; please set -kphp2cpp-binary arg")
+		}
+		conf.KphpCommand = kphpBinary
+	}
+
+	ctx, cancel := interruptContext()
+	defer cancel()
+	if err := ktest.RunBench(ctx, conf); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func checkMain(args []string) {
+	if err := cmdCheck(args); err != nil {
+		log.Fatalf("ktest check: error: %v", err)
+	}
+}
+
+func cmdCheck(args []string) error {
+	conf := &ktest.PhpunitConfig{}
+
+	workdir, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+
+	fs := flag.NewFlagSet("ktest check", flag.ExitOnError)
+	fs.StringVar(&conf.ProjectRoot, "project-root", workdir,
+		`project root directory`)
+	fs.StringVar(&conf.SrcDir, "src-dir", "src",
+		`project sources root, used along with the composer.json autoload paths`)
+	fs.StringVar(&conf.PHPVersion, "php-version", ktest.DefaultPHPVersion,
+		`PHP language version used to parse the test and source files (supported: `+ktest.SupportedPHPVersions+`)`)
+	fs.Parse(args)
+
+	if len(fs.Args()) == 0 {
+		// TODO: print command help here?
+		log.Printf("Expected at least 1 positional argument, the test target")
+		return nil
+	}
+
+	testTarget, err := filepath.Abs(fs.Args()[0])
+	if err != nil {
+		return fmt.Errorf("resolve test target path: %v", err)
+	}
+
+	conf.ProjectRoot, err = resolveProjectRoot(conf.ProjectRoot)
+	if err != nil {
+		return err
+	}
+	conf.TestTarget = testTarget
+
+	issues, err := ktest.CheckPhpunit(conf)
+	if err != nil {
+		return err
+	}
+	ktest.FormatCompatIssues(os.Stdout, issues)
+	numErrors := 0
+	for _, issue := range issues {
+		if !issue.Warning {
+			numErrors++
+		}
+	}
+	if numErrors != 0 {
+		return fmt.Errorf("found %d KPHP compatibility issues", numErrors)
+	}
+
+	return nil
+}
+
+func fuzzDiffMain(args []string) {
+	if err := cmdFuzzDiff(args); err != nil {
+		log.Fatalf("ktest fuzz-diff: error: %v", err)
+	}
+}
+
+func cmdFuzzDiff(args []string) error {
+	conf := &ktest.FuzzDiffConfig{}
+
+	workdir, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+
+	fs := flag.NewFlagSet("ktest fuzz-diff", flag.ExitOnError)
+	debug := fs.Bool("debug", false,
+		`print debug info`)
+	fs.IntVar(&conf.Count, "count", 100,
+		`number of the generated inputs`)
+	fs.Int64Var(&conf.Seed, "seed", 1,
+		`seed for the inputs generator`)
+	fs.StringVar(&conf.CorpusDir, "corpus-dir", "",
+		`directory for the diverging inputs; if empty, testdata/fuzz-diff/<Class>.<method> is used`)
+	fs.DurationVar(&conf.TimeoutPerInput, "timeout-per-input", 10*time.Second,
+		`time limit for a single input evaluation by PHP or KPHP; 0 means no limit`)
+	fs.BoolVar(&conf.NoCleanup, "no-cleanup", false,
+		`whether to keep temp build directory`)
+	fs.StringVar(&conf.ProjectRoot, "project-root", workdir,
+		`project root directory`)
+	fs.StringVar(&conf.SrcDir, "src-dir", "src",
+		`project sources root, used along with the composer.json autoload paths`)
+	fs.StringVar(&conf.PhpCommand, "php", "php",
+		`PHP command to evaluate the inputs`)
+	fs.StringVar(&conf.KphpCommand, "kphp2cpp-binary", "",
+		`kphp binary path; if empty, $KPHP_ROOT/objs/kphp2cpp is used`)
+	fs.StringVar(&conf.PHPVersion, "php-version", ktest.DefaultPHPVersion,
+		`PHP language version used to parse the source files (supported: `+ktest.SupportedPHPVersions+`)`)
+	fs.Parse(args)
+
+	if len(fs.Args()) == 0 {
+		// TODO: print command help here?
+		log.Printf("Expected at least 1 positional argument, the Class::method target")
+		return nil
+	}
+
+	conf.Target = fs.Args()[0]
+	conf.ProjectRoot, err = resolveProjectRoot(conf.ProjectRoot)
+	if err != nil {
+		return err
+	}
+	if conf.KphpCommand == "" {
+		kphpBinary := ktest.FindKphpBinary()
+		if kphpBinary == "" {
+			return fmt.Errorf("can't locate kphp2cpp binary; please set -kphp2cpp-binary arg")
+		}
+		conf.KphpCommand = kphpBinary
+	}
+	conf.Output = os.Stdout
+	if *debug {
+		conf.DebugPrint = func(msg string) {
+			log.Print(msg)
+		}
+	}
+
+	ctx, cancel := interruptContext()
+	defer cancel()
+	result, err := ktest.FuzzDiff(ctx, conf)
 	if err != nil {
-		return fmt.Errorf("resolve project root path: %v", err)
+		return err
+	}
+	ktest.FormatFuzzDiffResult(os.Stdout, result)
+	if len(result.Divergences) != 0 {
+		return fmt.Errorf("found %d divergences", len(result.Divergences))
+	}
+
+	return nil
+}
+
+func mutateMain(args []string) {
+	if err := cmdMutate(args); err != nil {
+		log.Fatalf("ktest mutate: error: %v", err)
 	}
-	if !strings.HasSuffix(conf.ProjectRoot, "/") {
-		conf.ProjectRoot += "/"
+}
+
+func cmdMutate(args []string) error {
+	conf := &ktest.PhpunitConfig{}
+
+	workdir, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+
+	fs := flag.NewFlagSet("ktest mutate", flag.ExitOnError)
+	debug := fs.Bool("debug", false,
+		`print debug info`)
+	timeout := fs.Duration("timeout", 5*time.Minute,
+		`time budget for a single mutant build and run`)
+	fs.BoolVar(&conf.NoCleanup, "no-cleanup", false,
+		`whether to keep temp build directory`)
+	fs.StringVar(&conf.ProjectRoot, "project-root", workdir,
+		`project root directory`)
+	fs.StringVar(&conf.SrcDir, "src-dir", "src",
+		`project sources root, used along with the composer.json autoload paths`)
+	fs.StringVar(&conf.PHPVersion, "php-version", ktest.DefaultPHPVersion,
+		`PHP language version used to parse the test and source files (supported: `+ktest.SupportedPHPVersions+`)`)
+	fs.StringVar(&conf.KphpCommand, "kphp2cpp-binary", "",
+		`kphp binary path; if empty, $KPHP_ROOT/objs/kphp2cpp is used`)
+	stubsFile := fs.String("stubs", "",
+		`PHP file with the @ktest-stub declarations; if empty, tests/stubs.php is used when it exists`)
+	fs.Parse(args)
+
+	if len(fs.Args()) == 0 {
+		// TODO: print command help here?
+		log.Printf("Expected at least 1 positional argument, the test target")
+		return nil
+	}
+
+	testTarget, err := filepath.Abs(fs.Args()[0])
+	if err != nil {
+		return fmt.Errorf("resolve test target path: %v", err)
+	}
+
+	conf.ProjectRoot, err = resolveProjectRoot(conf.ProjectRoot)
+	if err != nil {
+		return err
+	}
+
+	conf.TestTarget = testTarget
+	conf.TestArgv = fs.Args()[1:]
+	conf.StubsFile, err = resolveStubsFile(conf.ProjectRoot, *stubsFile)
+	if err != nil {
+		return err
+	}
+
+	if *debug {
+		conf.DebugPrint = func(msg string) {
+			log.Print(msg)
+		}
 	}
 
 	if conf.KphpCommand == "" {
-		kphpBinary := kenv.FindKphpBinary()
+		kphpBinary := ktest.FindKphpBinary()
 		if kphpBinary == "" {
 			return fmt.Errorf("can't locate kphp2cpp binary; please set -kphp2cpp-binary arg")
 		}
 		conf.KphpCommand = kphpBinary
 	}
 
-	if err := bench.Run(conf); err != nil {
+	ctx, cancel := interruptContext()
+	defer cancel()
+	result, err := ktest.Mutate(ctx, &ktest.MutateConfig{
+		RunConfig: conf,
+		Timeout:   *timeout,
+		Progress:  os.Stderr,
+	})
+	if err != nil {
 		return err
 	}
 
+	ktest.FormatMutationResult(os.Stdout, result)
+
 	return nil
 }
 
+// resolveStubsFile returns the absolute stubs file path.
+// If the -stubs flag is not set, the conventional location is checked.
+// resolveProjectRoot returns an absolute project root path with a trailing slash.
+// Every subcommand normalizes its -project-root flag with it.
+func resolveProjectRoot(dir string) (string, error) {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return "", fmt.Errorf("resolve project root path: %v", err)
+	}
+	if !strings.HasSuffix(abs, "/") {
+		abs += "/"
+	}
+	return abs, nil
+}
+
+func resolveStubsFile(projectRoot, flagValue string) (string, error) {
+	if flagValue == "" {
+		filename := filepath.Join(projectRoot, "tests", "stubs.php")
+		if _, err := os.Stat(filename); err != nil {
+			return "", nil
+		}
+		return filename, nil
+	}
+	filename, err := filepath.Abs(flagValue)
+	if err != nil {
+		return "", fmt.Errorf("resolve stubs file path: %v", err)
+	}
+	return filename, nil
+}
+
 func phpunitMain(args []string) {
 	if err := cmdPhpunit(args); err != nil {
 		log.Fatalf("ktest phpunit: error: %v", err)
@@ -214,7 +490,7 @@ func phpunitMain(args []string) {
 }
 
 func cmdPhpunit(args []string) error {
-	conf := &phpunit.RunConfig{}
+	conf := &ktest.PhpunitConfig{}
 
 	workdir, err := os.Getwd()
 	if err != nil {
@@ -224,14 +500,70 @@ func cmdPhpunit(args []string) error {
 	fs := flag.NewFlagSet("ktest phpunit", flag.ExitOnError)
 	debug := fs.Bool("debug", false,
 		`print debug info`)
+	verbose := fs.Bool("v", false,
+		`print the compile and run time of every test class and method`)
+	slowest := fs.Int("slowest", 0,
+		`report the specified number of the slowest test methods`)
+	compactDiff := fs.Bool("compact-diff", false,
+		`print one-line failure reasons instead of diffs for arrays and multi-line strings`)
+	watch := fs.Bool("watch", false,
+		`re-run the affected tests when test or source files change`)
+	watchInterval := fs.Duration("watch-interval", 500*time.Millisecond,
+		`file system polling interval for the watch mode`)
 	fs.BoolVar(&conf.NoCleanup, "no-cleanup", false,
 		`whether to keep temp build directory`)
 	fs.StringVar(&conf.ProjectRoot, "project-root", workdir,
 		`project root directory`)
 	fs.StringVar(&conf.SrcDir, "src-dir", "src",
-		`project sources root`)
+		`project sources root, used along with the composer.json autoload paths`)
+	fs.StringVar(&conf.PHPVersion, "php-version", ktest.DefaultPHPVersion,
+		`PHP language version used to parse the test and source files (supported: `+ktest.SupportedPHPVersions+`)`)
 	fs.StringVar(&conf.KphpCommand, "kphp2cpp-binary", "",
 		`kphp binary path; if empty, $KPHP_ROOT/objs/kphp2cpp is used`)
+	fs.StringVar(&conf.OrderBy, "order-by", "default",
+		`tests execution order: default or random`)
+	fs.Int64Var(&conf.RandomOrderSeed, "random-order-seed", 0,
+		`seed for the random tests order; if 0, a new seed is generated`)
+	fs.BoolVar(&conf.NoCompatCheck, "no-compat-check", false,
+		`skip the KPHP compatibility checks before the compilation`)
+	fs.BoolVar(&conf.StopOnSharedBuildError, "stop-on-shared-build-error", false,
+		`stop after the first build error that comes from outside of the test files`)
+	fs.BoolVar(&conf.StopOnFailure, "stop-on-failure", false,
+		`stop after the first failed test, build error or run error`)
+	fs.BoolVar(&conf.StopOnError, "stop-on-error", false,
+		`stop after the first test class that can't be compiled or executed`)
+	fs.BoolVar(&conf.RerunFailed, "rerun-failed", false,
+		`run only the tests that failed during the previous runs`)
+	fs.StringVar(&conf.StateFile, "state-file", "",
+		`file that keeps the failed tests between the runs; if empty, a file inside the user cache dir is used`)
+	fs.BoolVar(&conf.UpdateSnapshots, "update-snapshots", false,
+		`write the new and changed snapshots instead of failing assertMatchesSnapshot`)
+	fs.Int64Var(&conf.PropertySeed, "property-seed", 0,
+		`seed for the forAll generators, use it to replay a failed property; if 0, a new seed is generated`)
+	fs.IntVar(&conf.PropertyRuns, "property-runs", 0,
+		`number of generated inputs every forAll checks; if 0, 100 inputs are checked`)
+	fs.IntVar(&conf.Retries, "retries", 0,
+		`re-run a failed test method up to the specified number of times; tests that pass on a retry are reported as flaky`)
+	memoryLimit := fs.String("memory-limit-per-test", "",
+		`fail the tests with a peak memory usage above the limit, like 64M or 1G; if empty, the usage is not limited; every test method is executed in a separate process when the limit is set`)
+	fs.BoolVar(&conf.Coverage, "coverage", false,
+		`collect the line coverage of the project sources`)
+	coverageLcov := fs.String("coverage-lcov", "",
+		`write the coverage report in lcov format to the specified file; implies -coverage`)
+	coverageCobertura := fs.String("coverage-cobertura", "",
+		`write the coverage report in Cobertura XML format to the specified file; implies -coverage`)
+	jsonReport := fs.String("json-report", "",
+		`write the test results in JSON format to the specified file`)
+	junitReport := fs.String("junit-report", "",
+		`write the test results in JUnit XML format to the specified file`)
+	fs.IntVar(&conf.ShardIndex, "shard-index", 0,
+		`shard to run, from 0 to shard-total-1`)
+	fs.IntVar(&conf.ShardTotal, "shard-total", 0,
+		`split the test classes into the specified number of shards and run only the shard-index one`)
+	fs.StringVar(&conf.ShardTimingsFile, "shard-timings", "",
+		`JSON report of a previous run used to balance the shards by the test classes cost`)
+	stubsFile := fs.String("stubs", "",
+		`PHP file with the @ktest-stub declarations; if empty, tests/stubs.php is used when it exists`)
 	fs.Parse(args)
 
 	if len(fs.Args()) == 0 {
@@ -245,17 +577,36 @@ func cmdPhpunit(args []string) error {
 		return fmt.Errorf("resolve test target path: %v", err)
 	}
 
-	conf.ProjectRoot, err = filepath.Abs(conf.ProjectRoot)
+	conf.ProjectRoot, err = resolveProjectRoot(conf.ProjectRoot)
 	if err != nil {
-		return fmt.Errorf("resolve project root path: %v", err)
-	}
-	if !strings.HasSuffix(conf.ProjectRoot, "/") {
-		conf.ProjectRoot += "/"
+		return err
 	}
 
 	conf.TestTarget = testTarget
 	conf.TestArgv = fs.Args()[1:]
 	conf.Output = os.Stdout
+	conf.StubsFile, err = resolveStubsFile(conf.ProjectRoot, *stubsFile)
+	if err != nil {
+		return err
+	}
+	if *coverageLcov != "" || *coverageCobertura != "" {
+		conf.Coverage = true
+	}
+	if conf.StateFile == "" {
+		conf.StateFile, err = ktest.DefaultPhpunitStateFile(conf.ProjectRoot)
+		if err != nil {
+			if conf.RerunFailed {
+				return fmt.Errorf("locate state file: %v", err)
+			}
+			log.Printf("the failed tests won't be saved: locate state file: %v", err)
+		}
+	}
+	if *memoryLimit != "" {
+		conf.MemoryLimitPerTest, err = parseByteSize(*memoryLimit)
+		if err != nil {
+			return fmt.Errorf("parse -memory-limit-per-test: %v", err)
+		}
+	}
 
 	if *debug {
 		conf.DebugPrint = func(msg string) {
@@ -264,22 +615,129 @@ func cmdPhpunit(args []string) error {
 	}
 
 	if conf.KphpCommand == "" {
-		kphpBinary := kenv.FindKphpBinary()
+		kphpBinary := ktest.FindKphpBinary()
 		if kphpBinary == "" {
 			return fmt.Errorf("can't locate kphp2cpp binary; please set -kphp2cpp-binary arg")
 		}
 		conf.KphpCommand = kphpBinary
 	}
 
-	result, err := phpunit.Run(conf)
+	formatConfig := &ktest.PhpunitFormatConfig{
+		PrintTime:   true,
+		Verbose:     *verbose,
+		Slowest:     *slowest,
+		CompactDiff: *compactDiff,
+	}
+
+	ctx, cancel := interruptContext()
+	defer cancel()
+
+	if *watch {
+		return ktest.WatchPhpunit(ctx, &ktest.WatchConfig{
+			RunConfig:    conf,
+			FormatConfig: formatConfig,
+			PollInterval: *watchInterval,
+		})
+	}
+
+	result, err := ktest.RunPhpunit(ctx, conf)
 	if err != nil {
 		return err
 	}
 
-	formatConfig := &phpunit.FormatConfig{
-		PrintTime: true,
+	ktest.FormatPhpunitResult(os.Stdout, formatConfig, result)
+
+	testReports := []struct {
+		filename string
+		write    func(io.Writer, *ktest.PhpunitResult) error
+	}{
+		{*jsonReport, ktest.WritePhpunitJSONReport},
+		{*junitReport, ktest.WritePhpunitJUnitReport},
+	}
+	for _, report := range testReports {
+		if report.filename == "" {
+			continue
+		}
+		if err := writeReportFile(report.filename, func(w io.Writer) error {
+			return report.write(w, result)
+		}); err != nil {
+			return fmt.Errorf("write test report: %v", err)
+		}
+	}
+
+	if result.Coverage != nil {
+		reports := []struct {
+			filename string
+			write    func(io.Writer, string, *ktest.CoverageProfile) error
+		}{
+			{*coverageLcov, ktest.WriteCoverageLcov},
+			{*coverageCobertura, ktest.WriteCoverageCobertura},
+		}
+		for _, report := range reports {
+			if report.filename == "" {
+				continue
+			}
+			if err := writeReportFile(report.filename, func(w io.Writer) error {
+				return report.write(w, conf.ProjectRoot, result.Coverage)
+			}); err != nil {
+				return fmt.Errorf("write coverage report: %v", err)
+			}
+		}
 	}
-	phpunit.FormatResult(os.Stdout, formatConfig, result)
 
 	return nil
 }
+
+func writeReportFile(filename string, write func(w io.Writer) error) error {
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	if err := write(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// parseByteSize parses a size like "512K", "64M" or "1G";
+// the suffixes are binary, a number without a suffix is a size in bytes.
+func parseByteSize(s string) (int64, error) {
+	multiplier := int64(1)
+	switch strings.ToUpper(s[len(s)-1:]) {
+	case "K":
+		multiplier = 1 << 10
+	case "M":
+		multiplier = 1 << 20
+	case "G":
+		multiplier = 1 << 30
+	}
+	if multiplier != 1 {
+		s = s[:len(s)-1]
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	if n < 0 {
+		return 0, fmt.Errorf("negative size %d", n)
+	}
+	return n * multiplier, nil
+}
+
+// interruptContext returns a context that is canceled when
+// the process receives an interrupt signal.
+func interruptContext() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	go func() {
+		select {
+		case <-interrupt:
+			cancel()
+		case <-ctx.Done():
+		}
+		signal.Stop(interrupt)
+	}()
+	return ctx, cancel
+}
@@ -11,6 +11,7 @@ import (
 	"time"
 
 	"github.com/quasilyte/ktest/internal/fileutil"
+	"github.com/quasilyte/ktest/pkg/ktest"
 )
 
 func benchmarkVsPHP(args []string) error {
@@ -19,6 +20,7 @@ func benchmarkVsPHP(args []string) error {
 	flagCount := fs.Int("count", 10, `run each benchmark n times`)
 	flagPhpCommand := fs.String("php", "php", `PHP command to run the benchmarks`)
 	flagKphpCommand := fs.String("kphp2cpp-binary", "", `kphp binary path; if empty, $KPHP_ROOT/objs/kphp2cpp is used`)
+	flagPHPVersion := fs.String("php-version", ktest.DefaultPHPVersion, `PHP language version used to parse the benchmark files`)
 	fs.Parse(args)
 
 	if len(fs.Args()) == 0 {
@@ -102,6 +104,7 @@ func benchmarkVsPHP(args []string) error {
 		args := []string{
 			"bench",
 			"--count", fmt.Sprint(*flagCount),
+			"--php-version", *flagPHPVersion,
 		}
 		if *flagKphpCommand != "" {
 			args = append(args, "--kphp2cpp-binary", *flagKphpCommand)
@@ -123,6 +126,7 @@ func benchmarkVsPHP(args []string) error {
 		args := []string{
 			"bench-php",
 			"--count", fmt.Sprint(*flagCount),
+			"--php-version", *flagPHPVersion,
 		}
 		if *flagPhpCommand != "" {
 			args = append(args, "--php", *flagPhpCommand)
@@ -5,6 +5,6 @@ go 1.15
 require github.com/cespare/subcmd v1.1.0
 
 require (
-	github.com/z7zmey/php-parser v0.8.0-rc.1.0.20210213215434-367eff9de651
+	github.com/VKCOM/php-parser v0.8.2
 	golang.org/x/perf v0.0.0-20210220033136-40a54f11e909
 )
@@ -3,8 +3,8 @@ package bench
 import (
 	"strings"
 
-	"github.com/z7zmey/php-parser/pkg/ast"
-	"github.com/z7zmey/php-parser/pkg/visitor"
+	"github.com/VKCOM/php-parser/pkg/ast"
+	"github.com/VKCOM/php-parser/pkg/visitor"
 )
 
 type astVisitor struct {
@@ -1,6 +1,7 @@
 package bench
 
 import (
+	"context"
 	"io"
 )
 
@@ -11,6 +12,10 @@ type RunConfig struct {
 	KphpCommand string
 	PhpCommand  string
 
+	// PHPVersion is a "major.minor" PHP language version used to parse
+	// the benchmark files. If empty, phpsyntax.DefaultVersion is used.
+	PHPVersion string
+
 	Count int
 
 	Output     io.Writer
@@ -19,7 +24,9 @@ type RunConfig struct {
 	NoCleanup bool
 }
 
-func Run(conf *RunConfig) error {
+// Run compiles and runs the benchmarks, the results are written to the conf.Output.
+// Canceling the ctx interrupts the build and run commands.
+func Run(ctx context.Context, conf *RunConfig) error {
 	r := newRunner(conf)
-	return r.Run()
+	return r.Run(ctx)
 }
@@ -2,6 +2,7 @@ package bench
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"io/ioutil"
 	"log"
@@ -13,17 +14,20 @@ import (
 	"text/template"
 	"time"
 
+	"github.com/VKCOM/php-parser/pkg/version"
+	"github.com/VKCOM/php-parser/pkg/visitor/traverser"
 	"github.com/quasilyte/ktest/internal/fileutil"
-	"github.com/z7zmey/php-parser/pkg/conf"
-	"github.com/z7zmey/php-parser/pkg/errors"
-	"github.com/z7zmey/php-parser/pkg/parser"
-	"github.com/z7zmey/php-parser/pkg/version"
-	"github.com/z7zmey/php-parser/pkg/visitor/traverser"
+	"github.com/quasilyte/ktest/internal/phpsyntax"
 )
 
 type runner struct {
 	conf *RunConfig
 
+	// ctx is used to interrupt the build and run commands.
+	ctx context.Context
+
+	phpVersion *version.Version
+
 	benchFiles []*benchFile
 
 	composerMode bool
@@ -62,7 +66,8 @@ func (r *runner) debugf(format string, args ...interface{}) {
 	}
 }
 
-func (r *runner) Run() error {
+func (r *runner) Run(ctx context.Context) error {
+	r.ctx = ctx
 	defer func() {
 		if r.buildDir == "" || r.conf.NoCleanup {
 			return
@@ -77,10 +82,10 @@ func (r *runner) Run() error {
 		fn   func() error
 	}{
 		{"check for issues", r.stepCheckIssues},
+		{"parse php version", r.stepParsePHPVersion},
 		{"find bench files", r.stepFindBenchFiles},
 		{"prepare temp build dir", r.stepPrepareTempBuildDir},
 		{"parse bench files", r.stepParseBenchFiles},
-		{"filter only parsed files", r.stepFilterOnlyParsedFiles},
 		{"sort bench files", r.stepSortBenchFiles},
 		{"generate bench main", r.stepGenerateBenchMain},
 		{"run bench", r.stepRunBench},
@@ -103,6 +108,15 @@ func (r *runner) stepCheckIssues() error {
 	return nil
 }
 
+func (r *runner) stepParsePHPVersion() error {
+	v, err := phpsyntax.ParseVersion(r.conf.PHPVersion)
+	if err != nil {
+		return err
+	}
+	r.phpVersion = v
+	return nil
+}
+
 func (r *runner) stepFindBenchFiles() error {
 	var testDir string
 	var benchFiles []string
@@ -153,19 +167,13 @@ func (r *runner) stepParseBenchFiles() error {
 		if err != nil {
 			return fmt.Errorf("read file: %w", err)
 		}
-		var parserErrors []*errors.Error
-		errorHandler := func(e *errors.Error) {
-			parserErrors = append(parserErrors, e)
-		}
-		rootNode, err := parser.Parse(src, conf.Config{
-			Version:          &version.Version{Major: 7, Minor: 4},
-			ErrorHandlerFunc: errorHandler,
-		})
-		if err != nil || len(parserErrors) != 0 {
-			for _, parseErr := range parserErrors {
+		rootNode, parserErrors := phpsyntax.Parse(src, r.phpVersion)
+		if len(parserErrors) != 0 {
+			for _, parseErr := range parserErrors[1:] {
 				log.Printf("%s: parse error: %v", f.fullName, parseErr)
 			}
-			return err
+			return fmt.Errorf("%s: parse error: %v (PHP version %d.%d)",
+				f.fullName, parserErrors[0], r.phpVersion.Major, r.phpVersion.Minor)
 		}
 		f.info = &benchParsedInfo{}
 		visitor := &astVisitor{out: f.info}
@@ -179,18 +187,6 @@ func (r *runner) stepParseBenchFiles() error {
 	return nil
 }
 
-func (r *runner) stepFilterOnlyParsedFiles() error {
-	parsedFiles := make([]*benchFile, 0, len(r.benchFiles))
-	for _, f := range r.benchFiles {
-		if f.info != nil {
-			parsedFiles = append(parsedFiles, f)
-		}
-	}
-	r.benchFiles = parsedFiles
-
-	return nil
-}
-
 func (r *runner) stepSortBenchFiles() error {
 	sort.Slice(r.benchFiles, func(i, j int) bool {
 		return r.benchFiles[i].fullName < r.benchFiles[j].fullName
@@ -284,7 +280,7 @@ func (r *runner) runPhpBench() error {
 		args := []string{
 			"-f", mainFilename,
 		}
-		runCommand := exec.Command(r.conf.PhpCommand, args...)
+		runCommand := exec.CommandContext(r.ctx, r.conf.PhpCommand, args...)
 		runCommand.Dir = r.buildDir
 		var runStdout bytes.Buffer
 		runCommand.Stderr = r.conf.Output
@@ -323,7 +319,7 @@ func (r *runner) stepRunBench() error {
 			args = append(args, "--composer-root", r.conf.ProjectRoot)
 		}
 		args = append(args, mainFilename)
-		buildCommand := exec.Command(r.conf.KphpCommand, args...)
+		buildCommand := exec.CommandContext(r.ctx, r.conf.KphpCommand, args...)
 		buildCommand.Dir = r.buildDir
 		out, err := buildCommand.CombinedOutput()
 		if err != nil {
@@ -333,7 +329,7 @@ func (r *runner) stepRunBench() error {
 
 		// 2. Run.
 		executableName := filepath.Join(r.buildDir, "cli")
-		runCommand := exec.Command(executableName)
+		runCommand := exec.CommandContext(r.ctx, executableName)
 		runCommand.Dir = r.buildDir
 		var runStdout bytes.Buffer
 		runCommand.Stderr = r.conf.Output
@@ -0,0 +1,155 @@
+package fuzzdiff
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+type RunConfig struct {
+	ProjectRoot string
+
+	// SrcDir is a directory inside the ProjectRoot
+	// that is searched for the target class.
+	SrcDir string
+
+	// Target is a "Class::method" to fuzz.
+	// The class name can be either fully qualified or a short one.
+	Target string
+
+	KphpCommand string
+	PhpCommand  string
+
+	// PHPVersion is a "major.minor" PHP language version used to parse
+	// the source files. If empty, phpsyntax.DefaultVersion is used.
+	PHPVersion string
+
+	// Count is a number of the generated inputs.
+	Count int
+
+	// Seed is used to generate the inputs.
+	// The same seed results in the same inputs.
+	Seed int64
+
+	// CorpusDir is a directory where the diverging inputs are saved.
+	// The inputs found there are evaluated before the generated ones.
+	// If empty, testdata/fuzz-diff/<Class>.<method> inside the ProjectRoot is used.
+	CorpusDir string
+
+	// TimeoutPerInput limits a single input evaluation by PHP or KPHP.
+	// The timed out evaluation is reported as a crash.
+	// If 0, the evaluation time is not limited.
+	TimeoutPerInput time.Duration
+
+	Output     io.Writer
+	DebugPrint func(string)
+
+	NoCleanup bool
+}
+
+type Result struct {
+	Time time.Duration
+
+	// Inputs is a number of the evaluated inputs,
+	// CorpusInputs of them were loaded from the corpus.
+	Inputs       int
+	CorpusInputs int
+
+	Divergences []Divergence
+}
+
+// Divergence is an input the PHP and KPHP evaluate differently.
+type Divergence struct {
+	// Input is a JSON-encoded arguments list.
+	Input string
+
+	PHP  Outcome
+	KPHP Outcome
+
+	// SavedTo is a corpus file the input was saved to.
+	// It's empty for the inputs that were loaded from the corpus.
+	SavedTo string
+}
+
+// Outcome is a result of the target method evaluation.
+type Outcome struct {
+	// Result is the returned value exported the way PHPUnit prints it,
+	// null for the void methods.
+	Result string
+
+	Output string
+
+	// Exception is a "Class: message" of the thrown exception, if any.
+	Exception string
+
+	// Warnings contains the stderr contents.
+	// Only the presence of the warnings is compared,
+	// the messages format differs between PHP and KPHP.
+	Warnings string
+
+	// Crash describes an abnormal process termination, if any.
+	Crash string
+}
+
+// Run evaluates the target method with the same inputs
+// under PHP and KPHP and reports the differences.
+// Canceling the ctx interrupts the build and run commands.
+func Run(ctx context.Context, conf *RunConfig) (*Result, error) {
+	r := newRunner(conf)
+	return r.Run(ctx)
+}
+
+// FormatResult prints the found divergences and the summary.
+func FormatResult(w io.Writer, result *Result) {
+	fmt.Fprintf(w, "\nTime: %s\n\n", result.Time)
+
+	for i, d := range result.Divergences {
+		fmt.Fprintf(w, "%d) input: %s\n", i+1, d.Input)
+		printField := func(name, php, kphp string) {
+			if php == kphp {
+				return
+			}
+			// The exported arrays take several lines.
+			indent := func(s string) string { return strings.ReplaceAll(s, "\n", "\n        ") }
+			fmt.Fprintf(w, "%s:\n  PHP:  %s\n  KPHP: %s\n", name, indent(orNone(php)), indent(orNone(kphp)))
+		}
+		printField("result", d.PHP.Result, d.KPHP.Result)
+		printField("output", quoteNonEmpty(d.PHP.Output), quoteNonEmpty(d.KPHP.Output))
+		printField("exception", d.PHP.Exception, d.KPHP.Exception)
+		// The crash and warning messages always differ,
+		// so they're printed only if one side has none.
+		if (d.PHP.Crash == "") != (d.KPHP.Crash == "") {
+			printField("crash", d.PHP.Crash, d.KPHP.Crash)
+		}
+		if (d.PHP.Warnings == "") != (d.KPHP.Warnings == "") {
+			printField("warnings", d.PHP.Warnings, d.KPHP.Warnings)
+		}
+		if d.SavedTo != "" {
+			fmt.Fprintf(w, "saved to %s\n", d.SavedTo)
+		}
+		fmt.Fprintln(w)
+	}
+
+	if len(result.Divergences) == 0 {
+		fmt.Fprintf(w, "OK (%d inputs, %d from the corpus)\n", result.Inputs, result.CorpusInputs)
+		return
+	}
+	fmt.Fprintf(w, "Inputs: %d, From corpus: %d, Divergences: %d.\n",
+		result.Inputs, result.CorpusInputs, len(result.Divergences))
+}
+
+func orNone(s string) string {
+	if s == "" {
+		return "<none>"
+	}
+	return s
+}
+
+func quoteNonEmpty(s string) string {
+	if s == "" {
+		return ""
+	}
+	return fmt.Sprintf("%q", s)
+}
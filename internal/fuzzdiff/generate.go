@@ -0,0 +1,127 @@
+package fuzzdiff
+
+import (
+	"encoding/json"
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+)
+
+// generator produces the method arguments for the parameter types.
+//
+// Half of the scalar values are picked from the lists of the values
+// that are likely to reveal the differences, like the integer
+// overflow boundaries or the strings that look like numbers.
+type generator struct {
+	rand *rand.Rand
+}
+
+func newGenerator(seed int64) *generator {
+	return &generator{rand: rand.New(rand.NewSource(seed))}
+}
+
+var (
+	interestingInts = []int64{
+		0, 1, -1, 2, 7, 255, 256, 1000,
+		math.MaxInt32, math.MinInt32, math.MaxInt32 + 1,
+		math.MaxInt64, math.MinInt64 + 1,
+	}
+
+	interestingFloats = []float64{
+		0, 0.1, 0.5, -0.5, 1.5, -2.5, 3.14159, 1e15, 1e-15, 1e100, -1e100,
+		float64(math.MaxInt64), math.SmallestNonzeroFloat64,
+	}
+
+	interestingStrings = []string{
+		"", " ", "0", "1", "-1", "0.0", "1e3", "0x1A", " 12 ", "12abc",
+		"null", "true", "false", "abc", "ABC", "Hello, World",
+		"ünïcödé", "日本語", "\x00", "\t\n", "<b>&amp;</b>", `a\b"c'd`,
+		strings.Repeat("x", 300),
+	}
+)
+
+// args generates the arguments list for the method.
+// The result is encoded as JSON.
+func (g *generator) args(params []methodParam) string {
+	values := make([]interface{}, len(params))
+	for i, p := range params {
+		values[i] = g.value(p.typ)
+	}
+	data, err := json.Marshal(values)
+	if err != nil {
+		panic(err) // Should never happen: all values are encodable
+	}
+	return string(data)
+}
+
+func (g *generator) value(t *typeSpec) interface{} {
+	if t.nullable && g.rand.Intn(8) == 0 {
+		return nil
+	}
+
+	switch t.kind {
+	case typeInt:
+		if g.rand.Intn(2) == 0 {
+			return interestingInts[g.rand.Intn(len(interestingInts))]
+		}
+		if g.rand.Intn(2) == 0 {
+			return g.rand.Int63n(2001) - 1000
+		}
+		return int64(g.rand.Uint64())
+
+	case typeFloat:
+		if g.rand.Intn(2) == 0 {
+			return phpFloat(interestingFloats[g.rand.Intn(len(interestingFloats))])
+		}
+		return phpFloat(g.rand.NormFloat64() * 1000)
+
+	case typeString:
+		if g.rand.Intn(2) == 0 {
+			return interestingStrings[g.rand.Intn(len(interestingStrings))]
+		}
+		var sb strings.Builder
+		n := g.rand.Intn(17)
+		for i := 0; i < n; i++ {
+			// Printable ASCII characters.
+			sb.WriteByte(byte(' ' + g.rand.Intn('~'-' '+1)))
+		}
+		return sb.String()
+
+	case typeBool:
+		return g.rand.Intn(2) == 0
+
+	case typeArray:
+		elems := make([]interface{}, g.rand.Intn(6))
+		for i := range elems {
+			elems[i] = g.value(t.elem)
+		}
+		return elems
+
+	default:
+		switch g.rand.Intn(5) {
+		case 0:
+			return g.value(&typeSpec{kind: typeInt})
+		case 1:
+			return g.value(&typeSpec{kind: typeFloat})
+		case 2:
+			return g.value(&typeSpec{kind: typeString})
+		case 3:
+			return g.value(&typeSpec{kind: typeBool})
+		default:
+			return nil
+		}
+	}
+}
+
+// phpFloat is a float that is always decoded as a float by json_decode.
+// By default, the integral floats are encoded without a fraction part.
+type phpFloat float64
+
+func (f phpFloat) MarshalJSON() ([]byte, error) {
+	s := strconv.FormatFloat(float64(f), 'g', -1, 64)
+	if !strings.ContainsAny(s, ".e") {
+		s += ".0"
+	}
+	return []byte(s), nil
+}
@@ -0,0 +1,91 @@
+package fuzzdiff
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestGenerator(t *testing.T) {
+	params := []methodParam{
+		{name: "i", typ: &typeSpec{kind: typeInt}},
+		{name: "f", typ: &typeSpec{kind: typeFloat}},
+		{name: "s", typ: &typeSpec{kind: typeString, nullable: true}},
+		{name: "b", typ: &typeSpec{kind: typeBool}},
+		{name: "a", typ: &typeSpec{kind: typeArray, elem: &typeSpec{kind: typeInt}}},
+		{name: "m", typ: &typeSpec{kind: typeMixed}},
+	}
+
+	gen := newGenerator(1)
+	other := newGenerator(1)
+	sawNull := false
+	for i := 0; i < 500; i++ {
+		input := gen.args(params)
+		if otherInput := other.args(params); otherInput != input {
+			t.Fatalf("same seed, different inputs:\n%s\n%s", input, otherInput)
+		}
+
+		dec := json.NewDecoder(strings.NewReader(input))
+		dec.UseNumber()
+		var values []interface{}
+		if err := dec.Decode(&values); err != nil {
+			t.Fatalf("%s: %v", input, err)
+		}
+		if len(values) != len(params) {
+			t.Fatalf("%s: expected %d values", input, len(params))
+		}
+		if n, ok := values[0].(json.Number); !ok || strings.ContainsAny(string(n), ".e") {
+			t.Errorf("%s: $i is not an int", input)
+		}
+		// json_decode must see an integral float as a float, not as an int.
+		if n, ok := values[1].(json.Number); !ok || !strings.ContainsAny(string(n), ".e") {
+			t.Errorf("%s: $f is not a float", input)
+		}
+		if values[2] == nil {
+			sawNull = true
+		} else if _, ok := values[2].(string); !ok {
+			t.Errorf("%s: $s is not a string", input)
+		}
+		if _, ok := values[3].(bool); !ok {
+			t.Errorf("%s: $b is not a bool", input)
+		}
+		elems, ok := values[4].([]interface{})
+		if !ok {
+			t.Errorf("%s: $a is not an array", input)
+		}
+		for _, elem := range elems {
+			if n, ok := elem.(json.Number); !ok || strings.ContainsAny(string(n), ".e") {
+				t.Errorf("%s: $a element is not an int", input)
+			}
+		}
+	}
+	if !sawNull {
+		t.Errorf("no nulls generated for a nullable param")
+	}
+
+	if newGenerator(1).args(params) == newGenerator(2).args(params) {
+		t.Errorf("different seeds, same inputs")
+	}
+}
+
+func TestPHPFloat(t *testing.T) {
+	tests := []struct {
+		f    float64
+		want string
+	}{
+		{0, "0.0"},
+		{-3, "-3.0"},
+		{1.5, "1.5"},
+		{1e100, "1e+100"},
+	}
+
+	for _, test := range tests {
+		data, err := json.Marshal(phpFloat(test.f))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != test.want {
+			t.Errorf("phpFloat(%v): have %s, want %s", test.f, data, test.want)
+		}
+	}
+}
@@ -0,0 +1,489 @@
+package fuzzdiff
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/VKCOM/php-parser/pkg/version"
+	"github.com/VKCOM/php-parser/pkg/visitor/traverser"
+	"github.com/quasilyte/ktest/internal/fileutil"
+	"github.com/quasilyte/ktest/internal/phpsyntax"
+	"github.com/quasilyte/ktest/internal/phpunit"
+)
+
+// inputEnvVar is an env variable that holds the input file path
+// for the generated main.
+const inputEnvVar = "KTEST_FUZZ_INPUT"
+
+type runner struct {
+	conf *RunConfig
+
+	// ctx is used to interrupt the build and run commands.
+	ctx context.Context
+
+	phpVersion *version.Version
+
+	method *methodInfo
+
+	composerMode bool
+
+	buildDir     string
+	mainFilename string
+
+	corpus []string
+
+	result *Result
+}
+
+func newRunner(conf *RunConfig) *runner {
+	return &runner{
+		conf:   conf,
+		result: &Result{},
+	}
+}
+
+func (r *runner) debugf(format string, args ...interface{}) {
+	if r.conf.DebugPrint != nil {
+		r.conf.DebugPrint(fmt.Sprintf(format, args...))
+	}
+}
+
+func (r *runner) Run(ctx context.Context) (*Result, error) {
+	r.ctx = ctx
+	defer func() {
+		if r.buildDir == "" || r.conf.NoCleanup {
+			return
+		}
+		if err := os.RemoveAll(r.buildDir); err != nil {
+			log.Printf("remove temp build dir: %v", err)
+		}
+	}()
+
+	steps := []struct {
+		name string
+		fn   func() error
+	}{
+		{"parse php version", r.stepParsePHPVersion},
+		{"find target method", r.stepFindTargetMethod},
+		{"prepare temp build dir", r.stepPrepareTempBuildDir},
+		{"generate fuzz main", r.stepGenerateFuzzMain},
+		{"load corpus", r.stepLoadCorpus},
+		{"build kphp binary", r.stepBuildKphpBinary},
+		{"run inputs", r.stepRunInputs},
+	}
+
+	start := time.Now()
+	for _, step := range steps {
+		if err := step.fn(); err != nil {
+			return nil, fmt.Errorf("%s: %w", step.name, err)
+		}
+	}
+	r.result.Time = time.Since(start)
+
+	return r.result, nil
+}
+
+func (r *runner) stepParsePHPVersion() error {
+	v, err := phpsyntax.ParseVersion(r.conf.PHPVersion)
+	if err != nil {
+		return err
+	}
+	r.phpVersion = v
+	return nil
+}
+
+func (r *runner) stepFindTargetMethod() error {
+	parts := strings.Split(r.conf.Target, "::")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return fmt.Errorf("invalid target %q: expected Class::method", r.conf.Target)
+	}
+	className := strings.TrimPrefix(parts[0], `\`)
+	methodName := parts[1]
+
+	srcDir := filepath.Join(r.conf.ProjectRoot, r.conf.SrcDir)
+	err := filepath.Walk(srcDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if r.method != nil || info.IsDir() || !strings.HasSuffix(path, ".php") {
+			return nil
+		}
+		src, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		rootNode, parserErrors := phpsyntax.Parse(src, r.phpVersion)
+		if len(parserErrors) != 0 {
+			r.debugf("%s: skipping a file with parse errors: %v", path, parserErrors[0])
+			return nil
+		}
+		visitor := &methodVisitor{
+			src:        src,
+			className:  className,
+			methodName: methodName,
+		}
+		traverser.NewTraverser(visitor).Traverse(rootNode)
+		if visitor.err != nil {
+			return fmt.Errorf("%s: %v", r.conf.Target, visitor.err)
+		}
+		if visitor.out != nil {
+			visitor.out.filename = path
+			r.method = visitor.out
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if r.method == nil {
+		return fmt.Errorf("can't find %s method declaration inside %s", r.conf.Target, srcDir)
+	}
+
+	r.debugf("target method: %s::%s declared in %q", r.method.className, r.method.name, r.method.filename)
+	for _, p := range r.method.params {
+		r.debugf("param $%s: %s", p.name, p.typ)
+	}
+
+	return nil
+}
+
+func (r *runner) stepPrepareTempBuildDir() error {
+	tempDir, err := ioutil.TempDir("", "kphpfuzzdiff-build")
+	if err != nil {
+		return err
+	}
+	r.buildDir = tempDir
+	r.debugf("temp build dir: %q", tempDir)
+
+	return nil
+}
+
+type fuzzHelper struct {
+	Name string
+	Conv string
+}
+
+func (r *runner) stepGenerateFuzzMain() error {
+	r.composerMode = fileutil.FileExists(filepath.Join(r.conf.ProjectRoot, "composer.json"))
+
+	var helpers []fuzzHelper
+	args := make([]string, len(r.method.params))
+	for i, p := range r.method.params {
+		args[i] = argExpr(p.typ, fmt.Sprintf("$args[%d]", i), &helpers)
+	}
+	call := fmt.Sprintf(`\%s::%s(%s)`, r.method.className, r.method.name, strings.Join(args, ", "))
+	if !r.method.static {
+		call = fmt.Sprintf(`(new \%s())->%s(%s)`, r.method.className, r.method.name, strings.Join(args, ", "))
+	}
+
+	runtimeFilename := filepath.Join(r.buildDir, "ktest", "runtime.php")
+	if err := fileutil.WriteFile(runtimeFilename, []byte(phpunit.RuntimeSource())); err != nil {
+		return err
+	}
+
+	templateData := map[string]interface{}{
+		"RuntimeFilename": runtimeFilename,
+		"Filename":        r.method.filename,
+		"InputEnvVar":     inputEnvVar,
+		"Helpers":         helpers,
+		"Call":            call,
+		"Void":            r.method.void,
+	}
+	if r.composerMode {
+		templateData["Bootstrap"] = filepath.Join(r.conf.ProjectRoot, "vendor", "autoload.php")
+	}
+	var generated bytes.Buffer
+	if err := fuzzMainTemplate.Execute(&generated, templateData); err != nil {
+		return err
+	}
+
+	r.mainFilename = filepath.Join(r.buildDir, "main.php")
+	return fileutil.WriteFile(r.mainFilename, generated.Bytes())
+}
+
+// argExpr returns an expression that converts the decoded
+// JSON value v to the parameter type t.
+// The array conversion functions are added to the helpers.
+func argExpr(t *typeSpec, v string, helpers *[]fuzzHelper) string {
+	var conv string
+	switch t.kind {
+	case typeInt:
+		conv = "(int)" + v
+	case typeFloat:
+		conv = "(float)" + v
+	case typeString:
+		conv = "(string)" + v
+	case typeBool:
+		conv = "(bool)" + v
+	case typeArray:
+		if t.elem.kind == typeMixed {
+			conv = "(array)" + v
+			break
+		}
+		name := fmt.Sprintf("__fuzz_array%d", len(*helpers))
+		elemConv := argExpr(t.elem, "$elem", helpers)
+		*helpers = append(*helpers, fuzzHelper{Name: name, Conv: elemConv})
+		conv = name + "(" + v + ")"
+	default:
+		return v
+	}
+	if t.nullable {
+		return fmt.Sprintf("(%s === null ? null : %s)", v, conv)
+	}
+	return conv
+}
+
+// fuzzMainTemplate is a main file for both PHP and KPHP.
+// It evaluates the target method with the arguments read from the input file
+// and prints the results encoded by the KTest runtime as JSON.
+var fuzzMainTemplate = template.Must(template.New("fuzz_main").Parse(`<?php
+
+{{if .Bootstrap}}
+require_once '{{.Bootstrap}}';
+{{end}}
+
+require_once '{{.RuntimeFilename}}';
+require_once '{{.Filename}}';
+
+{{range $.Helpers}}
+/**
+ * @param mixed $v
+ */
+function {{.Name}}($v) {
+  $out = [];
+  foreach ($v as $key => $elem) {
+    $out[$key] = {{.Conv}};
+  }
+  return $out;
+}
+{{end}}
+
+function __fuzz_main() {
+  $args = json_decode((string)file_get_contents((string)getenv('{{.InputEnvVar}}')), true);
+  $result = null;
+  $exception = '';
+  ob_start();
+  try {
+    {{if not .Void}}$result = {{end}}{{.Call}};
+  } catch (\Exception $e) {
+    $exception = get_class($e) . ': ' . $e->getMessage();
+  }
+  $output = (string)ob_get_clean();
+  echo json_encode([
+    'result' => \KTest\Runtime::encode($result),
+    'output' => \KTest\Runtime::encode($output),
+    'exception' => \KTest\Runtime::encode($exception),
+  ]) . "\n";
+}
+
+__fuzz_main();
+`))
+
+func (r *runner) stepLoadCorpus() error {
+	if r.conf.CorpusDir == "" {
+		name := strings.ReplaceAll(r.method.className, `\`, ".") + "." + r.method.name
+		r.conf.CorpusDir = filepath.Join(r.conf.ProjectRoot, "testdata", "fuzz-diff", name)
+	}
+
+	files, err := ioutil.ReadDir(r.conf.CorpusDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	for _, f := range files {
+		if f.IsDir() || !strings.HasSuffix(f.Name(), ".json") {
+			continue
+		}
+		data, err := ioutil.ReadFile(filepath.Join(r.conf.CorpusDir, f.Name()))
+		if err != nil {
+			return err
+		}
+		r.corpus = append(r.corpus, strings.TrimSpace(string(data)))
+	}
+	r.debugf("loaded %d corpus inputs from %q", len(r.corpus), r.conf.CorpusDir)
+
+	return nil
+}
+
+func (r *runner) stepBuildKphpBinary() error {
+	args := []string{
+		"--mode", "cli",
+		"--destination-directory", r.buildDir,
+	}
+	if r.composerMode {
+		args = append(args, "--composer-root", r.conf.ProjectRoot)
+	}
+	args = append(args, r.mainFilename)
+	buildCommand := exec.CommandContext(r.ctx, r.conf.KphpCommand, args...)
+	buildCommand.Dir = r.buildDir
+	out, err := buildCommand.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%v: %s", err, out)
+	}
+	return nil
+}
+
+func (r *runner) stepRunInputs() error {
+	fmt.Fprintf(r.conf.Output, "fuzzing %s::%s with %d corpus and %d generated inputs\n",
+		r.method.className, r.method.name, len(r.corpus), r.conf.Count)
+
+	inputFilename := filepath.Join(r.buildDir, "input.json")
+	gen := newGenerator(r.conf.Seed)
+	for i := 0; i < len(r.corpus)+r.conf.Count; i++ {
+		fromCorpus := i < len(r.corpus)
+		var input string
+		if fromCorpus {
+			input = r.corpus[i]
+		} else {
+			input = gen.args(r.method.params)
+		}
+		r.debugf("input %d: %s", i, input)
+
+		if err := fileutil.WriteFile(inputFilename, []byte(input)); err != nil {
+			return err
+		}
+		phpOutcome, err := r.evaluate(inputFilename, r.conf.PhpCommand,
+			"-d", "display_errors=stderr",
+			"-d", "log_errors=0",
+			"-d", "error_reporting=E_ALL & ~E_DEPRECATED & ~E_USER_DEPRECATED & ~E_STRICT",
+			"-f", r.mainFilename)
+		if err != nil {
+			return err
+		}
+		kphpOutcome, err := r.evaluate(inputFilename, filepath.Join(r.buildDir, "cli"))
+		if err != nil {
+			return err
+		}
+
+		r.result.Inputs++
+		if fromCorpus {
+			r.result.CorpusInputs++
+		}
+		if !phpOutcome.diverges(kphpOutcome) {
+			continue
+		}
+		d := Divergence{
+			Input: input,
+			PHP:   *phpOutcome,
+			KPHP:  *kphpOutcome,
+		}
+		if !fromCorpus {
+			savedTo, err := r.saveInput(input)
+			if err != nil {
+				return err
+			}
+			d.SavedTo = savedTo
+		}
+		r.result.Divergences = append(r.result.Divergences, d)
+	}
+
+	return nil
+}
+
+// saveInput adds the input to the corpus.
+// The returned file name is relative to the project root if possible.
+func (r *runner) saveInput(input string) (string, error) {
+	hash := sha1.Sum([]byte(input))
+	filename := filepath.Join(r.conf.CorpusDir, hex.EncodeToString(hash[:8])+".json")
+	if err := fileutil.WriteFile(filename, []byte(input+"\n")); err != nil {
+		return "", err
+	}
+	if rel, err := filepath.Rel(r.conf.ProjectRoot, filename); err == nil {
+		return rel, nil
+	}
+	return filename, nil
+}
+
+// evaluate runs the command that executes the fuzz main.
+// The non-zero exit status and the timeout are reported as a crash, not as an error.
+func (r *runner) evaluate(inputFilename, command string, args ...string) (*Outcome, error) {
+	ctx := r.ctx
+	if r.conf.TimeoutPerInput != 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, r.conf.TimeoutPerInput)
+		defer cancel()
+	}
+	cmd := exec.CommandContext(ctx, command, args...)
+	cmd.Dir = r.buildDir
+	cmd.Env = append(os.Environ(), inputEnvVar+"="+inputFilename)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	runErr := cmd.Run()
+	if r.ctx.Err() != nil {
+		return nil, r.ctx.Err()
+	}
+	outcome := &Outcome{
+		Warnings: strings.TrimSpace(stderr.String()),
+	}
+	if ctx.Err() != nil {
+		// Only one side timing out is a divergence,
+		// the crash messages themselves are not compared.
+		outcome.Crash = fmt.Sprintf("timed out after %s", r.conf.TimeoutPerInput)
+		return outcome, nil
+	}
+	if _, ok := runErr.(*exec.ExitError); runErr != nil && !ok {
+		return nil, runErr
+	}
+	if err := decodeOutcome(stdout.Bytes(), outcome); err != nil {
+		if runErr == nil {
+			runErr = fmt.Errorf("malformed output: %v", err)
+		}
+		outcome.Crash = runErr.Error()
+		return outcome, nil
+	}
+	if runErr != nil {
+		outcome.Crash = runErr.Error()
+	}
+	return outcome, nil
+}
+
+func (o *Outcome) diverges(other *Outcome) bool {
+	return o.Result != other.Result ||
+		o.Output != other.Output ||
+		o.Exception != other.Exception ||
+		(o.Warnings == "") != (other.Warnings == "") ||
+		(o.Crash == "") != (other.Crash == "")
+}
+
+// decodeOutcome decodes the fuzz main output.
+// The result is exported the way PHPUnit prints the values,
+// so the 1 vs 1.0 vs '1' differences are visible.
+func decodeOutcome(data []byte, outcome *Outcome) error {
+	var out struct {
+		Result    json.RawMessage `json:"result"`
+		Output    json.RawMessage `json:"output"`
+		Exception json.RawMessage `json:"exception"`
+	}
+	if err := json.Unmarshal(data, &out); err != nil {
+		return err
+	}
+	var err error
+	outcome.Result, err = phpunit.ExportTypedValue(out.Result)
+	if err != nil {
+		return fmt.Errorf("result: %v", err)
+	}
+	outcome.Output, err = phpunit.DecodeTypedString(out.Output)
+	if err != nil {
+		return fmt.Errorf("output: %v", err)
+	}
+	outcome.Exception, err = phpunit.DecodeTypedString(out.Exception)
+	if err != nil {
+		return fmt.Errorf("exception: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,137 @@
+package fuzzdiff
+
+import (
+	"context"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestArgExpr(t *testing.T) {
+	tests := []struct {
+		typ     string
+		want    string
+		helpers string
+	}{
+		{"int", "(int)$x", ""},
+		{"?float", "($x === null ? null : (float)$x)", ""},
+		{"string", "(string)$x", ""},
+		{"bool", "(bool)$x", ""},
+		{"mixed", "$x", ""},
+		{"array", "(array)$x", ""},
+		{"?array", "($x === null ? null : (array)$x)", ""},
+		{"int[]", "__fuzz_array0($x)", "__fuzz_array0: (int)$elem"},
+		{"?string[]", "($x === null ? null : __fuzz_array0($x))", "__fuzz_array0: (string)$elem"},
+		{"mixed[]", "(array)$x", ""},
+	}
+
+	for _, test := range tests {
+		typ, err := parseType(test.typ)
+		if err != nil {
+			t.Fatalf("parseType(%q): %v", test.typ, err)
+		}
+		var helpers []fuzzHelper
+		have := argExpr(typ, "$x", &helpers)
+		if have != test.want {
+			t.Errorf("argExpr(%s): have %s, want %s", test.typ, have, test.want)
+		}
+		helperStrings := make([]string, len(helpers))
+		for i, h := range helpers {
+			helperStrings[i] = h.Name + ": " + h.Conv
+		}
+		if have := strings.Join(helperStrings, "; "); have != test.helpers {
+			t.Errorf("argExpr(%s): helpers mismatch:\nhave: %s\nwant: %s", test.typ, have, test.helpers)
+		}
+	}
+}
+
+func TestDecodeOutcome(t *testing.T) {
+	tests := []struct {
+		output    string
+		result    string
+		text      string
+		exception string
+		wantErr   string
+	}{
+		{
+			output: `{"result":["null"],"output":["string",""],"exception":["string",""]}`,
+			result: "null",
+		},
+		{
+			output: `{"result":["int","1"],"output":["string","a\nb"],"exception":["string","Exception: x"]}`,
+			result: "1", text: "a\nb", exception: "Exception: x",
+		},
+		{
+			output: `{"result":["float","1.0"],"output":["bytes","/w=="],"exception":["string",""]}`,
+			result: "1.0", text: "\xff",
+		},
+		{
+			output: `{"result":["string","1"],"output":["string",""],"exception":["string",""]}`,
+			result: "'1'",
+		},
+		{
+			output: `{"result":["array",[[["int","0"],["bool","true"]],[["string","k"],["array",[]]]]],"output":["string",""],"exception":["string",""]}`,
+			result: "Array &0 (\n    0 => true\n    'k' => Array &1 ()\n)",
+		},
+		{
+			output:  `{"result":["null"],"output":["int","1"],"exception":["string",""]}`,
+			wantErr: "output: expected a string, found 1",
+		},
+		{
+			output:  `{"result":["void","x"],"output":["string",""],"exception":["string",""]}`,
+			wantErr: `result: unexpected type tag "void"`,
+		},
+		{
+			output:  `Fatal error`,
+			wantErr: "invalid character 'F' looking for beginning of value",
+		},
+	}
+
+	for _, test := range tests {
+		var outcome Outcome
+		err := decodeOutcome([]byte(test.output), &outcome)
+		if test.wantErr != "" {
+			if err == nil || err.Error() != test.wantErr {
+				t.Errorf("%s: error mismatch:\nhave: %v\nwant: %s", test.output, err, test.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.output, err)
+			continue
+		}
+		want := Outcome{Result: test.result, Output: test.text, Exception: test.exception}
+		if outcome != want {
+			t.Errorf("%s: outcome mismatch:\nhave: %#v\nwant: %#v", test.output, outcome, want)
+		}
+	}
+}
+
+func TestEvaluateTimeout(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh is not available")
+	}
+
+	r := &runner{
+		conf: &RunConfig{TimeoutPerInput: 100 * time.Millisecond},
+		ctx:  context.Background(),
+	}
+	slow, err := r.evaluate("", "sh", "-c", "exec sleep 5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if slow.Crash != "timed out after 100ms" {
+		t.Errorf("unexpected slow outcome: %#v", slow)
+	}
+	fast, err := r.evaluate("", "sh", "-c", `echo '{"result":["null"],"output":["string",""],"exception":["string",""]}'`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fast.Crash != "" || fast.Result != "null" {
+		t.Errorf("unexpected fast outcome: %#v", fast)
+	}
+	if !slow.diverges(fast) {
+		t.Errorf("a timeout on one side is not reported as a divergence")
+	}
+}
@@ -0,0 +1,298 @@
+package fuzzdiff
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/VKCOM/php-parser/pkg/ast"
+	"github.com/VKCOM/php-parser/pkg/visitor"
+)
+
+type typeKind int
+
+const (
+	typeMixed typeKind = iota
+	typeInt
+	typeFloat
+	typeString
+	typeBool
+	typeArray
+)
+
+// typeSpec is a parameter type the inputs are generated for.
+type typeSpec struct {
+	kind     typeKind
+	nullable bool
+
+	// elem is an array element type.
+	// Only the arrays of the scalar and mixed values are supported.
+	elem *typeSpec
+}
+
+func (t *typeSpec) String() string {
+	var s string
+	switch t.kind {
+	case typeInt:
+		s = "int"
+	case typeFloat:
+		s = "float"
+	case typeString:
+		s = "string"
+	case typeBool:
+		s = "bool"
+	case typeArray:
+		s = t.elem.String() + "[]"
+	default:
+		s = "mixed"
+	}
+	if t.nullable {
+		return "?" + s
+	}
+	return s
+}
+
+// parseType parses a phpdoc or a type hint type.
+// Supported forms are T, ?T, T|null, T[] and array.
+func parseType(s string) (*typeSpec, error) {
+	s = strings.TrimSpace(s)
+	if strings.HasPrefix(s, "?") {
+		t, err := parseType(s[1:])
+		if err != nil {
+			return nil, err
+		}
+		t.nullable = true
+		return t, nil
+	}
+	if parts := strings.Split(s, "|"); len(parts) == 2 {
+		for i, p := range parts {
+			if strings.EqualFold(p, "null") {
+				return parseType("?" + parts[1-i])
+			}
+		}
+	}
+	if strings.HasSuffix(s, "[]") {
+		elem, err := parseType(strings.TrimSuffix(s, "[]"))
+		if err != nil {
+			return nil, err
+		}
+		if elem.kind == typeArray {
+			return nil, fmt.Errorf("%s: nested arrays are not supported", s)
+		}
+		return &typeSpec{kind: typeArray, elem: elem}, nil
+	}
+	switch strings.ToLower(s) {
+	case "int", "integer":
+		return &typeSpec{kind: typeInt}, nil
+	case "float", "double":
+		return &typeSpec{kind: typeFloat}, nil
+	case "string":
+		return &typeSpec{kind: typeString}, nil
+	case "bool", "boolean":
+		return &typeSpec{kind: typeBool}, nil
+	case "array":
+		return &typeSpec{kind: typeArray, elem: &typeSpec{kind: typeMixed}}, nil
+	case "mixed":
+		return &typeSpec{kind: typeMixed}, nil
+	}
+	return nil, fmt.Errorf("%s: unsupported type", s)
+}
+
+type methodParam struct {
+	name string
+	typ  *typeSpec
+}
+
+// methodInfo describes the fuzzing target method.
+type methodInfo struct {
+	// className is a fully qualified class name without a leading slash.
+	className string
+	name      string
+
+	filename string
+	static   bool
+	void     bool
+
+	params []methodParam
+}
+
+// methodVisitor finds the target method declaration.
+type methodVisitor struct {
+	visitor.Null
+
+	src []byte
+
+	// className is matched against both fully qualified and short class names.
+	className  string
+	methodName string
+
+	namespace    string
+	currentClass string
+
+	// classAbstract and ctorParams describe the current class,
+	// they're used to check that the instance method can be called.
+	classAbstract bool
+	ctorParams    []ast.Vertex
+
+	out *methodInfo
+	err error
+}
+
+func (v *methodVisitor) StmtNamespace(n *ast.StmtNamespace) {
+	v.namespace = ""
+	if name, ok := n.Name.(*ast.Name); ok {
+		v.namespace = typeName(name)
+	}
+}
+
+func (v *methodVisitor) StmtClass(n *ast.StmtClass) {
+	v.currentClass = ""
+	ident, ok := n.Name.(*ast.Identifier)
+	if !ok {
+		return
+	}
+	fqn := string(ident.Value)
+	if v.namespace != "" {
+		fqn = v.namespace + `\` + fqn
+	}
+	if !strings.EqualFold(fqn, v.className) && !strings.EqualFold(string(ident.Value), v.className) {
+		return
+	}
+	v.currentClass = fqn
+	v.classAbstract = hasModifier(n.Modifiers, "abstract")
+	v.ctorParams = nil
+	for _, stmt := range n.Stmts {
+		m, ok := stmt.(*ast.StmtClassMethod)
+		if !ok {
+			continue
+		}
+		if ident, ok := m.Name.(*ast.Identifier); ok && strings.EqualFold(string(ident.Value), "__construct") {
+			v.ctorParams = m.Params
+		}
+	}
+}
+
+func (v *methodVisitor) StmtClassMethod(n *ast.StmtClassMethod) {
+	if v.currentClass == "" || v.out != nil {
+		return
+	}
+	ident, ok := n.Name.(*ast.Identifier)
+	if !ok || !strings.EqualFold(string(ident.Value), v.methodName) {
+		return
+	}
+
+	info := &methodInfo{
+		className: v.currentClass,
+		name:      string(ident.Value),
+	}
+	info.static = hasModifier(n.Modifiers, "static")
+
+	// The generated main calls the method from the outside,
+	// creating an object for the instance methods.
+	for _, visibility := range []string{"private", "protected"} {
+		if hasModifier(n.Modifiers, visibility) {
+			v.err = fmt.Errorf("can't call a %s method, only the public methods are supported", visibility)
+			return
+		}
+	}
+	if !info.static {
+		if v.classAbstract {
+			v.err = fmt.Errorf("can't call an instance method of the abstract class, only the static methods are supported")
+			return
+		}
+		for _, p := range v.ctorParams {
+			if p := p.(*ast.Parameter); p.DefaultValue == nil && p.VariadicTkn == nil {
+				v.err = fmt.Errorf("can't call an instance method: the constructor requires arguments, only the static methods are supported")
+				return
+			}
+		}
+	}
+
+	doc := v.phpdoc(n.Position.StartPos)
+	docParams := make(map[string]string)
+	for _, m := range phpdocParamRegexp.FindAllStringSubmatch(doc, -1) {
+		docParams[m[2]] = m[1]
+	}
+	if m := phpdocReturnRegexp.FindStringSubmatch(doc); m != nil && strings.EqualFold(m[1], "void") {
+		info.void = true
+	}
+	if n.ReturnType != nil && strings.EqualFold(typeName(n.ReturnType), "void") {
+		info.void = true
+	}
+
+	for _, p := range n.Params {
+		p := p.(*ast.Parameter)
+		name := string(p.Var.(*ast.ExprVariable).Name.(*ast.Identifier).Value)
+		name = strings.TrimPrefix(name, "$")
+		if p.VariadicTkn != nil || p.AmpersandTkn != nil {
+			v.err = fmt.Errorf("$%s: variadic and by-reference parameters are not supported", name)
+			return
+		}
+		// The phpdoc types are more precise than the type hints, like int[] vs array.
+		typeString := docParams[name]
+		if typeString == "" && p.Type != nil {
+			typeString = typeName(p.Type)
+		}
+		if typeString == "" {
+			typeString = "mixed"
+		}
+		typ, err := parseType(typeString)
+		if err != nil {
+			v.err = fmt.Errorf("$%s: %v", name, err)
+			return
+		}
+		info.params = append(info.params, methodParam{name: name, typ: typ})
+	}
+
+	v.out = info
+}
+
+func hasModifier(modifiers []ast.Vertex, name string) bool {
+	for _, m := range modifiers {
+		if m, ok := m.(*ast.Identifier); ok && strings.EqualFold(string(m.Value), name) {
+			return true
+		}
+	}
+	return false
+}
+
+var (
+	phpdocParamRegexp  = regexp.MustCompile(`@param\s+(\S+)\s+\$(\w+)`)
+	phpdocReturnRegexp = regexp.MustCompile(`@return\s+(\S+)`)
+)
+
+// phpdoc returns a phpdoc comment that precedes the declaration.
+func (v *methodVisitor) phpdoc(declStart int) string {
+	before := bytes.TrimRight(v.src[:declStart], " \t\r\n")
+	if !bytes.HasSuffix(before, []byte("*/")) {
+		return ""
+	}
+	start := bytes.LastIndex(before, []byte("/**"))
+	if start == -1 {
+		return ""
+	}
+	return string(before[start:])
+}
+
+func typeName(n ast.Vertex) string {
+	switch n := n.(type) {
+	case *ast.Nullable:
+		return "?" + typeName(n.Expr)
+	case *ast.Identifier:
+		return string(n.Value)
+	case *ast.Name:
+		return namePartsToString(n.Parts)
+	case *ast.NameFullyQualified:
+		return namePartsToString(n.Parts)
+	}
+	return ""
+}
+
+func namePartsToString(parts []ast.Vertex) string {
+	s := make([]string, len(parts))
+	for i, p := range parts {
+		s[i] = string(p.(*ast.NamePart).Value)
+	}
+	return strings.Join(s, `\`)
+}
@@ -0,0 +1,137 @@
+package fuzzdiff
+
+import (
+	"testing"
+
+	"github.com/VKCOM/php-parser/pkg/visitor/traverser"
+	"github.com/quasilyte/ktest/internal/phpsyntax"
+)
+
+func TestMethodVisitor(t *testing.T) {
+	src := []byte(`<?php
+namespace App\Util;
+
+class Strings {
+  /**
+   * @param int[] $codes
+   * @param string|null $sep
+   */
+  public static function join(array $codes, $sep, bool $upper = false): string {
+    return '';
+  }
+
+  public function log(?float $x, $any): void {}
+}
+`)
+
+	tests := []struct {
+		className  string
+		methodName string
+		static     bool
+		void       bool
+		params     string
+	}{
+		{`App\Util\Strings`, "join", true, false, "int[] ?string bool"},
+		{`Strings`, "join", true, false, "int[] ?string bool"},
+		{`Strings`, "log", false, true, "?float mixed"},
+	}
+
+	v, err := phpsyntax.ParseVersion("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rootNode, parserErrors := phpsyntax.Parse(src, v)
+	if len(parserErrors) != 0 {
+		t.Fatal(parserErrors[0])
+	}
+	for _, test := range tests {
+		visitor := &methodVisitor{
+			src:        src,
+			className:  test.className,
+			methodName: test.methodName,
+		}
+		traverser.NewTraverser(visitor).Traverse(rootNode)
+		if visitor.err != nil {
+			t.Fatalf("%s::%s: %v", test.className, test.methodName, visitor.err)
+		}
+		m := visitor.out
+		if m == nil {
+			t.Fatalf("%s::%s: method not found", test.className, test.methodName)
+		}
+		if m.className != `App\Util\Strings` || m.static != test.static || m.void != test.void {
+			t.Errorf("%s::%s: unexpected method info: %+v", test.className, test.methodName, m)
+		}
+		params := ""
+		for i, p := range m.params {
+			if i != 0 {
+				params += " "
+			}
+			params += p.typ.String()
+		}
+		if params != test.params {
+			t.Errorf("%s::%s: params mismatch:\nhave: %s\nwant: %s", test.className, test.methodName, params, test.params)
+		}
+	}
+}
+
+func TestParseTypeUnsupported(t *testing.T) {
+	for _, typ := range []string{"Foo", "int[][]", "int|string", "callable"} {
+		if _, err := parseType(typ); err == nil {
+			t.Errorf("parseType(%q): expected an error", typ)
+		}
+	}
+}
+
+func TestMethodVisitorUncallable(t *testing.T) {
+	tests := []struct {
+		src     string
+		wantErr string
+	}{
+		{
+			`class C { private function f() {} }`,
+			"can't call a private method, only the public methods are supported",
+		},
+		{
+			`class C { protected static function f() {} }`,
+			"can't call a protected method, only the public methods are supported",
+		},
+		{
+			`abstract class C { public function f() {} }`,
+			"can't call an instance method of the abstract class, only the static methods are supported",
+		},
+		{
+			`class C { public function f() {} public function __construct($x, $y = 1) {} }`,
+			"can't call an instance method: the constructor requires arguments, only the static methods are supported",
+		},
+		{`abstract class C { public static function f() {} }`, ""},
+		{`class C { public function __construct($x = 0, ...$rest) {} public function f() {} }`, ""},
+		{`class C { public static function f() {} public function __construct($x) {} }`, ""},
+	}
+
+	v, err := phpsyntax.ParseVersion("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range tests {
+		src := []byte("<?php\n" + test.src)
+		rootNode, parserErrors := phpsyntax.Parse(src, v)
+		if len(parserErrors) != 0 {
+			t.Fatalf("%s: %v", test.src, parserErrors[0])
+		}
+		visitor := &methodVisitor{
+			src:        src,
+			className:  "C",
+			methodName: "f",
+		}
+		traverser.NewTraverser(visitor).Traverse(rootNode)
+		if test.wantErr == "" {
+			if visitor.err != nil || visitor.out == nil {
+				t.Errorf("%s: unexpected result: %v", test.src, visitor.err)
+			}
+			continue
+		}
+		if visitor.err == nil || visitor.err.Error() != test.wantErr {
+			t.Errorf("%s: error mismatch:\nhave: %v\nwant: %s", test.src, visitor.err, test.wantErr)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package phpsyntax
+
+import (
+	"fmt"
+
+	"github.com/VKCOM/php-parser/pkg/ast"
+	"github.com/VKCOM/php-parser/pkg/conf"
+	"github.com/VKCOM/php-parser/pkg/errors"
+	"github.com/VKCOM/php-parser/pkg/parser"
+	"github.com/VKCOM/php-parser/pkg/version"
+)
+
+// DefaultVersion is a PHP language version that is used when it's not specified.
+const DefaultVersion = "7.4"
+
+// SupportedVersions describes the versions accepted by ParseVersion.
+// They're limited by the php-parser library, see isSupported.
+const SupportedVersions = "5.0-5.6, 7.0-7.4, 8.0"
+
+// ParseVersion parses the "major.minor" PHP language version.
+// An empty string is interpreted as DefaultVersion.
+func ParseVersion(s string) (*version.Version, error) {
+	if s == "" {
+		s = DefaultVersion
+	}
+	v, err := version.New(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid PHP version %q: expected major.minor", s)
+	}
+	if err := v.Validate(); err != nil || !isSupported(v) {
+		return nil, fmt.Errorf("unsupported PHP version %s: supported versions are %s", s, SupportedVersions)
+	}
+	return v, nil
+}
+
+// isSupported reports whether the version is listed in SupportedVersions.
+// The parser may accept the newer versions, but the tools are not ready for them.
+func isSupported(v *version.Version) bool {
+	switch v.Major {
+	case 5:
+		return v.Minor <= 6
+	case 7:
+		return v.Minor <= 4
+	case 8:
+		return v.Minor == 0
+	default:
+		return false
+	}
+}
+
+// Parse parses the PHP source using the specified language version.
+func Parse(src []byte, v *version.Version) (ast.Vertex, []*errors.Error) {
+	var parserErrors []*errors.Error
+	errorHandler := func(e *errors.Error) {
+		parserErrors = append(parserErrors, e)
+	}
+	rootNode, err := parser.Parse(src, conf.Config{
+		Version:          v,
+		ErrorHandlerFunc: errorHandler,
+	})
+	if err != nil {
+		parserErrors = append(parserErrors, errors.NewError(err.Error(), nil))
+	}
+	return rootNode, parserErrors
+}
@@ -0,0 +1,167 @@
+package phpsyntax
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/VKCOM/php-parser/pkg/ast"
+	"github.com/VKCOM/php-parser/pkg/visitor"
+	"github.com/VKCOM/php-parser/pkg/visitor/traverser"
+)
+
+func TestParseVersion(t *testing.T) {
+	tests := []struct {
+		s       string
+		want    string
+		wantErr string
+	}{
+		{s: "", want: "7.4"},
+		{s: "7.0", want: "7.0"},
+		{s: "5.6", want: "5.6"},
+		{s: "8.0", want: "8.0"},
+		{s: "8.1", wantErr: "unsupported PHP version 8.1: supported versions are 5.0-5.6, 7.0-7.4, 8.0"},
+		{s: "7.5", wantErr: "unsupported PHP version 7.5: supported versions are 5.0-5.6, 7.0-7.4, 8.0"},
+		{s: "7", wantErr: `invalid PHP version "7": expected major.minor`},
+	}
+
+	for _, test := range tests {
+		v, err := ParseVersion(test.s)
+		if test.wantErr != "" {
+			if err == nil || err.Error() != test.wantErr {
+				t.Errorf("ParseVersion(%q): error mismatch:\nhave: %v\nwant: %s", test.s, err, test.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseVersion(%q): unexpected error: %v", test.s, err)
+			continue
+		}
+		have := fmt.Sprintf("%d.%d", v.Major, v.Minor)
+		if have != test.want {
+			t.Errorf("ParseVersion(%q): have %s, want %s", test.s, have, test.want)
+		}
+	}
+}
+
+func TestParsePHP8(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{`$x = match ($a) { 1, 2 => 'low', default => 'high', };`, "match"},
+		{`$x = match ($a) {};`, "match"},
+		{`$x = $user?->getAddress()?->city;`, "nullsafe property fetch, nullsafe method call"},
+		{`f(name: 'x', 10, limit: 20);`, "named arg name, named arg limit"},
+		{`f(array: [], class: 1);`, "named arg array, named arg class"},
+		{`class P { function __construct(public int $x, private ?string $y = null,) {} }`, "promoted param $x, promoted param $y"},
+		{`#[Pure] function f() {}`, "attribute Pure"},
+		{`#[A(1), B] #[C(x: 2)] class C { #[D] public $p; #[E] const X = 1; #[F] function m(#[G] $a) {} }`,
+			"attribute A, attribute B, attribute C, named arg x, attribute D, attribute E, attribute F, attribute G"},
+		{`$f = #[H] fn($x) => $x; $g = #[I] static function() use ($f,) {};`, "attribute H, attribute I"},
+		{`$o = new #[J] class {};`, "attribute J"},
+		{`function f(int|string|null $x): static { return $this; }`, "union of 3 types"},
+		{`$x = $y ?? throw new Exception();`, "throw expression"},
+		{`throw new Exception();`, ""},
+		{`try {} catch (Exception) {}`, ""},
+		{`# comment
+$x = 1; // #[NotAnAttribute]`, ""},
+		{`$x->match(1); X::match(2); $y = Foo::MATCH;`, ""},
+	}
+
+	v80, err := ParseVersion("8.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	v74, err := ParseVersion("7.4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range tests {
+		src := []byte("<?php\n" + test.src)
+		rootNode, parserErrors := Parse(src, v80)
+		if len(parserErrors) != 0 {
+			t.Errorf("%s: unexpected error: %v", test.src, parserErrors[0])
+			continue
+		}
+		collector := &php8NodeCollector{}
+		traverser.NewTraverser(collector).Traverse(rootNode)
+		if have := strings.Join(collector.nodes, ", "); have != test.want {
+			t.Errorf("%s: nodes mismatch:\nhave: %s\nwant: %s", test.src, have, test.want)
+		}
+		// A leading attribute is a comment for the older versions.
+		if test.want != "" && !strings.HasPrefix(test.src, "#[") {
+			if _, parserErrors := Parse(src, v74); len(parserErrors) == 0 {
+				t.Errorf("%s: expected a PHP 7.4 parse error", test.src)
+			}
+		}
+	}
+}
+
+type php8NodeCollector struct {
+	visitor.Null
+	nodes []string
+}
+
+func (c *php8NodeCollector) Attribute(n *ast.Attribute) {
+	c.nodes = append(c.nodes, "attribute "+string(n.Name.(*ast.Name).Parts[0].(*ast.NamePart).Value))
+}
+
+func (c *php8NodeCollector) Argument(n *ast.Argument) {
+	if n.Name != nil {
+		c.nodes = append(c.nodes, "named arg "+string(n.Name.(*ast.Identifier).Value))
+	}
+}
+
+func (c *php8NodeCollector) Parameter(n *ast.Parameter) {
+	if len(n.Modifiers) != 0 {
+		c.nodes = append(c.nodes, "promoted param "+string(n.Var.(*ast.ExprVariable).Name.(*ast.Identifier).Value))
+	}
+}
+
+func (c *php8NodeCollector) Union(n *ast.Union) {
+	c.nodes = append(c.nodes, fmt.Sprintf("union of %d types", len(n.Types)))
+}
+
+func (c *php8NodeCollector) ExprMatch(n *ast.ExprMatch) {
+	c.nodes = append(c.nodes, "match")
+}
+
+func (c *php8NodeCollector) ExprNullsafeMethodCall(n *ast.ExprNullsafeMethodCall) {
+	c.nodes = append(c.nodes, "nullsafe method call")
+}
+
+func (c *php8NodeCollector) ExprNullsafePropertyFetch(n *ast.ExprNullsafePropertyFetch) {
+	c.nodes = append(c.nodes, "nullsafe property fetch")
+}
+
+func (c *php8NodeCollector) ExprThrow(n *ast.ExprThrow) {
+	c.nodes = append(c.nodes, "throw expression")
+}
+
+func TestParsePHP8Keywords(t *testing.T) {
+	// match is a reserved keyword since PHP 8.0,
+	// it can be used only as a member name.
+	tests := []string{
+		`function match() {}`,
+		`const MATCH = 3;`,
+		`class Match {}`,
+	}
+
+	v80, err := ParseVersion("8.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	v74, err := ParseVersion("7.4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, src := range tests {
+		if _, parserErrors := Parse([]byte("<?php\n"+src), v80); len(parserErrors) == 0 {
+			t.Errorf("%s: expected a PHP 8.0 parse error", src)
+		}
+		if _, parserErrors := Parse([]byte("<?php\n"+src), v74); len(parserErrors) != 0 {
+			t.Errorf("%s: unexpected PHP 7.4 error: %v", src, parserErrors[0])
+		}
+	}
+}
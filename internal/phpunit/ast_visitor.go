@@ -4,14 +4,17 @@ import (
 	"fmt"
 	"strings"
 
-	"github.com/z7zmey/php-parser/pkg/ast"
-	"github.com/z7zmey/php-parser/pkg/visitor"
+	"github.com/VKCOM/php-parser/pkg/ast"
+	"github.com/VKCOM/php-parser/pkg/visitor"
 )
 
 type astVisitor struct {
 	visitor.Null
 	out *testParsedInfo
 
+	// filename is a test file path relative to the project root.
+	filename string
+
 	currentClass string
 }
 
@@ -32,12 +35,77 @@ func (v *astVisitor) ExprMethodCall(n *ast.ExprMethodCall) {
 		return
 	}
 	switch string(methodName.Value) {
-	case "assertTrue", "assertFalse", "assertSame", "assertNotSame", "assertEquals":
-		lineMethodName := string(methodName.Value) + "WithLine"
+	case "assertTrue", "assertFalse", "assertSame", "assertNotSame", "assertEquals", "assertNotEquals",
+		"expectOutputString", "expectOutputRegex", "forAll":
+		// Assertions are implemented by the ktest runtime, see runtimeSource.
+		v.rewriteRuntimeCall(n, methodName)
+	case "stub":
+		// The literal targets are checked against the stub declarations,
+		// see runner.stepCheckStubCalls.
+		if len(n.Args) != 0 {
+			if arg, ok := n.Args[0].(*ast.Argument); ok {
+				if target, ok := arg.Expr.(*ast.ScalarString); ok {
+					v.out.stubCalls = append(v.out.stubCalls, stubCall{
+						target: strings.ReplaceAll(unquotePHPString(target.Value), `\\`, `\`),
+						line:   n.GetPosition().StartLine,
+					})
+				}
+			}
+		}
+		v.rewriteRuntimeCall(n, methodName)
+	case "freezeTime", "advanceTime", "seedRandom":
+		// The built-in calls are rewritten only if the clock is controlled,
+		// see clockFunctions.
+		v.out.usesClock = true
+		v.rewriteRuntimeCall(n, methodName)
+	case "assertMatchesSnapshot":
+		// The snapshot location depends on the test file,
+		// the test name is provided by the runtime.
+		dir := snapshotDir(v.filename, v.out.ClassName)
 		v.out.fixes = append(v.out.fixes, textEdit{
-			StartPos:    methodName.GetPosition().StartPos,
+			StartPos:    n.GetPosition().StartPos,
 			EndPos:      n.OpenParenthesisTkn.GetPosition().EndPos,
-			Replacement: fmt.Sprintf("%s(__LINE__, ", lineMethodName),
+			Replacement: fmt.Sprintf(`\KTest\Runtime::assertMatchesSnapshot(__LINE__, '%s', `, escapePHPString(dir)),
+		})
+	default:
+		// The other kphpunit assertions would write their results
+		// into the captured test output, see runner.stepParseTestFiles.
+		name := strings.ToLower(string(methodName.Value))
+		if strings.HasPrefix(name, "assert") || strings.HasPrefix(name, "expect") {
+			v.out.unsupportedCalls = append(v.out.unsupportedCalls, methodCall{
+				method: string(methodName.Value),
+				line:   n.GetPosition().StartLine,
+			})
+		}
+	}
+}
+
+func (v *astVisitor) rewriteRuntimeCall(n *ast.ExprMethodCall, methodName *ast.Identifier) {
+	v.out.fixes = append(v.out.fixes, textEdit{
+		StartPos:    n.GetPosition().StartPos,
+		EndPos:      n.OpenParenthesisTkn.GetPosition().EndPos,
+		Replacement: fmt.Sprintf(`\KTest\Runtime::%s(__LINE__, `, methodName.Value),
+	})
+}
+
+func (v *astVisitor) ExprFunctionCall(n *ast.ExprFunctionCall) {
+	var parts []ast.Vertex
+	switch name := n.Function.(type) {
+	case *ast.Name:
+		parts = name.Parts
+	case *ast.NameFullyQualified:
+		parts = name.Parts
+	}
+	if len(parts) != 1 {
+		return
+	}
+	funcName := strings.ToLower(string(parts[0].(*ast.NamePart).Value))
+	if replacement, ok := clockFunctions[funcName]; ok {
+		pos := n.Function.GetPosition()
+		v.out.clockFixes = append(v.out.clockFixes, textEdit{
+			StartPos:    pos.StartPos,
+			EndPos:      pos.EndPos,
+			Replacement: replacement,
 		})
 	}
 }
@@ -85,8 +153,16 @@ func (v *astVisitor) StmtClassMethod(n *ast.StmtClassMethod) {
 		return
 	}
 	methodName := string(ident.Value)
+	if v.out.declaredMethods == nil {
+		v.out.declaredMethods = make(map[string]bool)
+	}
+	v.out.declaredMethods[strings.ToLower(methodName)] = true
 	if !strings.HasPrefix(methodName, "test") {
 		return
 	}
 	v.out.TestMethods = append(v.out.TestMethods, methodName)
+	if v.out.methodLines == nil {
+		v.out.methodLines = make(map[string]int)
+	}
+	v.out.methodLines[methodName] = n.GetPosition().StartLine
 }
@@ -0,0 +1,62 @@
+package phpunit
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/quasilyte/ktest/internal/fileutil"
+	"github.com/quasilyte/ktest/internal/phpsyntax"
+)
+
+func TestUnsupportedAssertions(t *testing.T) {
+	tests := []struct {
+		body string
+		err  string
+	}{
+		{body: `$this->assertSame(1, 1); $this->assertValid([]);`},
+		{body: `$this->expectOutputString('a'); echo 'a';`},
+		{body: `$this->assertCount(1, []);`, err: `FooTest.php:5: $this->assertCount() is not supported by ktest`},
+		{body: `$this->expectException(\LogicException::class);`, err: `FooTest.php:5: $this->expectException() is not supported by ktest`},
+	}
+
+	tempDir, err := ioutil.TempDir("", "ktest-unsupported")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+	v, err := phpsyntax.ParseVersion("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range tests {
+		src := `<?php
+use PHPUnit\Framework\TestCase;
+class FooTest extends TestCase {
+  public function testFoo() {
+    ` + test.body + `
+  }
+  private function assertValid(array $xs) {}
+}
+`
+		filename := filepath.Join(tempDir, "FooTest.php")
+		if err := fileutil.WriteFile(filename, []byte(src)); err != nil {
+			t.Fatal(err)
+		}
+		r := &runner{
+			conf:       &RunConfig{ProjectRoot: tempDir + "/"},
+			phpVersion: v,
+			testFiles:  []*testFile{{fullName: filename}},
+		}
+		err := r.stepParseTestFiles()
+		switch {
+		case test.err == "" && err != nil:
+			t.Errorf("%s: unexpected error: %v", test.body, err)
+		case test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)):
+			t.Errorf("%s: error mismatch:\nhave: %v\nwant: %s", test.body, err, test.err)
+		}
+	}
+}
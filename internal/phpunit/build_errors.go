@@ -0,0 +1,295 @@
+package phpunit
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// BuildError describes a test class that can't be compiled.
+type BuildError struct {
+	// TestFile is a test file that was being built.
+	TestFile  string
+	TestClass string
+
+	Diagnostics []BuildDiagnostic
+
+	// Output is a raw kphp2cpp output.
+	// It's useful when the output can't be parsed into diagnostics.
+	Output string
+}
+
+// BuildDiagnostic is a single kphp2cpp error or warning.
+type BuildDiagnostic struct {
+	Severity string
+
+	// File is a path to the original file, not the build dir file.
+	File     string
+	Line     int
+	Function string
+
+	Message string
+}
+
+// IsShared reports whether the build error comes from the code
+// that is not a part of the test file, like the project sources.
+func (e *BuildError) IsShared() bool {
+	for _, d := range e.Diagnostics {
+		if d.Severity == "error" && d.File != "" && d.File != e.TestFile {
+			return true
+		}
+	}
+	return false
+}
+
+// BuildErrorGroup is a build error that is shared by several test classes.
+type BuildErrorGroup struct {
+	// Diagnostics is a normalized diagnostics list.
+	// If it's empty, Output should be used instead.
+	Diagnostics []BuildDiagnostic
+	Output      string
+
+	TestClasses []string
+}
+
+// GroupBuildErrors combines the identical build errors.
+// The groups are ordered by their first appearance.
+func GroupBuildErrors(buildErrors []BuildError) []*BuildErrorGroup {
+	var groups []*BuildErrorGroup
+	byKey := make(map[string]*BuildErrorGroup)
+	for _, e := range buildErrors {
+		var diagnostics []BuildDiagnostic
+		for _, d := range e.Diagnostics {
+			if d.Severity == "error" {
+				d.Message = strings.Join(strings.Fields(d.Message), " ")
+				diagnostics = append(diagnostics, d)
+			}
+		}
+		var key string
+		if len(diagnostics) == 0 {
+			key = "output:" + strings.TrimSpace(e.Output)
+		} else {
+			parts := make([]string, len(diagnostics))
+			for i, d := range diagnostics {
+				parts[i] = d.String()
+			}
+			key = strings.Join(parts, "\n")
+		}
+		g := byKey[key]
+		if g == nil {
+			g = &BuildErrorGroup{Diagnostics: diagnostics}
+			if len(diagnostics) == 0 {
+				g.Output = strings.TrimSpace(e.Output)
+			}
+			byKey[key] = g
+			groups = append(groups, g)
+		}
+		g.TestClasses = append(g.TestClasses, e.TestClass)
+	}
+	return groups
+}
+
+func (d BuildDiagnostic) String() string {
+	if d.File == "" {
+		return fmt.Sprintf("%s: %s", d.Severity, d.Message)
+	}
+	return fmt.Sprintf("%s:%d: %s: %s", d.File, d.Line, d.Severity, d.Message)
+}
+
+var (
+	ansiEscapeRegexp    = regexp.MustCompile(`\x1b\[[0-9;]*m`)
+	buildHeaderRegexp   = regexp.MustCompile(`^(Compilation error|Warning|Error) at stage: `)
+	buildLocationRegexp = regexp.MustCompile(`^\s+(\S+\.php):(\d+)(?:\s+in\s+(.+))?$`)
+	buildOneLineRegexp  = regexp.MustCompile(`^(\S+\.php):(\d+):\s*(?:(error|warning):\s*)?(.+)$`)
+)
+
+// parseBuildOutput extracts the diagnostics from the kphp2cpp output.
+//
+// The multi-line diagnostics have the following form:
+//
+//	Compilation error at stage: <stage>, gen by <file>
+//	  <file>:<line>  in <function>
+//	    <code>
+//	<message>
+//
+// One-line "<file>:<line>: <message>" diagnostics are recognized as well.
+func parseBuildOutput(out []byte) []BuildDiagnostic {
+	var diagnostics []BuildDiagnostic
+
+	var current *BuildDiagnostic
+	var messageLines []string
+	flush := func() {
+		if current == nil {
+			return
+		}
+		current.Message = strings.TrimSpace(strings.Join(messageLines, "\n"))
+		if current.Message != "" || current.File != "" {
+			diagnostics = append(diagnostics, *current)
+		}
+		current = nil
+		messageLines = messageLines[:0]
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(ansiEscapeRegexp.ReplaceAll(out, nil)))
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), " \t\r")
+
+		if m := buildHeaderRegexp.FindStringSubmatch(line); m != nil {
+			flush()
+			severity := "error"
+			if m[1] == "Warning" {
+				severity = "warning"
+			}
+			current = &BuildDiagnostic{Severity: severity}
+			continue
+		}
+		if current == nil {
+			if m := buildOneLineRegexp.FindStringSubmatch(line); m != nil {
+				severity := m[3]
+				if severity == "" {
+					severity = "error"
+				}
+				lineNum, _ := strconv.Atoi(m[2])
+				diagnostics = append(diagnostics, BuildDiagnostic{
+					Severity: severity,
+					File:     m[1],
+					Line:     lineNum,
+					Message:  strings.TrimSpace(m[4]),
+				})
+			}
+			continue
+		}
+		if current.File == "" {
+			if m := buildLocationRegexp.FindStringSubmatch(line); m != nil {
+				current.File = m[1]
+				current.Line, _ = strconv.Atoi(m[2])
+				current.Function = m[3]
+				continue
+			}
+		}
+		if line == "" {
+			if len(messageLines) != 0 {
+				flush()
+			}
+			continue
+		}
+		if strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t") {
+			continue // A code snippet printed by kphp2cpp
+		}
+		if strings.HasPrefix(line, "Compilation terminated") {
+			flush()
+			continue
+		}
+		messageLines = append(messageLines, line)
+	}
+	flush()
+
+	return diagnostics
+}
+
+func (r *runner) newBuildError(f *testFile, out []byte) BuildError {
+	buildErr := BuildError{
+		TestFile:  f.fullName,
+		TestClass: f.info.ClassName,
+		Output:    string(out),
+	}
+	for _, d := range parseBuildOutput(out) {
+		d = r.mapBuildDiagnostic(d)
+		d.Message = strings.ReplaceAll(d.Message, r.buildDir+"/", "")
+		buildErr.Diagnostics = append(buildErr.Diagnostics, d)
+	}
+	return buildErr
+}
+
+// mapBuildDiagnostic rewrites the build dir locations into the project locations.
+func (r *runner) mapBuildDiagnostic(d BuildDiagnostic) BuildDiagnostic {
+	if d.File == "" {
+		return d
+	}
+
+	filename := d.File
+	if !filepath.IsAbs(filename) {
+		filename = filepath.Join(r.buildDir, filename)
+	}
+	buildDirs := []string{r.buildDir}
+	if resolved, err := filepath.EvalSymlinks(r.buildDir); err == nil && resolved != r.buildDir {
+		buildDirs = append(buildDirs, resolved)
+	}
+	for _, dir := range buildDirs {
+		if strings.HasPrefix(filename, dir+"/") {
+			filename = filepath.Join(r.buildDir, strings.TrimPrefix(filename, dir+"/"))
+			break
+		}
+	}
+
+	for _, f := range r.testFiles {
+		switch filename {
+		case filepath.Join(r.buildDirTests, f.shortName):
+			d.File = f.fullName
+			if f.sourceMap != nil {
+				d.Line = f.sourceMap.OrigLine(d.Line)
+			}
+			return d
+		case f.mainFilename:
+			// There is no original file for the generated main,
+			// the test file is the closest thing we can report.
+			d.File = f.fullName
+			d.Line = 0
+			return d
+		}
+	}
+
+	if strings.HasPrefix(filename, r.buildDir+"/") {
+		rel := strings.TrimPrefix(filename, r.buildDir+"/")
+		d.File = filepath.Join(r.conf.ProjectRoot, rel)
+		if m := r.sourceMaps[d.File]; m != nil {
+			d.Line = m.OrigLine(d.Line)
+		}
+	}
+
+	return d
+}
+
+// formatBuildDiagnostic prints the diagnostic along with the
+// original source code snippet around the reported line.
+func formatBuildDiagnostic(w io.Writer, d BuildDiagnostic, shortLocation bool) {
+	location := d
+	if shortLocation {
+		location.File = filepath.Base(d.File)
+	}
+	fmt.Fprintln(w, location)
+	if d.Function != "" {
+		fmt.Fprintf(w, "  in %s\n", d.Function)
+	}
+	if d.File == "" || d.Line == 0 {
+		return
+	}
+	src, err := ioutil.ReadFile(d.File)
+	if err != nil {
+		return
+	}
+	lines := strings.Split(string(src), "\n")
+	const contextLines = 2
+	from := d.Line - contextLines
+	if from < 1 {
+		from = 1
+	}
+	to := d.Line + contextLines
+	if to > len(lines) {
+		to = len(lines)
+	}
+	for i := from; i <= to; i++ {
+		marker := " "
+		if i == d.Line {
+			marker = ">"
+		}
+		fmt.Fprintf(w, "  %s %4d | %s\n", marker, i, lines[i-1])
+	}
+}
@@ -0,0 +1,120 @@
+package phpunit
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseBuildOutput(t *testing.T) {
+	out := "\x1b[31mCompilation error at stage: Check func calls and vararg, gen by check-func-calls-and-vararg.cpp:58\x1b[0m\n" +
+		"  /tmp/kphpunit-build1/src/Foo.php:9  in Foo::bar\n" +
+		"    $x = baz(1);\n" +
+		"Too many arguments in call to function baz\n" +
+		"\n" +
+		"Warning at stage: Parse file, gen by parse.cpp:10\n" +
+		"  tests/FooTest.php:4\n" +
+		"    return;\n" +
+		"Unreachable code\n" +
+		"\n" +
+		"Compilation terminated due to errors\n"
+
+	want := []BuildDiagnostic{
+		{
+			Severity: "error",
+			File:     "/tmp/kphpunit-build1/src/Foo.php",
+			Line:     9,
+			Function: "Foo::bar",
+			Message:  "Too many arguments in call to function baz",
+		},
+		{
+			Severity: "warning",
+			File:     "tests/FooTest.php",
+			Line:     4,
+			Message:  "Unreachable code",
+		},
+	}
+
+	have := parseBuildOutput([]byte(out))
+	if len(have) != len(want) {
+		t.Fatalf("diagnostics count mismatch: have %d, want %d\n%v", len(have), len(want), have)
+	}
+	for i := range want {
+		if have[i] != want[i] {
+			t.Errorf("diagnostic %d mismatch:\nhave: %#v\nwant: %#v", i, have[i], want[i])
+		}
+	}
+}
+
+func TestSourceMap(t *testing.T) {
+	contents := []byte("line1\nfoo(1);\nline3\nbar(2);\nline5\n")
+	fixes := []textEdit{
+		{StartPos: 6, EndPos: 6, Replacement: "// inserted\n// lines\n"},
+		{StartPos: 20, EndPos: 24, Replacement: "baz(__LINE__, "},
+	}
+	preprocessed := applyTextEdits(contents, fixes)
+	want := "line1\n// inserted\n// lines\nfoo(1);\nline3\nbaz(__LINE__, 2);\nline5\n"
+	if string(preprocessed) != want {
+		t.Fatalf("preprocessed contents mismatch:\nhave:\n%s\nwant:\n%s", preprocessed, want)
+	}
+
+	m := newSourceMap(contents, fixes)
+	tests := []struct {
+		newLine  int
+		origLine int
+	}{
+		{1, 1},
+		{2, 2},
+		{3, 2},
+		{4, 2},
+		{5, 3},
+		{6, 4},
+		{7, 5},
+	}
+	for _, test := range tests {
+		if have := m.OrigLine(test.newLine); have != test.origLine {
+			t.Errorf("OrigLine(%d): have %d, want %d", test.newLine, have, test.origLine)
+		}
+	}
+}
+
+func TestGroupBuildErrors(t *testing.T) {
+	shared := BuildDiagnostic{
+		Severity: "error",
+		File:     "/project/src/Foo.php",
+		Line:     9,
+		Message:  "Too many arguments",
+	}
+	local := BuildDiagnostic{
+		Severity: "error",
+		File:     "/project/tests/BarTest.php",
+		Line:     5,
+		Message:  "Unknown function",
+	}
+	buildErrors := []BuildError{
+		{TestFile: "/project/tests/ATest.php", TestClass: "ATest", Diagnostics: []BuildDiagnostic{shared}},
+		{TestFile: "/project/tests/BarTest.php", TestClass: "BarTest", Diagnostics: []BuildDiagnostic{local}},
+		{TestFile: "/project/tests/CTest.php", TestClass: "CTest", Diagnostics: []BuildDiagnostic{shared}},
+		{TestFile: "/project/tests/DTest.php", TestClass: "DTest", Output: "segfault\n"},
+	}
+
+	if !buildErrors[0].IsShared() {
+		t.Errorf("expected ATest error to be shared")
+	}
+	if buildErrors[1].IsShared() {
+		t.Errorf("expected BarTest error to be local")
+	}
+
+	groups := GroupBuildErrors(buildErrors)
+	if len(groups) != 3 {
+		t.Fatalf("expected 3 groups, got %d", len(groups))
+	}
+	if have := strings.Join(groups[0].TestClasses, ","); have != "ATest,CTest" {
+		t.Errorf("group 0: have %s classes", have)
+	}
+	if have := strings.Join(groups[1].TestClasses, ","); have != "BarTest" {
+		t.Errorf("group 1: have %s classes", have)
+	}
+	if groups[2].Output != "segfault" {
+		t.Errorf("group 2: have %q output", groups[2].Output)
+	}
+}
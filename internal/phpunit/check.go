@@ -0,0 +1,409 @@
+package phpunit
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/VKCOM/php-parser/pkg/ast"
+	"github.com/VKCOM/php-parser/pkg/position"
+	"github.com/VKCOM/php-parser/pkg/visitor"
+	"github.com/VKCOM/php-parser/pkg/visitor/traverser"
+	"github.com/quasilyte/ktest/internal/phpsyntax"
+)
+
+// CompatIssue is a PHP construct that is known to be unsupported by KPHP.
+type CompatIssue struct {
+	File    string
+	Line    int
+	Message string
+
+	// Warning marks the heuristic findings: the code may compile fine,
+	// but it's likely to behave differently in KPHP.
+	Warning bool
+}
+
+func (issue CompatIssue) String() string {
+	if issue.Warning {
+		return fmt.Sprintf("%s:%d: warning: %s", issue.File, issue.Line, issue.Message)
+	}
+	return fmt.Sprintf("%s:%d: %s", issue.File, issue.Line, issue.Message)
+}
+
+// Check runs the KPHP compatibility checks over the test files
+// and the sources they depend on without compiling anything.
+func Check(conf *RunConfig) ([]CompatIssue, error) {
+	r := newRunner(conf)
+	steps := []struct {
+		name string
+		fn   func() error
+	}{
+		{"parse php version", r.stepParsePHPVersion},
+		{"find test files", r.stepFindTestFiles},
+		{"filter test files", r.stepFilterTestFiles},
+		{"parse test files", r.stepParseTestFiles},
+		{"load composer autoload", r.stepLoadComposerAutoload},
+	}
+	for _, step := range steps {
+		if err := step.fn(); err != nil {
+			return nil, fmt.Errorf("%s: %w", step.name, err)
+		}
+	}
+	return r.checkCompatibility()
+}
+
+// FormatCompatIssues prints the compatibility issues, one per line.
+func FormatCompatIssues(w io.Writer, issues []CompatIssue) {
+	for _, issue := range issues {
+		fmt.Fprintln(w, issue)
+	}
+}
+
+func (r *runner) checkCompatibility() ([]CompatIssue, error) {
+	index := newDepsIndex(r.phpVersion)
+	for _, root := range r.sourceRoots {
+		if err := index.AddDir(root); err != nil {
+			return nil, err
+		}
+	}
+	reachable := make(map[string]bool)
+	for _, f := range r.testFiles {
+		if err := index.AddFile(f.fullName); err != nil {
+			return nil, err
+		}
+		for dep := range index.Closure(f.fullName) {
+			reachable[dep] = true
+		}
+	}
+
+	filenames := make([]string, 0, len(reachable))
+	for filename := range reachable {
+		filenames = append(filenames, filename)
+	}
+	sort.Strings(filenames)
+
+	var issues []CompatIssue
+	for _, filename := range filenames {
+		src, err := ioutil.ReadFile(filename)
+		if err != nil {
+			return nil, err
+		}
+		relFilename, err := filepath.Rel(r.conf.ProjectRoot, filename)
+		if err != nil {
+			return nil, err
+		}
+		rootNode, parserErrors := phpsyntax.Parse(src, r.phpVersion)
+		if len(parserErrors) != 0 {
+			for _, parseErr := range parserErrors {
+				issues = append(issues, CompatIssue{
+					File:    relFilename,
+					Line:    parserErrorLine(parseErr),
+					Message: "parse error: " + parseErr.Msg,
+				})
+			}
+			continue
+		}
+		v := &compatVisitor{
+			filename: relFilename,
+			src:      src,
+		}
+		traverser.NewTraverser(v).Traverse(rootNode)
+		issues = append(issues, v.issues...)
+	}
+
+	return issues, nil
+}
+
+func (r *runner) stepCheckCompatibility() error {
+	if r.conf.NoCompatCheck {
+		return nil
+	}
+
+	issues, err := r.checkCompatibility()
+	if err != nil {
+		return err
+	}
+	numErrors := 0
+	for _, issue := range issues {
+		r.logf("%s", issue)
+		if !issue.Warning {
+			numErrors++
+		}
+	}
+	if numErrors == 0 {
+		return nil // Warnings don't prevent the tests from running
+	}
+	return fmt.Errorf("found %d KPHP compatibility issues", numErrors)
+}
+
+// compatVisitor reports the constructs that KPHP can't compile.
+type compatVisitor struct {
+	visitor.Null
+
+	filename string
+	src      []byte
+
+	issues []CompatIssue
+
+	// stmts are the positions of the statements that may have
+	// a phpdoc comment attached to them, see enclosingStmt.
+	stmts []*position.Position
+
+	// varAssigns are the current function variables assignments
+	// in the source order, they're used to track the closures.
+	varAssigns map[string][]varAssign
+}
+
+type varAssign struct {
+	// end is the assignment end position, the variable has
+	// the assigned value only after it.
+	end int
+
+	closure bool
+}
+
+func (v *compatVisitor) StmtFunction(n *ast.StmtFunction) {
+	v.varAssigns = nil
+}
+
+func (v *compatVisitor) StmtClassMethod(n *ast.StmtClassMethod) {
+	v.varAssigns = nil
+}
+
+func (v *compatVisitor) StmtExpression(n *ast.StmtExpression) {
+	v.enterStmt(n.Position)
+}
+
+func (v *compatVisitor) StmtReturn(n *ast.StmtReturn) {
+	v.enterStmt(n.Position)
+	v.checkArrayLiteral(n.Expr)
+}
+
+func (v *compatVisitor) StmtPropertyList(n *ast.StmtPropertyList) {
+	v.enterStmt(n.Position)
+}
+
+func (v *compatVisitor) StmtProperty(n *ast.StmtProperty) {
+	v.checkArrayLiteral(n.Expr)
+}
+
+func (v *compatVisitor) ExprAssign(n *ast.ExprAssign) {
+	v.checkArrayLiteral(n.Expr)
+	if name := variableName(n.Var); name != "" {
+		if v.varAssigns == nil {
+			v.varAssigns = make(map[string][]varAssign)
+		}
+		v.varAssigns[name] = append(v.varAssigns[name], varAssign{
+			end:     n.Position.EndPos,
+			closure: v.isClosure(n.Expr),
+		})
+	}
+}
+
+func (v *compatVisitor) ExprEval(n *ast.ExprEval) {
+	v.report(n, "eval is not supported")
+}
+
+func (v *compatVisitor) ExprVariable(n *ast.ExprVariable) {
+	if _, ok := n.Name.(*ast.Identifier); !ok {
+		v.report(n, "variable variables are not supported")
+	}
+}
+
+func (v *compatVisitor) ExprMethodCall(n *ast.ExprMethodCall) {
+	ident, ok := n.Method.(*ast.Identifier)
+	if !ok {
+		v.report(n, "dynamic method calls are not supported")
+		return
+	}
+	switch strings.ToLower(string(ident.Value)) {
+	// Only the receivers that are known to be closures are reported,
+	// a user-defined bindTo() or call() method can take $this just fine.
+	case "bindto":
+		if v.isClosure(n.Var) {
+			v.report(n, "binding closures to objects is not supported")
+		}
+	case "call":
+		if len(n.Args) != 0 && isThisArgument(n.Args[0]) && v.isClosure(n.Var) {
+			v.report(n, "binding closures to objects is not supported")
+		}
+	}
+}
+
+func (v *compatVisitor) ExprStaticCall(n *ast.ExprStaticCall) {
+	if _, ok := n.Call.(*ast.Identifier); !ok {
+		v.report(n, "dynamic static method calls are not supported")
+		return
+	}
+	if _, ok := n.Class.(*ast.ExprVariable); ok {
+		v.report(n, "static calls via class name variables are not supported")
+		return
+	}
+	if isClosureClass(n.Class) && strings.EqualFold(string(n.Call.(*ast.Identifier).Value), "bind") {
+		v.report(n, "binding closures to objects is not supported")
+	}
+}
+
+func (v *compatVisitor) ExprNew(n *ast.ExprNew) {
+	if _, ok := n.Class.(*ast.ExprVariable); ok {
+		v.report(n, "instantiation via class name variables is not supported")
+	}
+}
+
+func (v *compatVisitor) checkArrayLiteral(e ast.Vertex) {
+	arr, ok := e.(*ast.ExprArray)
+	if !ok {
+		return
+	}
+	kinds := make(map[string]bool)
+	for _, item := range arr.Items {
+		item, ok := item.(*ast.ExprArrayItem)
+		if !ok || item == nil {
+			continue
+		}
+		if kind := literalKind(item.Val); kind != "" {
+			kinds[kind] = true
+		}
+	}
+	if len(kinds) < 2 || v.hasVarAnnotation(arr) {
+		return
+	}
+	// KPHP compiles such arrays as mixed[], it's only a problem
+	// when the code relies on the more precise element type.
+	v.warn(arr, "array mixes values of different types, KPHP infers mixed[]; add a @var annotation")
+}
+
+// isClosure reports whether e is a closure literal, a Closure::fromCallable()
+// result or a variable that was assigned one of them.
+func (v *compatVisitor) isClosure(e ast.Vertex) bool {
+	if brackets, ok := e.(*ast.ExprBrackets); ok {
+		e = brackets.Expr
+	}
+	switch e := e.(type) {
+	case *ast.ExprClosure, *ast.ExprArrowFunction:
+		return true
+	case *ast.ExprStaticCall:
+		call, ok := e.Call.(*ast.Identifier)
+		return ok && isClosureClass(e.Class) && strings.EqualFold(string(call.Value), "fromCallable")
+	}
+	// The assignments are visited before their right hand side,
+	// so the later assignments can be recorded already.
+	assigns := v.varAssigns[variableName(e)]
+	for i := len(assigns) - 1; i >= 0; i-- {
+		if assigns[i].end <= e.GetPosition().StartPos {
+			return assigns[i].closure
+		}
+	}
+	return false
+}
+
+// enterStmt records the statement that is about to be visited.
+func (v *compatVisitor) enterStmt(pos *position.Position) {
+	v.enclosingStmt(pos.StartPos)
+	v.stmts = append(v.stmts, pos)
+}
+
+// enclosingStmt returns the innermost recorded statement that contains the offset.
+//
+// The nodes are visited in the source order, so the statements that end
+// before the offset can be forgotten: the following nodes are located after them.
+func (v *compatVisitor) enclosingStmt(offset int) *position.Position {
+	for len(v.stmts) != 0 && v.stmts[len(v.stmts)-1].EndPos <= offset {
+		v.stmts = v.stmts[:len(v.stmts)-1]
+	}
+	if len(v.stmts) == 0 {
+		return nil
+	}
+	return v.stmts[len(v.stmts)-1]
+}
+
+// hasVarAnnotation reports whether the statement that contains n
+// is preceded by a phpdoc comment with @var tag.
+func (v *compatVisitor) hasVarAnnotation(n ast.Vertex) bool {
+	stmt := v.enclosingStmt(n.GetPosition().StartPos)
+	if stmt == nil {
+		return false
+	}
+	before := bytes.TrimRight(v.src[:stmt.StartPos], " \t\r\n")
+	if !bytes.HasSuffix(before, []byte("*/")) {
+		return false
+	}
+	start := bytes.LastIndex(before, []byte("/**"))
+	if start == -1 {
+		return false
+	}
+	return bytes.Contains(before[start:], []byte("@var"))
+}
+
+func (v *compatVisitor) report(n ast.Vertex, message string) {
+	v.issues = append(v.issues, CompatIssue{
+		File:    v.filename,
+		Line:    n.GetPosition().StartLine,
+		Message: message,
+	})
+}
+
+func (v *compatVisitor) warn(n ast.Vertex, message string) {
+	v.issues = append(v.issues, CompatIssue{
+		File:    v.filename,
+		Line:    n.GetPosition().StartLine,
+		Message: message,
+		Warning: true,
+	})
+}
+
+func literalKind(e ast.Vertex) string {
+	switch e := e.(type) {
+	case *ast.ScalarLnumber, *ast.ScalarDnumber:
+		return "number" // KPHP infers float for int+float combination
+
+	case *ast.ScalarString, *ast.ScalarEncapsed, *ast.ScalarHeredoc:
+		return "string"
+	case *ast.ExprArray:
+		return "array"
+	case *ast.ExprConstFetch:
+		name, ok := e.Const.(*ast.Name)
+		if !ok {
+			return ""
+		}
+		switch strings.ToLower(astNameToString(name)) {
+		case "true", "false":
+			return "bool"
+		}
+	}
+	return ""
+}
+
+// isClosureClass reports whether the class name refers to the Closure class.
+func isClosureClass(class ast.Vertex) bool {
+	switch class := class.(type) {
+	case *ast.Name:
+		return strings.EqualFold(astNameToString(class), "Closure")
+	case *ast.NameFullyQualified:
+		return strings.EqualFold(strings.Join(namePartsToStrings(class.Parts), `\`), "Closure")
+	}
+	return false
+}
+
+func isThisArgument(arg ast.Vertex) bool {
+	a, ok := arg.(*ast.Argument)
+	return ok && variableName(a.Expr) == "$this"
+}
+
+// variableName returns the name of a simple variable, like "$x".
+// For other expressions an empty string is returned.
+func variableName(e ast.Vertex) string {
+	variable, ok := e.(*ast.ExprVariable)
+	if !ok {
+		return ""
+	}
+	ident, ok := variable.Name.(*ast.Identifier)
+	if !ok {
+		return ""
+	}
+	return string(ident.Value)
+}
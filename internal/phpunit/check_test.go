@@ -0,0 +1,63 @@
+package phpunit
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/VKCOM/php-parser/pkg/visitor/traverser"
+	"github.com/quasilyte/ktest/internal/phpsyntax"
+)
+
+func TestCompatVisitor(t *testing.T) {
+	src := `<?php
+class Foo {
+  public function f($x, $method) {
+    $this->$method();
+    $y = $$x;
+    eval('return 1;');
+    $c = function() {};
+    $c = $c->bindTo($this);
+    $a = [1, 'a'];
+    /** @var mixed[] */
+    $b = [1, 'a'];
+    $nums = [1, 2.5]; $cb = function() {};
+    $cb->call($this);
+    $this->router->call($this);
+    (function() {})->call($this);
+    $c = $this->router;
+    $c->call($this);
+    $this->router->bindTo($this);
+    \Closure::fromCallable('strlen')->bindTo($this);
+    $m = [function() { /** @var mixed[] */ $z = [1, 'a']; }, $n = [2, 'b']];
+    return new $x();
+  }
+}
+`
+	want := []string{
+		"Foo.php:4: dynamic method calls are not supported",
+		"Foo.php:5: variable variables are not supported",
+		"Foo.php:6: eval is not supported",
+		"Foo.php:8: binding closures to objects is not supported",
+		"Foo.php:9: warning: array mixes values of different types, KPHP infers mixed[]; add a @var annotation",
+		"Foo.php:13: binding closures to objects is not supported",
+		"Foo.php:15: binding closures to objects is not supported",
+		"Foo.php:19: binding closures to objects is not supported",
+		"Foo.php:20: warning: array mixes values of different types, KPHP infers mixed[]; add a @var annotation",
+		"Foo.php:21: instantiation via class name variables is not supported",
+	}
+
+	rootNode, parserErrors := phpsyntax.Parse([]byte(src), testPHPVersion)
+	if len(parserErrors) != 0 {
+		t.Fatalf("parse: %v", parserErrors)
+	}
+	v := &compatVisitor{filename: "Foo.php", src: []byte(src)}
+	traverser.NewTraverser(v).Traverse(rootNode)
+	var have []string
+	for _, issue := range v.issues {
+		have = append(have, issue.String())
+	}
+	if strings.Join(have, "\n") != strings.Join(want, "\n") {
+		t.Errorf("issues mismatch:\nhave:\n%s\nwant:\n%s",
+			strings.Join(have, "\n"), strings.Join(want, "\n"))
+	}
+}
@@ -0,0 +1,19 @@
+package phpunit
+
+// clockFunctions maps the time and random built-ins to their
+// KTest\Clock replacements. The calls are rewritten in the sources
+// and the test files when the tests use the clock control API:
+//
+//	$this->freezeTime($timestamp) stops the clock at the specified time
+//	$this->advanceTime($seconds) moves the clock forward, freezing it first if needed
+//	$this->seedRandom($seed) makes the random numbers deterministic
+//
+// The clock and the random source are reset before every test method.
+var clockFunctions = map[string]string{
+	"time":       `\KTest\Clock::time`,
+	"microtime":  `\KTest\Clock::microtime`,
+	"hrtime":     `\KTest\Clock::hrtime`,
+	"mt_rand":    `\KTest\Clock::mt_rand`,
+	"rand":       `\KTest\Clock::rand`,
+	"random_int": `\KTest\Clock::random_int`,
+}
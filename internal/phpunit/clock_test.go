@@ -0,0 +1,78 @@
+package phpunit
+
+import (
+	"testing"
+
+	"github.com/VKCOM/php-parser/pkg/visitor/traverser"
+	"github.com/quasilyte/ktest/internal/phpsyntax"
+)
+
+func TestClockRewrite(t *testing.T) {
+	testSrc := []byte(`<?php
+class SessionTest {
+  public function testExpired() {
+    $this->freezeTime(1600000000);
+    $this->advanceTime(3600);
+    $this->assertSame(1600003600, time());
+  }
+}
+`)
+	wantTest := `<?php
+class SessionTest {
+  public function testExpired() {
+    \KTest\Runtime::freezeTime(__LINE__, 1600000000);
+    \KTest\Runtime::advanceTime(__LINE__, 3600);
+    \KTest\Runtime::assertSame(__LINE__, 1600003600, \KTest\Clock::time());
+  }
+}
+`
+	src := []byte(`<?php
+namespace App;
+
+class Session {
+  public function isExpired(): bool {
+    return \time() > $this->deadline + mt_rand(0, 10) + strlen(date('Y'));
+  }
+}
+`)
+	wantSrc := `<?php
+namespace App;
+
+class Session {
+  public function isExpired(): bool {
+    return \KTest\Clock::time() > $this->deadline + \KTest\Clock::mt_rand(0, 10) + strlen(date('Y'));
+  }
+}
+`
+
+	v, err := phpsyntax.ParseVersion("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rootNode, parserErrors := phpsyntax.Parse(testSrc, v)
+	if len(parserErrors) != 0 {
+		t.Fatal(parserErrors[0])
+	}
+	info := &testParsedInfo{}
+	traverser.NewTraverser(&astVisitor{out: info}).Traverse(rootNode)
+	if !info.usesClock {
+		t.Fatalf("clock usage is not detected")
+	}
+	have := string(applyTextEdits(testSrc, append(info.fixes, info.clockFixes...)))
+	if have != wantTest {
+		t.Errorf("test file rewrite mismatch:\n%s", unifiedDiff(wantTest, have))
+	}
+
+	rootNode, parserErrors = phpsyntax.Parse(src, v)
+	if len(parserErrors) != 0 {
+		t.Fatal(parserErrors[0])
+	}
+	r := &runner{usesClock: true}
+	callsVisitor := newCallsRewriteVisitor(r.callRewrites())
+	traverser.NewTraverser(callsVisitor).Traverse(rootNode)
+	have = string(applyTextEdits(src, callsVisitor.edits))
+	if have != wantSrc {
+		t.Errorf("source rewrite mismatch:\n%s", unifiedDiff(wantSrc, have))
+	}
+}
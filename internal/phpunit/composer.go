@@ -0,0 +1,360 @@
+package phpunit
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/quasilyte/ktest/internal/fileutil"
+)
+
+// composerAutoload holds the paths referenced by the composer.json
+// "autoload" and "autoload-dev" sections.
+//
+// All paths are cleaned, relative to the project root and exist;
+// the globs are expanded. The paths outside of the project root
+// start with "..", see buildDirRel.
+type composerAutoload struct {
+	// paths are the "autoload" section paths: PSR-4 and PSR-0 roots,
+	// classmap entries and "files".
+	paths []string
+
+	// devPaths are the "autoload-dev" section paths.
+	devPaths []string
+
+	// mapsRoot is set if the project root itself is autoloaded,
+	// like with the "" => "./" PSR-4 mapping.
+	// The root is not included into the paths.
+	mapsRoot bool
+
+	// manifest is the composer.json contents.
+	manifest []byte
+
+	// warnings describe the referenced paths that were skipped.
+	warnings []string
+}
+
+type composerAutoloadSection struct {
+	PSR4     map[string]composerPaths `json:"psr-4"`
+	PSR0     map[string]composerPaths `json:"psr-0"`
+	Classmap []string                 `json:"classmap"`
+	Files    []string                 `json:"files"`
+}
+
+// composerPaths is a namespace mapping value: either a single path or a list of paths.
+type composerPaths []string
+
+func (p *composerPaths) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*p = composerPaths{single}
+		return nil
+	}
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return err
+	}
+	*p = list
+	return nil
+}
+
+// loadComposerAutoload reads the project composer.json autoload sections.
+// If there is no composer.json, an empty autoload is returned.
+func loadComposerAutoload(projectRoot string) (*composerAutoload, error) {
+	filename := filepath.Join(projectRoot, "composer.json")
+	if !fileutil.FileExists(filename) {
+		return &composerAutoload{}, nil
+	}
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var manifest struct {
+		Autoload    composerAutoloadSection `json:"autoload"`
+		AutoloadDev composerAutoloadSection `json:"autoload-dev"`
+	}
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("%s: %v", filename, err)
+	}
+
+	autoload := &composerAutoload{manifest: data}
+	autoload.paths = autoload.resolvePaths(projectRoot, "autoload", &manifest.Autoload)
+	autoload.devPaths = autoload.resolvePaths(projectRoot, "autoload-dev", &manifest.AutoloadDev)
+	return autoload, nil
+}
+
+func (autoload *composerAutoload) resolvePaths(projectRoot, sectionName string, section *composerAutoloadSection) []string {
+	var patterns []string
+	for _, m := range []map[string]composerPaths{section.PSR4, section.PSR0} {
+		for _, paths := range m {
+			patterns = append(patterns, paths...)
+		}
+	}
+	patterns = append(patterns, section.Classmap...)
+	patterns = append(patterns, section.Files...)
+
+	var paths []string
+	for _, pattern := range patterns {
+		rel := composerRelPath(projectRoot, pattern)
+		if rel == "." {
+			autoload.mapsRoot = true
+			continue
+		}
+		if !strings.ContainsAny(rel, "*?[") {
+			if !fileutil.FileExists(filepath.Join(projectRoot, rel)) {
+				autoload.warnf("%s: %q doesn't exist", sectionName, pattern)
+				continue
+			}
+			paths = append(paths, rel)
+			continue
+		}
+		matches, err := filepath.Glob(filepath.Join(projectRoot, rel))
+		if err != nil || len(matches) == 0 {
+			autoload.warnf("%s: %q doesn't match any path", sectionName, pattern)
+			continue
+		}
+		for _, m := range matches {
+			paths = append(paths, composerRelPath(projectRoot, m))
+		}
+	}
+
+	return outermostPaths(paths)
+}
+
+// composerRelPath returns a composer.json path relative to the project root.
+func composerRelPath(projectRoot, p string) string {
+	p = filepath.FromSlash(p)
+	if filepath.IsAbs(p) {
+		if rel, err := filepath.Rel(projectRoot, p); err == nil {
+			return rel
+		}
+	}
+	return filepath.Clean(p)
+}
+
+// externalDir is a build dir location of the autoloaded paths
+// that are outside of the project root.
+var externalDir = filepath.Join("ktest", "external")
+
+// buildDirRel returns the build dir location of the path relative to the project root.
+//
+// The build dir is used as a composer root, so the paths outside of the project
+// root are relocated inside the externalDir: every ".." is replaced with "__parent".
+// The build dir composer.json refers to their new locations, see buildDirManifest.
+func buildDirRel(rel string) string {
+	if rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return rel
+	}
+	parts := strings.Split(rel, string(filepath.Separator))
+	for i, part := range parts {
+		if part == ".." {
+			parts[i] = "__parent"
+		}
+	}
+	return filepath.Join(externalDir, filepath.Join(parts...))
+}
+
+// buildDirManifest returns the composer.json contents for the build dir.
+// The autoload paths outside of the project root are replaced with their
+// relocated build dir paths. If there are no such paths, nil is returned:
+// the project composer.json can be used as is.
+func (autoload *composerAutoload) buildDirManifest(projectRoot string) ([]byte, error) {
+	if autoload.manifest == nil {
+		return nil, nil
+	}
+	var manifest map[string]interface{}
+	dec := json.NewDecoder(bytes.NewReader(autoload.manifest))
+	dec.UseNumber()
+	if err := dec.Decode(&manifest); err != nil {
+		return nil, err
+	}
+
+	relocated := false
+	relocate := func(p string) string {
+		rel := composerRelPath(projectRoot, p)
+		dst := buildDirRel(rel)
+		if dst == rel {
+			return p
+		}
+		relocated = true
+		dst = filepath.ToSlash(dst)
+		if strings.HasSuffix(p, "/") {
+			dst += "/"
+		}
+		return dst
+	}
+	relocateList := func(v interface{}) {
+		if list, ok := v.([]interface{}); ok {
+			for i, p := range list {
+				if p, ok := p.(string); ok {
+					list[i] = relocate(p)
+				}
+			}
+		}
+	}
+	for _, sectionName := range []string{"autoload", "autoload-dev"} {
+		section, ok := manifest[sectionName].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		for _, key := range []string{"psr-4", "psr-0"} {
+			m, ok := section[key].(map[string]interface{})
+			if !ok {
+				continue
+			}
+			for prefix, paths := range m {
+				if p, ok := paths.(string); ok {
+					m[prefix] = relocate(p)
+				} else {
+					relocateList(paths)
+				}
+			}
+		}
+		relocateList(section["classmap"])
+		relocateList(section["files"])
+	}
+
+	if !relocated {
+		return nil, nil
+	}
+	return json.MarshalIndent(manifest, "", "    ")
+}
+
+func (autoload *composerAutoload) warnf(format string, args ...interface{}) {
+	autoload.warnings = append(autoload.warnings, "composer.json: "+fmt.Sprintf(format, args...))
+}
+
+// sourceRoots returns the absolute paths of the project sources:
+// the "autoload" paths and the SrcDir, if it exists.
+func (autoload *composerAutoload) sourceRoots(conf *RunConfig) []string {
+	paths := append([]string{}, autoload.paths...)
+	if conf.SrcDir != "" && fileutil.FileExists(filepath.Join(conf.ProjectRoot, conf.SrcDir)) {
+		paths = append(paths, filepath.Clean(conf.SrcDir))
+	}
+	paths = outermostPaths(paths)
+	for i, p := range paths {
+		paths[i] = filepath.Join(conf.ProjectRoot, p)
+	}
+	return paths
+}
+
+// outermostPaths sorts the paths and removes the duplicates
+// along with the paths that are nested inside the other ones.
+func outermostPaths(paths []string) []string {
+	sorted := append([]string{}, paths...)
+	sort.Strings(sorted)
+	var result []string
+	for _, p := range sorted {
+		if len(result) != 0 && isSubpath(result[len(result)-1], p) {
+			continue
+		}
+		result = append(result, p)
+	}
+	return result
+}
+
+// isSubpath reports whether path is the same as root or is located inside it.
+func isSubpath(root, path string) bool {
+	return path == root || strings.HasPrefix(path, root+string(filepath.Separator))
+}
+
+// buildDirEntries are the build dir top-level entries that are written
+// by ktest and kphp, the project paths with these names are not mirrored.
+var buildDirEntries = []string{"composer.json", "vendor", "ktest", "mains", "protocol", "coverage", "cli"}
+
+// mirrorPath makes the project path available at the same place inside the build dir.
+// The paths outside of the project root are relocated, see buildDirRel.
+//
+// The paths are symlinked, except for the ones overlapping the test dir or
+// the copied sources: the build dir files are written there, so the directories
+// are re-created and only their other entries are symlinked. The copied sources
+// and the *Test.php files are skipped, stepCopySources and the preprocessing
+// write them instead.
+func (r *runner) mirrorPath(rel string, copied []string) error {
+	src := filepath.Join(r.conf.ProjectRoot, rel)
+	dst := filepath.Join(r.buildDir, buildDirRel(rel))
+	testDir := filepath.Clean(r.testDir)
+	buildDir := filepath.Clean(r.buildDir)
+
+	if isSubpath(buildDir, src) {
+		return nil // The build dir is located inside the project
+	}
+	for _, name := range buildDirEntries {
+		if rel == name {
+			if name != "composer.json" && name != "vendor" {
+				r.logf("%s: can't be mirrored, the build dir uses this name", src)
+			}
+			return nil
+		}
+	}
+
+	overlaps := isSubpath(src, testDir) || isSubpath(testDir, src) || isSubpath(src, buildDir)
+	for _, root := range copied {
+		if isSubpath(root, src) {
+			return nil
+		}
+		if isSubpath(src, root) {
+			overlaps = true
+		}
+	}
+
+	info, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() || !overlaps {
+		if isSubpath(testDir, src) && strings.HasSuffix(src, "Test.php") {
+			return nil
+		}
+		if _, err := os.Lstat(dst); err == nil {
+			return nil // Already linked during the previous run
+		}
+		if err := fileutil.MkdirAll(filepath.Dir(dst)); err != nil {
+			return err
+		}
+		return os.Symlink(src, dst)
+	}
+
+	// The previous run could have linked this dir as a whole;
+	// writing through that link would modify the project files.
+	if dstInfo, err := os.Lstat(dst); err == nil && dstInfo.Mode()&os.ModeSymlink != 0 {
+		if err := os.Remove(dst); err != nil {
+			return err
+		}
+	}
+	if err := fileutil.MkdirAll(dst); err != nil {
+		return err
+	}
+	entries, err := ioutil.ReadDir(src)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if err := r.mirrorPath(filepath.Join(rel, e.Name()), copied); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// buildDirFilename returns the build dir location of the project file.
+// If the file is not mirrored into the build dir, it's returned as is.
+func (r *runner) buildDirFilename(filename string) string {
+	if r.buildDir == "" {
+		return filename
+	}
+	rel, err := filepath.Rel(r.conf.ProjectRoot, filename)
+	if err != nil {
+		return filename
+	}
+	mirrored := filepath.Join(r.buildDir, buildDirRel(rel))
+	if !fileutil.FileExists(mirrored) {
+		return filename
+	}
+	return mirrored
+}
@@ -0,0 +1,226 @@
+package phpunit
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/quasilyte/ktest/internal/fileutil"
+)
+
+func TestLoadComposerAutoload(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "ktest-composer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+	projectRoot := filepath.Join(tempDir, "project")
+
+	files := map[string]string{
+		"composer.json": `{
+  "autoload": {
+    "psr-4": {"App\\": ["src/", "lib"], "App\\Core\\": "src/Core/"},
+    "psr-0": {"Legacy_": "legacy/"},
+    "classmap": ["modules/*/src", "generated/"],
+    "files": ["helpers.php", "../shared/functions.php", "%s/vendored/lib.php"]
+  },
+  "autoload-dev": {
+    "psr-4": {"Tests\\": "tests/", "": "./"}
+  }
+}`,
+		"src/Core/Kernel.php":       "<?php",
+		"lib/Util.php":              "<?php",
+		"legacy/Legacy/Foo.php":     "<?php",
+		"modules/auth/src/Auth.php": "<?php",
+		"modules/blog/src/Blog.php": "<?php",
+		"helpers.php":               "<?php",
+		"tests/FooTest.php":         "<?php",
+		"../shared/functions.php":   "<?php",
+		"../vendored/lib.php":       "<?php",
+	}
+	files["composer.json"] = fmt.Sprintf(files["composer.json"], filepath.ToSlash(tempDir))
+	for name, contents := range files {
+		if err := fileutil.WriteFile(filepath.Join(projectRoot, name), []byte(contents)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	autoload, err := loadComposerAutoload(projectRoot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantPaths := []string{
+		"../shared/functions.php", "../vendored/lib.php",
+		"helpers.php", "legacy", "lib", "modules/auth/src", "modules/blog/src", "src",
+	}
+	if !reflect.DeepEqual(autoload.paths, wantPaths) {
+		t.Errorf("autoload paths mismatch:\nhave: %q\nwant: %q", autoload.paths, wantPaths)
+	}
+	if want := []string{"tests"}; !reflect.DeepEqual(autoload.devPaths, want) {
+		t.Errorf("autoload-dev paths mismatch:\nhave: %q\nwant: %q", autoload.devPaths, want)
+	}
+	if !autoload.mapsRoot {
+		t.Errorf("the project root mapping is not detected")
+	}
+	wantWarnings := []string{
+		`composer.json: autoload: "generated/" doesn't exist`,
+	}
+	if !reflect.DeepEqual(autoload.warnings, wantWarnings) {
+		t.Errorf("warnings mismatch:\nhave: %q\nwant: %q", autoload.warnings, wantWarnings)
+	}
+
+	conf := &RunConfig{ProjectRoot: projectRoot + "/", SrcDir: "src/Core"}
+	roots := autoload.sourceRoots(conf)
+	if len(roots) != len(wantPaths) || roots[len(roots)-1] != filepath.Join(projectRoot, "src") {
+		t.Errorf("unexpected source roots: %q", roots)
+	}
+
+	manifest, err := autoload.buildDirManifest(projectRoot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rewritten struct {
+		Autoload struct {
+			Files []string `json:"files"`
+		} `json:"autoload"`
+	}
+	if err := json.Unmarshal(manifest, &rewritten); err != nil {
+		t.Fatal(err)
+	}
+	wantFiles := []string{"helpers.php", "ktest/external/__parent/shared/functions.php", "ktest/external/__parent/vendored/lib.php"}
+	if !reflect.DeepEqual(rewritten.Autoload.Files, wantFiles) {
+		t.Errorf("build dir autoload files mismatch:\nhave: %q\nwant: %q", rewritten.Autoload.Files, wantFiles)
+	}
+}
+
+func TestBuildDirRel(t *testing.T) {
+	tests := []struct {
+		rel  string
+		want string
+	}{
+		{"src", "src"},
+		{"src/Foo.php", "src/Foo.php"},
+		{"..foo/bar", "..foo/bar"},
+		{"..", "ktest/external/__parent"},
+		{"../shared/src", "ktest/external/__parent/shared/src"},
+		{"../../lib", "ktest/external/__parent/__parent/lib"},
+	}
+
+	for _, test := range tests {
+		have := filepath.ToSlash(buildDirRel(filepath.FromSlash(test.rel)))
+		if have != test.want {
+			t.Errorf("buildDirRel(%q): have %q, want %q", test.rel, have, test.want)
+		}
+	}
+}
+
+func TestMirrorPath(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "ktest-mirror")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	projectRoot := filepath.Join(tempDir, "project")
+	for _, name := range []string{"lib/Util.php", "lib/src/Foo.php", "lib/tests/FooTest.php", "lib/tests/fixtures/a.txt"} {
+		if err := fileutil.WriteFile(filepath.Join(projectRoot, name), []byte("<?php")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	r := &runner{
+		conf:     &RunConfig{ProjectRoot: projectRoot + "/"},
+		testDir:  filepath.Join(projectRoot, "lib/tests") + "/",
+		buildDir: filepath.Join(tempDir, "build"),
+	}
+	if err := r.mirrorPath("lib", []string{filepath.Join(projectRoot, "lib/src")}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		symlink bool
+		missing bool
+	}{
+		{name: "lib"},
+		{name: "lib/Util.php", symlink: true},
+		{name: "lib/src", missing: true},
+		{name: "lib/tests"},
+		{name: "lib/tests/FooTest.php", missing: true},
+		{name: "lib/tests/fixtures"},
+		{name: "lib/tests/fixtures/a.txt", symlink: true},
+	}
+	for _, test := range tests {
+		info, err := os.Lstat(filepath.Join(r.buildDir, test.name))
+		if test.missing {
+			if err == nil {
+				t.Errorf("%s: expected to be skipped", test.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if symlink := info.Mode()&os.ModeSymlink != 0; symlink != test.symlink {
+			t.Errorf("%s: symlink=%v, want %v", test.name, symlink, test.symlink)
+		}
+	}
+}
+
+func TestMirrorProjectRoot(t *testing.T) {
+	projectRoot, err := ioutil.TempDir("", "ktest-mirror-root")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(projectRoot)
+
+	for _, name := range []string{"Kernel.php", "src/Foo.php", "coverage/index.html", "composer.json"} {
+		if err := fileutil.WriteFile(filepath.Join(projectRoot, name), []byte("<?php")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The build dir is located inside the mirrored project root.
+	r := &runner{
+		conf:     &RunConfig{ProjectRoot: projectRoot + "/"},
+		testDir:  filepath.Join(projectRoot, "tests") + "/",
+		buildDir: filepath.Join(projectRoot, "build"),
+		quiet:    true,
+	}
+	if err := r.mirrorPath(".", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		symlink bool
+		missing bool
+	}{
+		{name: "Kernel.php", symlink: true},
+		{name: "src", symlink: true},
+		{name: "coverage", missing: true},
+		{name: "composer.json", missing: true},
+		{name: "build", missing: true},
+	}
+	for _, test := range tests {
+		info, err := os.Lstat(filepath.Join(r.buildDir, test.name))
+		if test.missing {
+			if err == nil {
+				t.Errorf("%s: expected to be skipped", test.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if symlink := info.Mode()&os.ModeSymlink != 0; symlink != test.symlink {
+			t.Errorf("%s: symlink=%v, want %v", test.name, symlink, test.symlink)
+		}
+	}
+}
@@ -0,0 +1,326 @@
+package phpunit
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/VKCOM/php-parser/pkg/ast"
+	"github.com/VKCOM/php-parser/pkg/visitor"
+)
+
+// CoverageProfile is a line coverage collected from the instrumented sources.
+type CoverageProfile struct {
+	Files []*FileCoverage
+}
+
+type FileCoverage struct {
+	// Filename is a path relative to the project root.
+	Filename string
+
+	Lines []LineCoverage
+}
+
+type LineCoverage struct {
+	Line int
+	Hits int
+}
+
+// CoveredLines returns the number of lines that were executed at least once.
+func (f *FileCoverage) CoveredLines() int {
+	n := 0
+	for _, l := range f.Lines {
+		if l.Hits != 0 {
+			n++
+		}
+	}
+	return n
+}
+
+// coverageCounter describes a single instrumented statement.
+type coverageCounter struct {
+	file string
+	line int
+}
+
+const coverageRuntimeSource = `<?php
+
+namespace KTest;
+
+class Coverage {
+  /** @var int[] */
+  public static $counters = [];
+
+  public static function init(int $n) {
+    self::$counters = array_fill(0, $n, 0);
+  }
+
+  public static function dump(string $filename) {
+    file_put_contents($filename, implode(',', self::$counters));
+  }
+}
+`
+
+// coverageVisitor inserts the statement counters into a source file.
+type coverageVisitor struct {
+	visitor.Null
+
+	filename string
+	counters []coverageCounter
+	edits    []textEdit
+}
+
+func (v *coverageVisitor) StmtStmtList(n *ast.StmtStmtList) { v.instrumentList(n.Stmts) }
+func (v *coverageVisitor) StmtFunction(n *ast.StmtFunction) { v.instrumentList(n.Stmts) }
+func (v *coverageVisitor) ExprClosure(n *ast.ExprClosure)   { v.instrumentList(n.Stmts) }
+func (v *coverageVisitor) StmtCase(n *ast.StmtCase)         { v.instrumentList(n.Stmts) }
+func (v *coverageVisitor) StmtDefault(n *ast.StmtDefault)   { v.instrumentList(n.Stmts) }
+func (v *coverageVisitor) StmtTry(n *ast.StmtTry)           { v.instrumentList(n.Stmts) }
+func (v *coverageVisitor) StmtCatch(n *ast.StmtCatch)       { v.instrumentList(n.Stmts) }
+func (v *coverageVisitor) StmtFinally(n *ast.StmtFinally)   { v.instrumentList(n.Stmts) }
+func (v *coverageVisitor) StmtIf(n *ast.StmtIf)             { v.instrumentBody(n.Stmt) }
+func (v *coverageVisitor) StmtElseIf(n *ast.StmtElseIf)     { v.instrumentBody(n.Stmt) }
+func (v *coverageVisitor) StmtElse(n *ast.StmtElse)         { v.instrumentBody(n.Stmt) }
+func (v *coverageVisitor) StmtFor(n *ast.StmtFor)           { v.instrumentBody(n.Stmt) }
+func (v *coverageVisitor) StmtForeach(n *ast.StmtForeach)   { v.instrumentBody(n.Stmt) }
+func (v *coverageVisitor) StmtWhile(n *ast.StmtWhile)       { v.instrumentBody(n.Stmt) }
+func (v *coverageVisitor) StmtDo(n *ast.StmtDo)             { v.instrumentBody(n.Stmt) }
+
+func (v *coverageVisitor) instrumentList(list []ast.Vertex) {
+	for _, stmt := range list {
+		if !isExecutableStmt(stmt) {
+			continue
+		}
+		pos := stmt.GetPosition()
+		v.edits = append(v.edits, textEdit{
+			StartPos:    pos.StartPos,
+			EndPos:      pos.StartPos,
+			Replacement: v.newCounter(pos.StartLine) + " ",
+		})
+	}
+}
+
+func (v *coverageVisitor) instrumentBody(body ast.Vertex) {
+	if _, ok := body.(*ast.StmtStmtList); ok {
+		return // Handled by StmtStmtList
+	}
+	if body == nil || !isExecutableStmt(body) {
+		return
+	}
+	// A single statement body needs to be wrapped into {}
+	// as we're inserting a second statement.
+	pos := body.GetPosition()
+	v.edits = append(v.edits,
+		textEdit{
+			StartPos:    pos.StartPos,
+			EndPos:      pos.StartPos,
+			Replacement: "{ " + v.newCounter(pos.StartLine) + " ",
+		},
+		textEdit{
+			StartPos:    pos.EndPos,
+			EndPos:      pos.EndPos,
+			Replacement: " }",
+		},
+	)
+}
+
+func (v *coverageVisitor) newCounter(line int) string {
+	id := len(v.counters)
+	v.counters = append(v.counters, coverageCounter{file: v.filename, line: line})
+	return fmt.Sprintf(`\KTest\Coverage::$counters[%d]++;`, id)
+}
+
+func isExecutableStmt(n ast.Vertex) bool {
+	switch n.(type) {
+	case *ast.StmtNop, *ast.StmtFunction, *ast.StmtClass, *ast.StmtInterface, *ast.StmtTrait,
+		*ast.StmtInlineHtml, *ast.StmtLabel, *ast.StmtHaltCompiler, *ast.StmtDeclare:
+		return false
+	default:
+		return true
+	}
+}
+
+func parseCoverageCounters(data []byte) ([]int, error) {
+	s := strings.TrimSpace(string(data))
+	if s == "" {
+		return nil, nil
+	}
+	parts := strings.Split(s, ",")
+	counters := make([]int, len(parts))
+	for i, p := range parts {
+		v, err := strconv.Atoi(p)
+		if err != nil {
+			return nil, err
+		}
+		counters[i] = v
+	}
+	return counters, nil
+}
+
+func buildCoverageProfile(counters []coverageCounter, hits []int) *CoverageProfile {
+	type lineKey struct {
+		file string
+		line int
+	}
+	lineHits := make(map[lineKey]int)
+	for i, c := range counters {
+		key := lineKey{file: c.file, line: c.line}
+		if n, ok := lineHits[key]; !ok || hits[i] > n {
+			lineHits[key] = hits[i]
+		}
+	}
+
+	files := make(map[string]*FileCoverage)
+	for key, n := range lineHits {
+		f := files[key.file]
+		if f == nil {
+			f = &FileCoverage{Filename: key.file}
+			files[key.file] = f
+		}
+		f.Lines = append(f.Lines, LineCoverage{Line: key.line, Hits: n})
+	}
+
+	profile := &CoverageProfile{}
+	for _, f := range files {
+		sort.Slice(f.Lines, func(i, j int) bool {
+			return f.Lines[i].Line < f.Lines[j].Line
+		})
+		profile.Files = append(profile.Files, f)
+	}
+	sort.Slice(profile.Files, func(i, j int) bool {
+		return profile.Files[i].Filename < profile.Files[j].Filename
+	})
+	return profile
+}
+
+// WriteCoverageLcov writes the coverage profile in the lcov tracefile format.
+// root is used to turn the relative source file names into absolute paths.
+func WriteCoverageLcov(w io.Writer, root string, profile *CoverageProfile) error {
+	var buf strings.Builder
+	for _, f := range profile.Files {
+		buf.WriteString("TN:\n")
+		fmt.Fprintf(&buf, "SF:%s\n", strings.TrimSuffix(root, "/")+"/"+f.Filename)
+		for _, l := range f.Lines {
+			fmt.Fprintf(&buf, "DA:%d,%d\n", l.Line, l.Hits)
+		}
+		fmt.Fprintf(&buf, "LF:%d\n", len(f.Lines))
+		fmt.Fprintf(&buf, "LH:%d\n", f.CoveredLines())
+		buf.WriteString("end_of_record\n")
+	}
+	_, err := io.WriteString(w, buf.String())
+	return err
+}
+
+type coberturaCoverage struct {
+	XMLName      xml.Name           `xml:"coverage"`
+	LineRate     string             `xml:"line-rate,attr"`
+	BranchRate   string             `xml:"branch-rate,attr"`
+	LinesCovered int                `xml:"lines-covered,attr"`
+	LinesValid   int                `xml:"lines-valid,attr"`
+	Version      string             `xml:"version,attr"`
+	Timestamp    int64              `xml:"timestamp,attr"`
+	Sources      []string           `xml:"sources>source"`
+	Packages     []coberturaPackage `xml:"packages>package"`
+}
+
+type coberturaPackage struct {
+	Name       string           `xml:"name,attr"`
+	LineRate   string           `xml:"line-rate,attr"`
+	BranchRate string           `xml:"branch-rate,attr"`
+	Classes    []coberturaClass `xml:"classes>class"`
+}
+
+type coberturaClass struct {
+	Name       string          `xml:"name,attr"`
+	Filename   string          `xml:"filename,attr"`
+	LineRate   string          `xml:"line-rate,attr"`
+	BranchRate string          `xml:"branch-rate,attr"`
+	Methods    struct{}        `xml:"methods"`
+	Lines      []coberturaLine `xml:"lines>line"`
+}
+
+type coberturaLine struct {
+	Number int `xml:"number,attr"`
+	Hits   int `xml:"hits,attr"`
+}
+
+// WriteCoverageCobertura writes the coverage profile in the Cobertura XML format.
+func WriteCoverageCobertura(w io.Writer, root string, profile *CoverageProfile) error {
+	lineRate := func(covered, total int) string {
+		if total == 0 {
+			return "1"
+		}
+		return strconv.FormatFloat(float64(covered)/float64(total), 'f', 4, 64)
+	}
+
+	doc := coberturaCoverage{
+		BranchRate: "0",
+		Version:    "ktest",
+		Timestamp:  time.Now().Unix(),
+		Sources:    []string{strings.TrimSuffix(root, "/")},
+	}
+	pkg := coberturaPackage{Name: "main", BranchRate: "0"}
+	for _, f := range profile.Files {
+		covered := f.CoveredLines()
+		doc.LinesCovered += covered
+		doc.LinesValid += len(f.Lines)
+		class := coberturaClass{
+			Name:       f.Filename,
+			Filename:   f.Filename,
+			LineRate:   lineRate(covered, len(f.Lines)),
+			BranchRate: "0",
+		}
+		for _, l := range f.Lines {
+			class.Lines = append(class.Lines, coberturaLine{Number: l.Line, Hits: l.Hits})
+		}
+		pkg.Classes = append(pkg.Classes, class)
+	}
+	doc.LineRate = lineRate(doc.LinesCovered, doc.LinesValid)
+	pkg.LineRate = doc.LineRate
+	doc.Packages = []coberturaPackage{pkg}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(doc); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// FormatCoverage prints a per-file coverage summary.
+func FormatCoverage(w io.Writer, profile *CoverageProfile) {
+	percent := func(covered, total int) float64 {
+		if total == 0 {
+			return 100
+		}
+		return float64(covered) / float64(total) * 100
+	}
+
+	width := len("Total")
+	for _, f := range profile.Files {
+		if len(f.Filename) > width {
+			width = len(f.Filename)
+		}
+	}
+
+	fmt.Fprintf(w, "\nCode Coverage:\n\n")
+	coveredTotal := 0
+	linesTotal := 0
+	for _, f := range profile.Files {
+		covered := f.CoveredLines()
+		coveredTotal += covered
+		linesTotal += len(f.Lines)
+		fmt.Fprintf(w, "  %-*s  %6.2f%% (%d/%d)\n",
+			width, f.Filename, percent(covered, len(f.Lines)), covered, len(f.Lines))
+	}
+	fmt.Fprintf(w, "  %-*s  %6.2f%% (%d/%d)\n",
+		width, "Total", percent(coveredTotal, linesTotal), coveredTotal, linesTotal)
+}
@@ -0,0 +1,51 @@
+package phpunit
+
+import (
+	"testing"
+
+	"github.com/VKCOM/php-parser/pkg/visitor/traverser"
+	"github.com/quasilyte/ktest/internal/phpsyntax"
+)
+
+func TestCoverageInstrumentation(t *testing.T) {
+	src := `<?php
+class Foo {
+  public function f($x) {
+    if ($x) return 1;
+    foreach ([1, 2] as $y) {
+      $x += $y;
+    }
+    return $x;
+  }
+}
+`
+	want := `<?php
+class Foo {
+  public function f($x) {
+    \KTest\Coverage::$counters[0]++; if ($x) { \KTest\Coverage::$counters[3]++; return 1; }
+    \KTest\Coverage::$counters[1]++; foreach ([1, 2] as $y) {
+      \KTest\Coverage::$counters[4]++; $x += $y;
+    }
+    \KTest\Coverage::$counters[2]++; return $x;
+  }
+}
+`
+
+	rootNode, parserErrors := phpsyntax.Parse([]byte(src), testPHPVersion)
+	if len(parserErrors) != 0 {
+		t.Fatalf("parse: %v", parserErrors)
+	}
+	v := &coverageVisitor{filename: "src/Foo.php"}
+	traverser.NewTraverser(v).Traverse(rootNode)
+	have := string(applyTextEdits([]byte(src), v.edits))
+	if have != want {
+		t.Errorf("instrumented code mismatch:\nhave:\n%s\nwant:\n%s", have, want)
+	}
+
+	wantLines := []int{4, 5, 8, 4, 6}
+	for i, c := range v.counters {
+		if c.line != wantLines[i] {
+			t.Errorf("counter %d: have line %d, want %d", i, c.line, wantLines[i])
+		}
+	}
+}
@@ -0,0 +1,234 @@
+package phpunit
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/VKCOM/php-parser/pkg/ast"
+	"github.com/VKCOM/php-parser/pkg/version"
+	"github.com/VKCOM/php-parser/pkg/visitor"
+	"github.com/VKCOM/php-parser/pkg/visitor/traverser"
+	"github.com/quasilyte/ktest/internal/phpsyntax"
+)
+
+// depsIndex describes which files a PHP file depends on.
+//
+// The dependencies are approximated: every class-like name
+// mentioned inside a file is resolved to a fully qualified name and
+// then matched against the classes declared in the indexed files.
+// Literal require/include paths are recorded as well.
+type depsIndex struct {
+	// classes maps lowercased fully qualified class names to their files.
+	classes map[string]string
+
+	files map[string]*fileDeps
+
+	phpVersion *version.Version
+}
+
+type fileDeps struct {
+	declared []string
+	refs     []string
+	requires []string
+}
+
+func newDepsIndex(phpVersion *version.Version) *depsIndex {
+	return &depsIndex{
+		classes:    make(map[string]string),
+		files:      make(map[string]*fileDeps),
+		phpVersion: phpVersion,
+	}
+}
+
+// AddDir indexes all PHP files under the specified root.
+// Files that can't be parsed are ignored.
+func (index *depsIndex) AddDir(root string) error {
+	return filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || !strings.HasSuffix(path, ".php") {
+			return nil
+		}
+		return index.AddFile(path)
+	})
+}
+
+// AddFile indexes a single PHP file.
+// Parse errors are not reported, the file is indexed as a file without dependencies.
+func (index *depsIndex) AddFile(filename string) error {
+	src, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	deps := &fileDeps{}
+	index.files[filename] = deps
+	rootNode, parserErrors := phpsyntax.Parse(src, index.phpVersion)
+	if len(parserErrors) != 0 {
+		return nil
+	}
+	v := &depsVisitor{
+		out:  deps,
+		dir:  filepath.Dir(filename),
+		uses: make(map[string]string),
+	}
+	traverser.NewTraverser(v).Traverse(rootNode)
+	for _, className := range deps.declared {
+		index.classes[className] = filename
+	}
+	return nil
+}
+
+// Closure returns all files that are reachable from the given file, including itself.
+func (index *depsIndex) Closure(filename string) map[string]bool {
+	visited := make(map[string]bool)
+	queue := []string{filename}
+	for len(queue) != 0 {
+		f := queue[0]
+		queue = queue[1:]
+		if visited[f] {
+			continue
+		}
+		visited[f] = true
+		deps := index.files[f]
+		if deps == nil {
+			continue
+		}
+		for _, ref := range deps.refs {
+			if depFile, ok := index.classes[ref]; ok {
+				queue = append(queue, depFile)
+			}
+		}
+		queue = append(queue, deps.requires...)
+	}
+	return visited
+}
+
+type depsVisitor struct {
+	visitor.Null
+	out *fileDeps
+
+	dir string
+
+	namespace string
+	uses      map[string]string
+}
+
+func (v *depsVisitor) StmtNamespace(n *ast.StmtNamespace) {
+	v.namespace = ""
+	v.uses = make(map[string]string)
+	if name, ok := n.Name.(*ast.Name); ok {
+		v.namespace = astNameToString(name)
+	}
+}
+
+func (v *depsVisitor) StmtUse(n *ast.StmtUseList) {
+	if n.Type != nil {
+		return
+	}
+	v.addUses("", n.Uses)
+}
+
+func (v *depsVisitor) StmtGroupUse(n *ast.StmtGroupUseList) {
+	if n.Type != nil {
+		return
+	}
+	prefix := ""
+	if name, ok := n.Prefix.(*ast.Name); ok {
+		prefix = astNameToString(name) + `\`
+	}
+	v.addUses(prefix, n.Uses)
+}
+
+func (v *depsVisitor) addUses(prefix string, uses []ast.Vertex) {
+	for _, u := range uses {
+		u, ok := u.(*ast.StmtUse)
+		if !ok || u.Type != nil {
+			continue
+		}
+		name, ok := u.Use.(*ast.Name)
+		if !ok {
+			continue
+		}
+		fqn := prefix + astNameToString(name)
+		alias := string(name.Parts[len(name.Parts)-1].(*ast.NamePart).Value)
+		if ident, ok := u.Alias.(*ast.Identifier); ok {
+			alias = string(ident.Value)
+		}
+		v.uses[strings.ToLower(alias)] = fqn
+		v.addRef(fqn)
+	}
+}
+
+func (v *depsVisitor) StmtClass(n *ast.StmtClass) {
+	v.addDeclared(n.Name)
+}
+
+func (v *depsVisitor) StmtInterface(n *ast.StmtInterface) {
+	v.addDeclared(n.Name)
+}
+
+func (v *depsVisitor) StmtTrait(n *ast.StmtTrait) {
+	v.addDeclared(n.Name)
+}
+
+func (v *depsVisitor) NameName(n *ast.Name) {
+	parts := namePartsToStrings(n.Parts)
+	if fqn, ok := v.uses[strings.ToLower(parts[0])]; ok {
+		parts[0] = fqn
+		v.addRef(strings.Join(parts, `\`))
+		return
+	}
+	v.addRef(v.qualify(strings.Join(parts, `\`)))
+}
+
+func (v *depsVisitor) NameFullyQualified(n *ast.NameFullyQualified) {
+	v.addRef(strings.Join(namePartsToStrings(n.Parts), `\`))
+}
+
+func (v *depsVisitor) NameRelative(n *ast.NameRelative) {
+	v.addRef(v.qualify(strings.Join(namePartsToStrings(n.Parts), `\`)))
+}
+
+func (v *depsVisitor) ExprRequire(n *ast.ExprRequire)         { v.addRequire(n.Expr) }
+func (v *depsVisitor) ExprRequireOnce(n *ast.ExprRequireOnce) { v.addRequire(n.Expr) }
+func (v *depsVisitor) ExprInclude(n *ast.ExprInclude)         { v.addRequire(n.Expr) }
+func (v *depsVisitor) ExprIncludeOnce(n *ast.ExprIncludeOnce) { v.addRequire(n.Expr) }
+
+func (v *depsVisitor) addRequire(e ast.Vertex) {
+	switch e := e.(type) {
+	case *ast.ScalarString:
+		v.out.requires = append(v.out.requires, filepath.Join(v.dir, unquotePHPString(e.Value)))
+	case *ast.ExprBinaryConcat:
+		magic, ok := e.Left.(*ast.ScalarMagicConstant)
+		if !ok || string(magic.Value) != "__DIR__" {
+			return
+		}
+		path, ok := e.Right.(*ast.ScalarString)
+		if !ok {
+			return
+		}
+		v.out.requires = append(v.out.requires, filepath.Join(v.dir, unquotePHPString(path.Value)))
+	}
+}
+
+func (v *depsVisitor) addDeclared(name ast.Vertex) {
+	ident, ok := name.(*ast.Identifier)
+	if !ok {
+		return
+	}
+	v.out.declared = append(v.out.declared, strings.ToLower(v.qualify(string(ident.Value))))
+}
+
+func (v *depsVisitor) addRef(fqn string) {
+	v.out.refs = append(v.out.refs, strings.ToLower(fqn))
+}
+
+func (v *depsVisitor) qualify(name string) string {
+	if v.namespace == "" {
+		return name
+	}
+	return v.namespace + `\` + name
+}
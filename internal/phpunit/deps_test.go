@@ -0,0 +1,64 @@
+package phpunit
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/VKCOM/php-parser/pkg/version"
+	"github.com/quasilyte/ktest/internal/fileutil"
+)
+
+var testPHPVersion = &version.Version{Major: 7, Minor: 4}
+
+func TestDepsIndex(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ktest-deps")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"src/Strings.php": `<?php
+namespace Lib;
+class Strings { public static function f() { return Util\Arrays::g(); } }`,
+		"src/Util/Arrays.php": `<?php
+namespace Lib\Util;
+class Arrays { public static function g() { return 1; } }`,
+		"src/Unused.php": `<?php
+namespace Lib;
+class Unused {}`,
+		"tests/StringsTest.php": `<?php
+use Lib\Strings as S;
+require_once __DIR__ . '/helpers.php';
+class StringsTest { public function testF() { S::f(); } }`,
+		"tests/helpers.php": `<?php
+function helper() {}`,
+	}
+	for name, contents := range files {
+		if err := fileutil.WriteFile(filepath.Join(dir, name), []byte(contents)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	index := newDepsIndex(testPHPVersion)
+	if err := index.AddDir(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	closure := index.Closure(filepath.Join(dir, "tests/StringsTest.php"))
+	expected := map[string]bool{
+		"tests/StringsTest.php": true,
+		"tests/helpers.php":     true,
+		"src/Strings.php":       true,
+		"src/Util/Arrays.php":   true,
+		"src/Unused.php":        false,
+	}
+	for name, want := range expected {
+		have := closure[filepath.Join(dir, name)]
+		if have != want {
+			t.Errorf("%s: have %v, want %v", name, have, want)
+		}
+	}
+}
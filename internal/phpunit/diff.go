@@ -0,0 +1,101 @@
+package phpunit
+
+import (
+	"strings"
+)
+
+// unifiedDiff returns a PHPUnit-style line diff of two texts.
+// Like PHPUnit, it doesn't print the line numbers inside the hunk headers.
+func unifiedDiff(expected, actual string) string {
+	const contextLines = 3
+
+	a := strings.Split(expected, "\n")
+	b := strings.Split(actual, "\n")
+	ops := diffLines(a, b)
+
+	var buf strings.Builder
+	buf.WriteString("--- Expected\n")
+	buf.WriteString("+++ Actual\n")
+
+	// Group the changed lines into hunks; the changes that are
+	// close to each other share the context lines.
+	type hunk struct{ from, to int }
+	var hunks []hunk
+	for i, op := range ops {
+		if op.kind == ' ' {
+			continue
+		}
+		if len(hunks) != 0 && i-hunks[len(hunks)-1].to <= 2*contextLines {
+			hunks[len(hunks)-1].to = i
+			continue
+		}
+		hunks = append(hunks, hunk{from: i, to: i})
+	}
+
+	for _, h := range hunks {
+		from := h.from - contextLines
+		if from < 0 {
+			from = 0
+		}
+		to := h.to + contextLines
+		if to >= len(ops) {
+			to = len(ops) - 1
+		}
+		buf.WriteString("@@ @@\n")
+		for _, op := range ops[from : to+1] {
+			buf.WriteByte(op.kind)
+			buf.WriteString(op.line)
+			buf.WriteByte('\n')
+		}
+	}
+
+	return strings.TrimSuffix(buf.String(), "\n")
+}
+
+type diffOp struct {
+	kind byte // ' ', '-' or '+'
+	line string
+}
+
+// diffLines computes the line edit script using the longest common subsequence.
+func diffLines(a, b []string) []diffOp {
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	ops := make([]diffOp, 0, len(a)+len(b))
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			ops = append(ops, diffOp{kind: ' ', line: a[i]})
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			ops = append(ops, diffOp{kind: '-', line: a[i]})
+			i++
+		default:
+			ops = append(ops, diffOp{kind: '+', line: b[j]})
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		ops = append(ops, diffOp{kind: '-', line: a[i]})
+	}
+	for ; j < len(b); j++ {
+		ops = append(ops, diffOp{kind: '+', line: b[j]})
+	}
+	return ops
+}
@@ -0,0 +1,65 @@
+package phpunit
+
+import (
+	"testing"
+)
+
+func TestValuesDiff(t *testing.T) {
+	tests := []struct {
+		expected string
+		actual   string
+		want     string
+	}{
+		{
+			expected: `["array", [[["int", "0"], ["int", "1"]], [["int", "1"], ["int", "2"]], [["int", "2"], ["int", "3"]]]]`,
+			actual:   `["array", [[["int", "0"], ["int", "1"]], [["int", "1"], ["int", "5"]], [["int", "2"], ["int", "3"]]]]`,
+			want: `--- Expected
++++ Actual
+@@ @@
+ Array &0 (
+     0 => 1
+-    1 => 2
++    1 => 5
+     2 => 3
+ )`,
+		},
+		{
+			expected: `["array", [[["string", "b"], ["float", "1.5"]], [["string", "a"], ["array", [[["int", "5"], ["string", "x"]]]]]]]`,
+			actual:   `["array", [[["string", "b"], ["int", "1"]], [["string", "a"], ["array", [[["int", "5"], ["string", "x"]]]]]]]`,
+			want: `--- Expected
++++ Actual
+@@ @@
+ Array &0 (
+-    'b' => 1.5
++    'b' => 1
+     'a' => Array &1 (
+         5 => 'x'
+     )`,
+		},
+		{
+			expected: `["string", "foo\nbar\nbaz"]`,
+			actual:   `["string", "foo\nbaz"]`,
+			want: `--- Expected
++++ Actual
+@@ @@
+ 'foo\n
+-bar\n
+ baz'`,
+		},
+	}
+
+	for _, test := range tests {
+		expected, err := decodeTypedValue([]byte(test.expected))
+		if err != nil {
+			t.Fatalf("decode %s: %v", test.expected, err)
+		}
+		actual, err := decodeTypedValue([]byte(test.actual))
+		if err != nil {
+			t.Fatalf("decode %s: %v", test.actual, err)
+		}
+		have := unifiedDiff(exportValue(expected), exportValue(actual))
+		if have != test.want {
+			t.Errorf("diff mismatch:\nhave:\n%s\nwant:\n%s", have, test.want)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package phpunit
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// exportValue renders a value the way SebastianBergmann\Exporter\Exporter::export does.
+//
+// PHPUnit uses this representation in its failure messages and diffs,
+// so ktest output can be compared with the PHPUnit output line-for-line.
+func exportValue(v *phpValue) string {
+	e := exporter{}
+	var buf strings.Builder
+	e.export(&buf, v, 0)
+	return buf.String()
+}
+
+type exporter struct {
+	// numArrays is used to assign the "&N" array IDs in the visiting order.
+	numArrays int
+}
+
+func (e *exporter) export(buf *strings.Builder, v *phpValue, indentation int) {
+	switch v.kind {
+	case valueNull:
+		buf.WriteString("null")
+	case valueBool, valueInt:
+		buf.WriteString(v.repr)
+	case valueFloat:
+		buf.WriteString(floatRepr(v.repr))
+	case valueString:
+		buf.WriteString(exportString(v.repr))
+	case valueArray:
+		id := e.numArrays
+		e.numArrays++
+		fmt.Fprintf(buf, "Array &%d (", id)
+		if len(v.items) == 0 {
+			buf.WriteString(")")
+			return
+		}
+		whitespace := strings.Repeat(" ", 4*indentation)
+		buf.WriteString("\n")
+		for _, item := range v.items {
+			buf.WriteString(whitespace)
+			buf.WriteString("    ")
+			e.export(buf, item.key, indentation)
+			buf.WriteString(" => ")
+			e.export(buf, item.value, indentation+1)
+			buf.WriteString("\n")
+		}
+		buf.WriteString(whitespace)
+		buf.WriteString(")")
+	}
+}
+
+func exportString(s string) string {
+	// Like the exporter, treat strings with most of the non-printable
+	// chars as binary; it's an equivalent of the /[^\x09-\x0d\x1b\x20-\xff]/ regexp.
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c < 0x09 || (c > 0x0d && c < 0x20 && c != 0x1b) {
+			return "Binary String: 0x" + hex.EncodeToString([]byte(s))
+		}
+	}
+	// Line breaks are printed as escape sequences followed by a real newline.
+	// The replacements are sequential, like str_replace with arrays does.
+	for _, r := range lineBreakReplacements {
+		s = strings.ReplaceAll(s, r[0], r[1])
+	}
+	return "'" + strings.ReplaceAll(s, "<lf>", "\n") + "'"
+}
+
+var lineBreakReplacements = [][2]string{
+	{"\r\n", `\r\n<lf>`},
+	{"\n\r", `\n\r<lf>`},
+	{"\r", `\r<lf>`},
+	{"\n", `\n<lf>`},
+}
+
+// floatRepr makes sure that float value doesn't look like an int.
+func floatRepr(repr string) string {
+	if strings.Trim(repr, "-0123456789") == "" {
+		return repr + ".0"
+	}
+	return repr
+}
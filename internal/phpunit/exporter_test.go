@@ -0,0 +1,45 @@
+package phpunit
+
+import (
+	"testing"
+)
+
+func TestExportValue(t *testing.T) {
+	tests := []struct {
+		encoded string
+		want    string
+	}{
+		{`["null"]`, `null`},
+		{`["bool", "false"]`, `false`},
+		{`["int", "1"]`, `1`},
+		{`["float", "1"]`, `1.0`},
+		{`["float", "0.30000000000000004"]`, `0.30000000000000004`},
+		{`["float", "-INF"]`, `-INF`},
+		{`["string", "1"]`, `'1'`},
+		{`["string", "it's"]`, `'it's'`},
+		{`["string", "a\r\nb\n"]`, "'a\\r\\n\nb\\n\n'"},
+		{`["bytes", "AAE="]`, `Binary String: 0x0001`},
+		{`["array", []]`, `Array &0 ()`},
+		{
+			`["array", [[["int", "0"], ["string", "a"]], [["string", "x"], ["array", [[["int", "5"], ["float", "2.5"]]]]], [["int", "1"], ["array", []]]]]`,
+			`Array &0 (
+    0 => 'a'
+    'x' => Array &1 (
+        5 => 2.5
+    )
+    1 => Array &2 ()
+)`,
+		},
+	}
+
+	for _, test := range tests {
+		v, err := decodeTypedValue([]byte(test.encoded))
+		if err != nil {
+			t.Fatalf("decode %s: %v", test.encoded, err)
+		}
+		have := exportValue(v)
+		if have != test.want {
+			t.Errorf("export %s:\nhave: %s\nwant: %s", test.encoded, have, test.want)
+		}
+	}
+}
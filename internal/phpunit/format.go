@@ -4,6 +4,9 @@ import (
 	"fmt"
 	"io"
 	"path/filepath"
+	"sort"
+	"strings"
+	"time"
 )
 
 func formatResult(w io.Writer, conf *FormatConfig, result *RunResult) {
@@ -13,10 +16,65 @@ func formatResult(w io.Writer, conf *FormatConfig, result *RunResult) {
 		fmt.Fprint(w, "\n")
 	}
 
-	if len(result.Failures) != 0 {
+	if conf.Verbose {
+		formatClassTimings(w, result)
+	}
+
+	buildErrorGroups := GroupBuildErrors(result.BuildErrors)
+	if len(buildErrorGroups) != 0 {
+		if len(buildErrorGroups) == 1 {
+			fmt.Fprintf(w, "There was 1 build error:\n\n")
+		} else {
+			fmt.Fprintf(w, "There were %d build errors:\n\n", len(buildErrorGroups))
+		}
+		for i, g := range buildErrorGroups {
+			fmt.Fprintf(w, "%d) ", i+1)
+			if len(g.Diagnostics) == 0 {
+				fmt.Fprintf(w, "%s\n", g.Output)
+			}
+			for _, d := range g.Diagnostics {
+				formatBuildDiagnostic(w, d, conf.ShortLocation)
+			}
+			fmt.Fprintf(w, "\nAffected test classes: %s\n\n", strings.Join(g.TestClasses, ", "))
+		}
+	}
+
+	if len(result.UpdatedSnapshots) != 0 {
+		fmt.Fprintf(w, "Updated %d snapshots:\n", len(result.UpdatedSnapshots))
+		for _, filename := range result.UpdatedSnapshots {
+			fmt.Fprintf(w, "  %s\n", filename)
+		}
+		fmt.Fprintln(w)
+	}
+
+	if result.NotRun != 0 {
+		fmt.Fprintf(w, "Stopped early, %d tests were not run.\n\n", result.NotRun)
+	}
+
+	if len(result.Flaky) != 0 {
+		if len(result.Flaky) == 1 {
+			fmt.Fprintf(w, "There was 1 flaky test:\n\n")
+		} else {
+			fmt.Fprintf(w, "There were %d flaky tests:\n\n", len(result.Flaky))
+		}
+		for i, flaky := range result.Flaky {
+			fmt.Fprintf(w, "%d) %s (passed on retry %d)\n", i+1, flaky.Name, flaky.Retries)
+			for _, failure := range flaky.Failures {
+				fmt.Fprintf(w, "%s.\n", failure.Reason)
+				if conf.ShortLocation {
+					fmt.Fprintf(w, "%s:%d\n", filepath.Base(failure.File), failure.Line)
+				} else {
+					fmt.Fprintf(w, "%s:%d\n", failure.File, failure.Line)
+				}
+			}
+			fmt.Fprintln(w)
+		}
+	}
+
+	if len(result.Failures) != 0 || len(result.BuildErrors) != 0 {
 		if len(result.Failures) == 1 {
 			fmt.Fprintf(w, "There was 1 failure:\n\n")
-		} else {
+		} else if len(result.Failures) > 1 {
 			fmt.Fprintf(w, "There were %d failures:\n\n", len(result.Failures))
 		}
 
@@ -25,7 +83,19 @@ func formatResult(w io.Writer, conf *FormatConfig, result *RunResult) {
 			if failure.Message != "" {
 				fmt.Fprintf(w, "%s\n", failure.Message)
 			}
-			fmt.Fprintf(w, "%s.\n\n", failure.Reason)
+			if failure.Diff != nil && !conf.CompactDiff {
+				fmt.Fprintf(w, "%s.\n", failure.Diff.Reason)
+				fmt.Fprintf(w, "%s\n\n", unifiedDiff(failure.Diff.Expected, failure.Diff.Actual))
+			} else {
+				fmt.Fprintf(w, "%s.\n\n", failure.Reason)
+			}
+			if p := failure.Property; p != nil {
+				fmt.Fprintf(w, "Property falsified after %d runs and %d shrinks (seed %d), counterexample:\n%s\n\n",
+					p.Runs, p.Shrinks, p.Seed, p.Counterexample)
+			}
+			if failure.Output != "" {
+				fmt.Fprintf(w, "Test output:\n%s\n\n", strings.TrimSuffix(failure.Output, "\n"))
+			}
 			if conf.ShortLocation {
 				fmt.Fprintf(w, "%s:%d\n\n", filepath.Base(failure.File), failure.Line)
 			} else {
@@ -33,10 +103,118 @@ func formatResult(w io.Writer, conf *FormatConfig, result *RunResult) {
 			}
 		}
 		fmt.Fprintln(w, "FAILURES!")
-		fmt.Fprintf(w, "Tests: %d, Assertions: %d, Failures: %d.\n",
-			result.Tests, result.Assertions, len(result.Failures))
+		fmt.Fprintf(w, "Tests: %d, Assertions: %d, Failures: %d", result.Tests, result.Assertions, len(result.Failures))
+		if len(result.BuildErrors) != 0 {
+			fmt.Fprintf(w, ", Build errors: %d", len(result.BuildErrors))
+		}
+		if len(result.Flaky) != 0 {
+			fmt.Fprintf(w, ", Flaky: %d", len(result.Flaky))
+		}
+		fmt.Fprintln(w, ".")
+	} else if len(result.Flaky) != 0 {
+		fmt.Fprintln(w, "OK, but some tests are flaky!")
+		fmt.Fprintf(w, "Tests: %d, Assertions: %d, Flaky: %d.\n",
+			result.Tests, result.Assertions, len(result.Flaky))
 	} else {
 		fmt.Fprintf(w, "OK (%d tests, %d assertions)\n",
 			result.Tests, result.Assertions)
 	}
+
+	if conf.Slowest != 0 {
+		formatSlowestTests(w, result, conf.Slowest)
+	}
+
+	if result.Coverage != nil {
+		FormatCoverage(w, result.Coverage)
+	}
+}
+
+func formatClassTimings(w io.Writer, result *RunResult) {
+	fmt.Fprintf(w, "Test classes:\n\n")
+	for _, class := range result.Classes {
+		switch {
+		case class.BuildError != nil:
+			fmt.Fprintf(w, "%s (compile: %s, build error)\n", class.ClassName, formatDuration(class.CompileTime))
+			continue
+		case class.Err != nil:
+			fmt.Fprintf(w, "%s (compile: %s, run: %s, run error)\n",
+				class.ClassName, formatDuration(class.CompileTime), formatDuration(class.RunTime))
+			continue
+		}
+		if class.MaxRSS != 0 {
+			fmt.Fprintf(w, "%s (compile: %s, run: %s, max RSS: %s)\n",
+				class.ClassName, formatDuration(class.CompileTime), formatDuration(class.RunTime), formatBytes(class.MaxRSS))
+		} else {
+			fmt.Fprintf(w, "%s (compile: %s, run: %s)\n",
+				class.ClassName, formatDuration(class.CompileTime), formatDuration(class.RunTime))
+		}
+		for _, m := range class.Methods {
+			usage := formatBytes(m.MemoryUsage)
+			if m.MemoryUsage >= 0 {
+				usage = "+" + usage
+			}
+			fmt.Fprintf(w, "  %s %s, memory: %s, peak: %s\n", m.Name, formatDuration(m.Time), usage, formatBytes(m.PeakMemory))
+		}
+	}
+	fmt.Fprintln(w)
+}
+
+func formatSlowestTests(w io.Writer, result *RunResult, n int) {
+	type methodTime struct {
+		name    string
+		elapsed time.Duration
+	}
+	var methods []methodTime
+	for _, class := range result.Classes {
+		for _, m := range class.Methods {
+			methods = append(methods, methodTime{name: class.ClassName + "::" + m.Name, elapsed: m.Time})
+		}
+	}
+	if len(methods) == 0 {
+		return
+	}
+	sort.SliceStable(methods, func(i, j int) bool {
+		return methods[i].elapsed > methods[j].elapsed
+	})
+	if len(methods) > n {
+		methods = methods[:n]
+	}
+
+	fmt.Fprintf(w, "\nSlowest %d tests:\n\n", len(methods))
+	for i, m := range methods {
+		fmt.Fprintf(w, "%d) %s %s\n", i+1, m.name, formatDuration(m.elapsed))
+	}
+}
+
+// formatDuration rounds the duration to make it readable.
+func formatDuration(d time.Duration) string {
+	switch {
+	case d >= time.Second:
+		return d.Round(time.Millisecond).String()
+	case d >= time.Millisecond:
+		return d.Round(10 * time.Microsecond).String()
+	default:
+		return d.Round(time.Microsecond).String()
+	}
+}
+
+// formatBytes prints the size using the binary units.
+func formatBytes(n int64) string {
+	sign := ""
+	if n < 0 {
+		sign = "-"
+		n = -n
+	}
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%s%d B", sign, n)
+	}
+	size := float64(n) / unit
+	for _, suffix := range []string{"KiB", "MiB"} {
+		if size < unit {
+			return fmt.Sprintf("%s%.1f %s", sign, size, suffix)
+		}
+		size /= unit
+	}
+	return fmt.Sprintf("%s%.1f GiB", sign, size)
 }
@@ -0,0 +1,57 @@
+package phpunit
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFormatTimings(t *testing.T) {
+	result := &RunResult{
+		Tests:      3,
+		Assertions: 3,
+		Classes: []*ClassResult{
+			{
+				ClassName:   "FooTest",
+				CompileTime: 2512 * time.Millisecond,
+				RunTime:     31 * time.Millisecond,
+				MaxRSS:      12 << 20,
+				Methods: []MethodResult{
+					{Name: "testA", Time: 1500 * time.Microsecond, MemoryUsage: 1536, PeakMemory: 3 << 20},
+					{Name: "testB", Time: 20 * time.Millisecond, MemoryUsage: -100},
+				},
+			},
+			{
+				ClassName:   "BarTest",
+				CompileTime: 1200 * time.Millisecond,
+				RunTime:     12 * time.Millisecond,
+				Methods: []MethodResult{
+					{Name: "testC", Time: 3 * time.Millisecond},
+				},
+			},
+		},
+	}
+
+	var buf bytes.Buffer
+	formatResult(&buf, &FormatConfig{Verbose: true, Slowest: 2}, result)
+	want := `
+Test classes:
+
+FooTest (compile: 2.512s, run: 31ms, max RSS: 12.0 MiB)
+  testA 1.5ms, memory: +1.5 KiB, peak: 3.0 MiB
+  testB 20ms, memory: -100 B, peak: 0 B
+BarTest (compile: 1.2s, run: 12ms)
+  testC 3ms, memory: +0 B, peak: 0 B
+
+OK (3 tests, 3 assertions)
+
+Slowest 2 tests:
+
+1) FooTest::testB 20ms
+2) BarTest::testC 3ms
+`
+	if have := buf.String(); have != want {
+		t.Errorf("output mismatch:\nhave:\n%s\nwant:\n%s", have, strings.TrimSpace(want))
+	}
+}
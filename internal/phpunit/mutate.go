@@ -0,0 +1,443 @@
+package phpunit
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/VKCOM/php-parser/pkg/ast"
+	"github.com/VKCOM/php-parser/pkg/token"
+	"github.com/VKCOM/php-parser/pkg/version"
+	"github.com/VKCOM/php-parser/pkg/visitor"
+	"github.com/VKCOM/php-parser/pkg/visitor/traverser"
+	"github.com/quasilyte/ktest/internal/phpsyntax"
+)
+
+type MutateConfig struct {
+	// RunConfig describes the tests to run against the mutants.
+	// Its StateFile, RerunFailed and UpdateSnapshots are ignored.
+	// The KPHP compatibility is checked only before the original sources run.
+	RunConfig *RunConfig
+
+	// Timeout is a time budget for a single mutant run (build + tests).
+	// Mutants that exceed it are reported as timed out.
+	Timeout time.Duration
+
+	// Progress is used to report the mutants that were checked.
+	// Can be nil.
+	Progress io.Writer
+}
+
+type MutantStatus int
+
+const (
+	MutantKilled MutantStatus = iota
+	MutantSurvived
+	MutantTimedOut
+	MutantBuildError
+	MutantNotCovered
+)
+
+func (s MutantStatus) String() string {
+	switch s {
+	case MutantKilled:
+		return "killed"
+	case MutantSurvived:
+		return "survived"
+	case MutantTimedOut:
+		return "timed out"
+	case MutantBuildError:
+		return "build error"
+	case MutantNotCovered:
+		return "not covered"
+	default:
+		return "?"
+	}
+}
+
+type Mutant struct {
+	// File is a mutated source file path relative to the project root.
+	File string
+	Line int
+
+	Description string
+
+	Status MutantStatus
+
+	edit textEdit
+}
+
+type MutationResult struct {
+	Mutants []*Mutant
+	Time    time.Duration
+}
+
+// Score returns the percentage of the killed mutants.
+// Timed out mutants are counted as killed, mutants that can't
+// be compiled are not counted at all.
+func (result *MutationResult) Score() float64 {
+	detected := 0
+	total := 0
+	for _, m := range result.Mutants {
+		switch m.Status {
+		case MutantKilled, MutantTimedOut:
+			detected++
+			total++
+		case MutantSurvived, MutantNotCovered:
+			total++
+		}
+	}
+	if total == 0 {
+		return 100
+	}
+	return float64(detected) / float64(total) * 100
+}
+
+// Mutate generates the mutants of the project sources
+// and runs the relevant tests against every one of them.
+//
+// Canceling the ctx stops the process, the ctx error is returned in that case.
+func Mutate(ctx context.Context, conf *MutateConfig) (*MutationResult, error) {
+	startTime := time.Now()
+
+	runConf := *conf.RunConfig
+	// The mutants are expected to fail the tests,
+	// they shouldn't be recorded as the failed tests.
+	runConf.StateFile = ""
+	runConf.RerunFailed = false
+	// The mutants output must not become the new snapshots either.
+	runConf.UpdateSnapshots = false
+	if runConf.BuildDir == "" {
+		buildDir, err := ioutil.TempDir("", "kphpunit-build")
+		if err != nil {
+			return nil, err
+		}
+		runConf.BuildDir = buildDir
+		if !runConf.NoCleanup {
+			defer os.RemoveAll(buildDir)
+		}
+	}
+	progress := conf.Progress
+	if progress == nil {
+		progress = ioutil.Discard
+	}
+
+	phpVersion, err := phpsyntax.ParseVersion(runConf.PHPVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	autoload, err := loadComposerAutoload(runConf.ProjectRoot)
+	if err != nil {
+		return nil, err
+	}
+	sourceRoots := autoload.sourceRoots(&runConf)
+	index := newDepsIndex(phpVersion)
+	for _, root := range sourceRoots {
+		if err := index.AddDir(root); err != nil {
+			return nil, err
+		}
+	}
+	var testFiles []string
+	if strings.HasSuffix(runConf.TestTarget, ".php") {
+		testFiles = []string{runConf.TestTarget}
+	} else {
+		testFiles, err = findTestFiles(runConf.TestTarget)
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, f := range testFiles {
+		if err := index.AddFile(f); err != nil {
+			return nil, err
+		}
+	}
+	testDeps := make(map[string]map[string]bool, len(testFiles))
+	for _, f := range testFiles {
+		testDeps[f] = index.Closure(f)
+	}
+
+	// The tests should pass for the original sources,
+	// otherwise every mutant would look like a killed one.
+	fmt.Fprintf(progress, "running the tests against the original sources...\n")
+	baseline, err := runMutant(ctx, &runConf, nil, time.Time{})
+	if err != nil {
+		return nil, err
+	}
+	if len(baseline.result.Failures) != 0 || len(baseline.result.BuildErrors) != 0 || baseline.runErrors != 0 {
+		return nil, fmt.Errorf("tests are failing without mutations, fix them first")
+	}
+
+	mutants, sources, err := generateMutants(&runConf, sourceRoots, phpVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, m := range mutants {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		filename := filepath.Join(runConf.ProjectRoot, m.File)
+		affected := make(map[string]bool)
+		for _, f := range testFiles {
+			if testDeps[f][filename] {
+				affected[f] = true
+			}
+		}
+		if len(affected) == 0 {
+			m.Status = MutantNotCovered
+		} else {
+			mutantConf := runConf
+			// The baseline run has checked the sources and the mutations
+			// don't introduce the constructs KPHP can't compile.
+			mutantConf.NoCompatCheck = true
+			mutantConf.TestFilter = func(filename string) bool {
+				return affected[filename]
+			}
+			overrides := map[string][]byte{
+				filename: applyTextEdits(sources[filename], []textEdit{m.edit}),
+			}
+			var deadline time.Time
+			if conf.Timeout != 0 {
+				deadline = time.Now().Add(conf.Timeout)
+			}
+			r, err := runMutant(ctx, &mutantConf, overrides, deadline)
+			switch {
+			case err != nil:
+				return nil, err
+			case !deadline.IsZero() && time.Now().After(deadline):
+				m.Status = MutantTimedOut
+			case len(r.result.Failures) != 0 || r.runErrors != 0:
+				m.Status = MutantKilled
+			case len(r.result.BuildErrors) != 0:
+				m.Status = MutantBuildError
+			default:
+				m.Status = MutantSurvived
+			}
+		}
+		fmt.Fprintf(progress, "[%d/%d] %s:%d: %s: %s\n",
+			i+1, len(mutants), m.File, m.Line, m.Description, m.Status)
+	}
+
+	return &MutationResult{
+		Mutants: mutants,
+		Time:    time.Since(startTime),
+	}, nil
+}
+
+func runMutant(ctx context.Context, conf *RunConfig, overrides map[string][]byte, deadline time.Time) (*runner, error) {
+	conf.Output = ioutil.Discard
+	if overrides == nil {
+		overrides = map[string][]byte{}
+	}
+	r := newRunner(conf)
+	r.sourceOverrides = overrides
+	r.deadline = deadline
+	r.quiet = true
+	if _, err := r.Run(ctx); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func generateMutants(conf *RunConfig, sourceRoots []string, phpVersion *version.Version) ([]*Mutant, map[string][]byte, error) {
+	var mutants []*Mutant
+	sources := make(map[string][]byte)
+
+	walkFn := func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || !strings.HasSuffix(path, ".php") {
+			return nil
+		}
+		src, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		rootNode, parserErrors := phpsyntax.Parse(src, phpVersion)
+		if len(parserErrors) != 0 {
+			return fmt.Errorf("%s: parse error: %v", path, parserErrors[0])
+		}
+		rel, err := filepath.Rel(conf.ProjectRoot, path)
+		if err != nil {
+			return err
+		}
+		v := &mutationVisitor{filename: rel}
+		traverser.NewTraverser(v).Traverse(rootNode)
+		if len(v.mutants) != 0 {
+			sources[path] = src
+			mutants = append(mutants, v.mutants...)
+		}
+		return nil
+	}
+	for _, root := range sourceRoots {
+		if err := filepath.Walk(root, walkFn); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	sort.SliceStable(mutants, func(i, j int) bool {
+		if mutants[i].File != mutants[j].File {
+			return mutants[i].File < mutants[j].File
+		}
+		return mutants[i].Line < mutants[j].Line
+	})
+
+	return mutants, sources, nil
+}
+
+// FormatMutationResult prints the survived mutants and the mutation score.
+func FormatMutationResult(w io.Writer, result *MutationResult) {
+	counts := make(map[MutantStatus]int)
+	for _, m := range result.Mutants {
+		counts[m.Status]++
+	}
+
+	fmt.Fprintf(w, "\nTime: %s\n\n", result.Time)
+
+	if counts[MutantSurvived]+counts[MutantNotCovered] != 0 {
+		fmt.Fprintf(w, "Survived mutants:\n\n")
+		for _, m := range result.Mutants {
+			if m.Status != MutantSurvived && m.Status != MutantNotCovered {
+				continue
+			}
+			suffix := ""
+			if m.Status == MutantNotCovered {
+				suffix = " (not covered by tests)"
+			}
+			fmt.Fprintf(w, "%s:%d: %s%s\n", m.File, m.Line, m.Description, suffix)
+		}
+		fmt.Fprintln(w)
+	}
+
+	fmt.Fprintf(w, "Mutants: %d, Killed: %d, Survived: %d, Not covered: %d, Timed out: %d, Build errors: %d.\n",
+		len(result.Mutants), counts[MutantKilled], counts[MutantSurvived],
+		counts[MutantNotCovered], counts[MutantTimedOut], counts[MutantBuildError])
+	fmt.Fprintf(w, "Mutation score: %.2f%%\n", result.Score())
+}
+
+// mutationVisitor collects the source mutations.
+//
+// Every mutant is a single text edit applied to the original file.
+type mutationVisitor struct {
+	visitor.Null
+
+	filename string
+	mutants  []*Mutant
+}
+
+func (v *mutationVisitor) ExprBinarySmaller(n *ast.ExprBinarySmaller) {
+	v.replaceOp(n.OpTkn, ">=")
+}
+
+func (v *mutationVisitor) ExprBinarySmallerOrEqual(n *ast.ExprBinarySmallerOrEqual) {
+	v.replaceOp(n.OpTkn, ">")
+}
+
+func (v *mutationVisitor) ExprBinaryGreater(n *ast.ExprBinaryGreater) {
+	v.replaceOp(n.OpTkn, "<=")
+}
+
+func (v *mutationVisitor) ExprBinaryGreaterOrEqual(n *ast.ExprBinaryGreaterOrEqual) {
+	v.replaceOp(n.OpTkn, "<")
+}
+
+func (v *mutationVisitor) ExprBinaryEqual(n *ast.ExprBinaryEqual) {
+	v.replaceOp(n.OpTkn, "!=")
+}
+
+func (v *mutationVisitor) ExprBinaryNotEqual(n *ast.ExprBinaryNotEqual) {
+	v.replaceOp(n.OpTkn, "==")
+}
+
+func (v *mutationVisitor) ExprBinaryIdentical(n *ast.ExprBinaryIdentical) {
+	v.replaceOp(n.OpTkn, "!==")
+}
+
+func (v *mutationVisitor) ExprBinaryNotIdentical(n *ast.ExprBinaryNotIdentical) {
+	v.replaceOp(n.OpTkn, "===")
+}
+
+func (v *mutationVisitor) ExprBinaryPlus(n *ast.ExprBinaryPlus) {
+	v.replaceOp(n.OpTkn, "-")
+}
+
+func (v *mutationVisitor) ExprBinaryMinus(n *ast.ExprBinaryMinus) {
+	v.replaceOp(n.OpTkn, "+")
+}
+
+func (v *mutationVisitor) StmtReturn(n *ast.StmtReturn) {
+	var replacement string
+	switch e := n.Expr.(type) {
+	case *ast.ExprConstFetch:
+		name, ok := e.Const.(*ast.Name)
+		if !ok {
+			return
+		}
+		switch strings.ToLower(astNameToString(name)) {
+		case "true":
+			replacement = "false"
+		case "false":
+			replacement = "true"
+		default:
+			return
+		}
+	case *ast.ScalarLnumber:
+		replacement = "0"
+		if string(e.Value) == "0" {
+			replacement = "1"
+		}
+	case *ast.ScalarString:
+		replacement = "''"
+		if len(e.Value) == 2 {
+			replacement = "'ktest'"
+		}
+	case *ast.ExprArray:
+		if len(e.Items) == 0 {
+			return
+		}
+		replacement = "[]"
+	default:
+		return
+	}
+	pos := n.Expr.GetPosition()
+	v.addMutant(pos.StartLine, "replaced return value with "+replacement, textEdit{
+		StartPos:    pos.StartPos,
+		EndPos:      pos.EndPos,
+		Replacement: replacement,
+	})
+}
+
+func (v *mutationVisitor) StmtExpression(n *ast.StmtExpression) {
+	pos := n.GetPosition()
+	v.addMutant(pos.StartLine, "removed statement", textEdit{
+		StartPos:    pos.StartPos,
+		EndPos:      pos.EndPos,
+		Replacement: ";",
+	})
+}
+
+func (v *mutationVisitor) replaceOp(tok *token.Token, replacement string) {
+	pos := tok.Position
+	v.addMutant(pos.StartLine, fmt.Sprintf("replaced %s with %s", tok.Value, replacement), textEdit{
+		StartPos:    pos.StartPos,
+		EndPos:      pos.EndPos,
+		Replacement: replacement,
+	})
+}
+
+func (v *mutationVisitor) addMutant(line int, description string, edit textEdit) {
+	v.mutants = append(v.mutants, &Mutant{
+		File:        v.filename,
+		Line:        line,
+		Description: description,
+		edit:        edit,
+	})
+}
@@ -0,0 +1,146 @@
+package phpunit
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/VKCOM/php-parser/pkg/visitor/traverser"
+	"github.com/quasilyte/ktest/internal/fileutil"
+	"github.com/quasilyte/ktest/internal/phpsyntax"
+)
+
+func TestMutationVisitor(t *testing.T) {
+	type mutation struct {
+		description string
+		line        string
+	}
+	tests := []struct {
+		stmt string
+		want []mutation
+	}{
+		{`return $a < $b;`, []mutation{{"replaced < with >=", `return $a >= $b;`}}},
+		{`return $a <= $b;`, []mutation{{"replaced <= with >", `return $a > $b;`}}},
+		{`return $a > $b;`, []mutation{{"replaced > with <=", `return $a <= $b;`}}},
+		{`return $a >= $b;`, []mutation{{"replaced >= with <", `return $a < $b;`}}},
+		{`return $a == $b;`, []mutation{{"replaced == with !=", `return $a != $b;`}}},
+		{`return $a != $b;`, []mutation{{"replaced != with ==", `return $a == $b;`}}},
+		{`return $a === $b;`, []mutation{{"replaced === with !==", `return $a !== $b;`}}},
+		{`return $a !== $b;`, []mutation{{"replaced !== with ===", `return $a === $b;`}}},
+		{`return $a - $b;`, []mutation{{"replaced - with +", `return $a + $b;`}}},
+
+		{`return true;`, []mutation{{"replaced return value with false", `return false;`}}},
+		{`return FALSE;`, []mutation{{"replaced return value with true", `return true;`}}},
+		{`return 10;`, []mutation{{"replaced return value with 0", `return 0;`}}},
+		{`return 0;`, []mutation{{"replaced return value with 1", `return 1;`}}},
+		{`return 'ok';`, []mutation{{"replaced return value with ''", `return '';`}}},
+		{`return '';`, []mutation{{"replaced return value with 'ktest'", `return 'ktest';`}}},
+		{`return [$a];`, []mutation{{"replaced return value with []", `return [];`}}},
+		{`return [];`, nil},
+		{`return null;`, nil},
+		{`return $a;`, nil},
+
+		{`$a = $a + 1;`, []mutation{
+			{"removed statement", `;`},
+			{"replaced + with -", `$a = $a - 1;`},
+		}},
+	}
+
+	for _, test := range tests {
+		src := []byte("<?php\nfunction f($a, $b) {\n" + test.stmt + "\n}\n")
+		rootNode, parserErrors := phpsyntax.Parse(src, testPHPVersion)
+		if len(parserErrors) != 0 {
+			t.Fatalf("%s: parse: %v", test.stmt, parserErrors[0])
+		}
+		v := &mutationVisitor{filename: "src/f.php"}
+		traverser.NewTraverser(v).Traverse(rootNode)
+		if len(v.mutants) != len(test.want) {
+			t.Errorf("%s: have %d mutants, want %d", test.stmt, len(v.mutants), len(test.want))
+			continue
+		}
+		for i, m := range v.mutants {
+			want := test.want[i]
+			if m.File != "src/f.php" || m.Line != 3 {
+				t.Errorf("%s: unexpected mutant location %s:%d", test.stmt, m.File, m.Line)
+			}
+			if m.Description != want.description {
+				t.Errorf("%s: description mismatch:\nhave: %s\nwant: %s", test.stmt, m.Description, want.description)
+			}
+			mutated := strings.Split(string(applyTextEdits(src, []textEdit{m.edit})), "\n")[2]
+			if mutated != want.line {
+				t.Errorf("%s: mutated line mismatch:\nhave: %s\nwant: %s", test.stmt, mutated, want.line)
+			}
+		}
+	}
+}
+
+func TestGenerateMutants(t *testing.T) {
+	projectRoot, err := ioutil.TempDir("", "ktest-mutate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(projectRoot)
+
+	files := map[string]string{
+		"src/B.php":      "<?php\nfunction b($x) {\n  return $x > 0;\n}\n",
+		"src/A.php":      "<?php\nfunction a($x) {\n  $x++;\n  return $x + 1;\n}\n",
+		"src/Const.php":  "<?php\nconst X = 1;\n",
+		"src/readme.txt": "return true;",
+	}
+	for name, contents := range files {
+		if err := fileutil.WriteFile(filepath.Join(projectRoot, name), []byte(contents)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	conf := &RunConfig{ProjectRoot: projectRoot + "/"}
+	mutants, sources, err := generateMutants(conf, []string{filepath.Join(projectRoot, "src")}, testPHPVersion)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"src/A.php:3: removed statement",
+		"src/A.php:4: replaced + with -",
+		"src/B.php:3: replaced > with <=",
+	}
+	if len(mutants) != len(want) {
+		t.Fatalf("have %d mutants, want %d", len(mutants), len(want))
+	}
+	for i, m := range mutants {
+		have := fmt.Sprintf("%s:%d: %s", m.File, m.Line, m.Description)
+		if have != want[i] {
+			t.Errorf("mutant %d mismatch:\nhave: %s\nwant: %s", i, have, want[i])
+		}
+	}
+	if len(sources) != 2 || sources[filepath.Join(projectRoot, "src/A.php")] == nil {
+		t.Errorf("unexpected sources: %d files", len(sources))
+	}
+}
+
+func TestMutationScore(t *testing.T) {
+	tests := []struct {
+		statuses []MutantStatus
+		want     float64
+	}{
+		{nil, 100},
+		{[]MutantStatus{MutantTimedOut, MutantTimedOut}, 100},
+		{[]MutantStatus{MutantBuildError}, 100},
+		{[]MutantStatus{MutantKilled, MutantSurvived}, 50},
+		{[]MutantStatus{MutantKilled, MutantNotCovered, MutantBuildError}, 50},
+		{[]MutantStatus{MutantSurvived, MutantNotCovered}, 0},
+		{[]MutantStatus{MutantKilled, MutantTimedOut, MutantKilled, MutantSurvived, MutantBuildError}, 75},
+	}
+
+	for _, test := range tests {
+		result := &MutationResult{}
+		for _, status := range test.statuses {
+			result.Mutants = append(result.Mutants, &Mutant{Status: status})
+		}
+		if have := result.Score(); have != test.want {
+			t.Errorf("Score(%v): have %.2f, want %.2f", test.statuses, have, test.want)
+		}
+	}
+}
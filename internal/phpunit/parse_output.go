@@ -4,114 +4,281 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"strings"
+	"time"
 )
 
 type testFileResult struct {
 	finished bool
 	asserts  int
 	failures []TestFailure
+	methods  []MethodResult
+
+	// updatedSnapshots lists the snapshots written in the update mode.
+	updatedSnapshots []string
+
+	// outputs maps the test names to their captured output.
+	outputs map[string]string
+}
+
+// assertFailure is a decoded ["ASSERT_*_FAILED", expected, actual, message, line] op.
+// The values are encoded with KTest\Runtime::encode, see decodeTypedValue.
+type assertFailure struct {
+	expected *phpValue
+	actual   *phpValue
+	message  string
+	line     int
+}
+
+func decodeAssertFailure(fields []json.RawMessage) (*assertFailure, error) {
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("expected 5 fields, found %d", len(fields))
+	}
+	var f assertFailure
+	var line float64
+	if err := json.Unmarshal(fields[3], &f.message); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(fields[4], &line); err != nil {
+		return nil, err
+	}
+	f.line = int(line)
+	var err error
+	f.expected, err = decodeTypedValue(fields[1])
+	if err != nil {
+		return nil, fmt.Errorf("decode expected: %v", err)
+	}
+	f.actual, err = decodeTypedValue(fields[2])
+	if err != nil {
+		return nil, fmt.Errorf("decode actual: %v", err)
+	}
+	return &f, nil
+}
+
+// valuesDiff returns a diff description for the strings and arrays comparison.
+// For other values it returns nil as one-line reason is good enough.
+//
+// Like PHPUnit, it compares the exported values.
+func (f *assertFailure) valuesDiff(relation string) *ValuesDiff {
+	var subject string
+	switch {
+	case f.expected.kind == valueArray && f.actual.kind == valueArray:
+		subject = "two arrays"
+	case f.expected.kind == valueString && f.actual.kind == valueString:
+		subject = "two strings"
+	default:
+		return nil
+	}
+	return &ValuesDiff{
+		Reason:   fmt.Sprintf("Failed asserting that %s are %s", subject, relation),
+		Expected: exportValue(f.expected),
+		Actual:   exportValue(f.actual),
+	}
+}
+
+// isEqualDescription is a port of the IsEqual constraint toString method.
+func isEqualDescription(v *phpValue) string {
+	if v.kind == valueString {
+		if strings.Contains(v.repr, "\n") {
+			return "is equal to <text>"
+		}
+		return fmt.Sprintf("is equal to '%s'", v.repr)
+	}
+	return "is equal to " + exportValue(v)
 }
 
 func parseTestOutput(f *testFile, output []byte) (*testFileResult, error) {
-	res := &testFileResult{}
+	res := &testFileResult{outputs: make(map[string]string)}
 
 	var currentTest string
+	// property is reported before the failure it belongs to.
+	var property *PropertyFailure
 	for i, line := range bytes.Split(output, []byte("\n")) {
 		if len(line) == 0 {
 			continue
 		}
-		var fields []interface{}
+		var fields []json.RawMessage
 		if err := json.Unmarshal(line, &fields); err != nil {
 			return nil, fmt.Errorf("output line %d: %s: %v", i+1, line, err)
 		}
 		if len(fields) == 0 {
 			return nil, fmt.Errorf("output line %d: %s: empty fields", i+1, line)
 		}
-		op := fields[0].(string)
+		var op string
+		if err := json.Unmarshal(fields[0], &op); err != nil {
+			return nil, fmt.Errorf("output line %d: %s: decode op: %v", i+1, line, err)
+		}
+
+		var failure *assertFailure
+		switch op {
+		case "ASSERT_EQUALS_FAILED", "ASSERT_NOT_EQUALS_FAILED", "ASSERT_BOOL_FAILED", "ASSERT_NOT_SAME_FAILED", "ASSERT_SAME_FAILED",
+			"ASSERT_OUTPUT_FAILED", "ASSERT_OUTPUT_REGEX_FAILED", "ASSERT_EXCEPTION_FAILED", "ASSERT_KPHPUNIT_FAILED",
+			"ASSERT_SNAPSHOT_FAILED", "ASSERT_SNAPSHOT_MISSING_FAILED":
+			res.asserts++
+			fallthrough
+		case "ASSERT_MEMORY_LIMIT_FAILED":
+			// The memory limit is not an assertion, so it's not counted.
+			var err error
+			failure, err = decodeAssertFailure(fields)
+			if err != nil {
+				return nil, fmt.Errorf("output line %d: %s: %v", i+1, line, err)
+			}
+		}
+
+		var reason string
+		var diff *ValuesDiff
 		switch op {
 		case "START":
-			currentTest = fields[1].(string)
+			if err := json.Unmarshal(fields[1], &currentTest); err != nil {
+				return nil, fmt.Errorf("output line %d: %s: %v", i+1, line, err)
+			}
+			continue
+		case "OUTPUT":
+			if len(fields) != 2 {
+				return nil, fmt.Errorf("output line %d: %s: expected 2 fields, found %d", i+1, line, len(fields))
+			}
+			output, err := decodeTypedValue(fields[1])
+			if err != nil {
+				return nil, fmt.Errorf("output line %d: %s: %v", i+1, line, err)
+			}
+			res.outputs[f.info.ClassName+"::"+currentTest] = output.repr
+			continue
+		case "TIME":
+			var elapsed int64
+			if len(fields) != 2 {
+				return nil, fmt.Errorf("output line %d: %s: expected 2 fields, found %d", i+1, line, len(fields))
+			}
+			if err := json.Unmarshal(fields[1], &elapsed); err != nil {
+				return nil, fmt.Errorf("output line %d: %s: %v", i+1, line, err)
+			}
+			res.methods = append(res.methods, MethodResult{
+				Name: currentTest,
+				Time: time.Duration(elapsed),
+			})
+			continue
+		case "MEMORY":
+			var usage, peak int64
+			if len(fields) != 3 {
+				return nil, fmt.Errorf("output line %d: %s: expected 3 fields, found %d", i+1, line, len(fields))
+			}
+			if err := json.Unmarshal(fields[1], &usage); err != nil {
+				return nil, fmt.Errorf("output line %d: %s: %v", i+1, line, err)
+			}
+			if err := json.Unmarshal(fields[2], &peak); err != nil {
+				return nil, fmt.Errorf("output line %d: %s: %v", i+1, line, err)
+			}
+			if len(res.methods) == 0 || res.methods[len(res.methods)-1].Name != currentTest {
+				return nil, fmt.Errorf("output line %d: %s: MEMORY without TIME", i+1, line)
+			}
+			m := &res.methods[len(res.methods)-1]
+			m.MemoryUsage = usage
+			m.PeakMemory = peak
+			continue
+		case "SNAPSHOT_UPDATED":
+			var name string
+			if len(fields) != 2 {
+				return nil, fmt.Errorf("output line %d: %s: expected 2 fields, found %d", i+1, line, len(fields))
+			}
+			if err := json.Unmarshal(fields[1], &name); err != nil {
+				return nil, fmt.Errorf("output line %d: %s: %v", i+1, line, err)
+			}
+			res.updatedSnapshots = append(res.updatedSnapshots, name)
+			continue
+		case "PROPERTY":
+			if len(fields) != 5 {
+				return nil, fmt.Errorf("output line %d: %s: expected 5 fields, found %d", i+1, line, len(fields))
+			}
+			property = &PropertyFailure{}
+			for j, dst := range []interface{}{&property.Seed, &property.Runs, &property.Shrinks} {
+				if err := json.Unmarshal(fields[j+1], dst); err != nil {
+					return nil, fmt.Errorf("output line %d: %s: %v", i+1, line, err)
+				}
+			}
+			args, err := decodeTypedValue(fields[4])
+			if err != nil {
+				return nil, fmt.Errorf("output line %d: %s: %v", i+1, line, err)
+			}
+			property.Counterexample = exportValue(args)
+			continue
 		case "ASSERT_OK":
 			res.asserts++
+			continue
 		case "FINISHED":
 			res.finished = true
+			continue
 		case "ASSERT_EQUALS_FAILED":
-			res.asserts++
-			expected := fields[1]
-			actual := fields[2]
-			message := fields[3].(string)
-			line := fields[4].(float64)
-			reason := fmt.Sprintf("Failed asserting that %s matches expected %s",
-				jsonString(actual), jsonString(expected))
-			res.failures = append(res.failures, TestFailure{
-				Name:    f.info.ClassName + "::" + currentTest,
-				Reason:  reason,
-				Message: message,
-				File:    f.fullName,
-				Line:    int(line),
-			})
+			reason = fmt.Sprintf("Failed asserting that %s matches expected %s",
+				exportValue(failure.actual), exportValue(failure.expected))
+			diff = failure.valuesDiff("equal")
 		case "ASSERT_NOT_EQUALS_FAILED":
-			res.asserts++
-			expected := fields[1]
-			actual := fields[2]
-			message := fields[3].(string)
-			line := fields[4].(float64)
-			reason := fmt.Sprintf("Failed asserting that %s is not equal to %s",
-				jsonString(actual), jsonString(expected))
-			res.failures = append(res.failures, TestFailure{
-				Name:    f.info.ClassName + "::" + currentTest,
-				Reason:  reason,
-				Message: message,
-				File:    f.fullName,
-				Line:    int(line),
-			})
+			reason = fmt.Sprintf("Failed asserting that %s %s",
+				exportValue(failure.actual), strings.Replace(isEqualDescription(failure.expected), "is ", "is not ", 1))
 		case "ASSERT_BOOL_FAILED":
-			res.asserts++
-			expected := fields[1]
-			actual := fields[2]
-			message := fields[3].(string)
-			line := fields[4].(float64)
-			reason := fmt.Sprintf("Failed asserting that %s is %s", jsonString(actual), expected)
-			res.failures = append(res.failures, TestFailure{
-				Name:    f.info.ClassName + "::" + currentTest,
-				Reason:  reason,
-				Message: message,
-				File:    f.fullName,
-				Line:    int(line),
-			})
+			reason = fmt.Sprintf("Failed asserting that %s is %s", exportValue(failure.actual), failure.expected.repr)
 		case "ASSERT_NOT_SAME_FAILED":
-			res.asserts++
-			expected := fields[1]
-			actual := fields[2]
-			message := fields[3].(string)
-			line := fields[4].(float64)
-			reason := fmt.Sprintf("Failed asserting that %s is not identical to %s",
-				jsonString(actual), jsonString(expected))
-			res.failures = append(res.failures, TestFailure{
-				Name:    f.info.ClassName + "::" + currentTest,
-				Reason:  reason,
-				Message: message,
-				File:    f.fullName,
-				Line:    int(line),
-			})
+			reason = fmt.Sprintf("Failed asserting that %s is not identical to %s",
+				exportValue(failure.actual), exportValue(failure.expected))
 		case "ASSERT_SAME_FAILED":
-			res.asserts++
-			expected := fields[1]
-			actual := fields[2]
-			message := fields[3].(string)
-			line := fields[4].(float64)
-			reason := fmt.Sprintf("Failed asserting that %s is identical to %s",
-				jsonString(actual), jsonString(expected))
-			res.failures = append(res.failures, TestFailure{
-				Name:    f.info.ClassName + "::" + currentTest,
-				Reason:  reason,
-				Message: message,
-				File:    f.fullName,
-				Line:    int(line),
-			})
+			reason = fmt.Sprintf("Failed asserting that %s is identical to %s",
+				exportValue(failure.actual), exportValue(failure.expected))
+			diff = failure.valuesDiff("identical")
+		case "ASSERT_OUTPUT_FAILED":
+			reason = fmt.Sprintf("Failed asserting that %s matches expected %s",
+				exportValue(failure.actual), exportValue(failure.expected))
+			diff = failure.valuesDiff("equal")
+		case "ASSERT_OUTPUT_REGEX_FAILED":
+			reason = fmt.Sprintf("Failed asserting that %s matches PCRE pattern \"%s\"",
+				exportValue(failure.actual), failure.expected.repr)
+		case "ASSERT_EXCEPTION_FAILED":
+			reason = fmt.Sprintf("Failed asserting that the property holds, %s was thrown with message %s",
+				failure.expected.repr, exportValue(failure.actual))
+		case "ASSERT_SNAPSHOT_FAILED":
+			// The snapshot name is reported instead of the message.
+			name := failure.message
+			failure.message = ""
+			reason = fmt.Sprintf("Failed asserting that %s matches snapshot %s", failure.actual.repr, name)
+			diff = &ValuesDiff{
+				Reason:   fmt.Sprintf("Failed asserting that the value matches snapshot %s", name),
+				Expected: failure.expected.repr,
+				Actual:   failure.actual.repr,
+			}
+		case "ASSERT_SNAPSHOT_MISSING_FAILED":
+			name := failure.message
+			failure.message = ""
+			reason = fmt.Sprintf("Failed asserting that snapshot %s exists", name)
+		case "ASSERT_KPHPUNIT_FAILED":
+			reason = fmt.Sprintf("Failed asserting with an assertion that is not supported by ktest, %s was thrown with message %s",
+				failure.expected.repr, exportValue(failure.actual))
+			// The assertion is called outside of the test class,
+			// the test method declaration line is reported instead.
+			failure.line = f.info.methodLines[currentTest]
+		case "ASSERT_MEMORY_LIMIT_FAILED":
+			reason = fmt.Sprintf("Failed asserting that the test peak memory usage of %s bytes doesn't exceed the limit of %s bytes",
+				failure.actual.repr, failure.expected.repr)
+			// The runtime doesn't know the test location,
+			// the test method declaration line is reported instead.
+			failure.line = f.info.methodLines[currentTest]
 		default:
 			return nil, fmt.Errorf("output line %d: %s: unexpected op %s", i+1, line, op)
 		}
+
+		res.failures = append(res.failures, TestFailure{
+			Name:     f.info.ClassName + "::" + currentTest,
+			Reason:   reason,
+			Message:  failure.message,
+			File:     f.fullName,
+			Line:     failure.line,
+			Diff:     diff,
+			Property: property,
+		})
+		property = nil
+	}
+
+	// The output is reported after the test is finished,
+	// so it's attached to the failures afterwards.
+	for i := range res.failures {
+		failure := &res.failures[i]
+		failure.Output = res.outputs[failure.Name]
 	}
 
 	return res, nil
@@ -0,0 +1,90 @@
+package phpunit
+
+import (
+	"testing"
+)
+
+func TestParsePropertyFailure(t *testing.T) {
+	f := &testFile{
+		fullName: "/project/tests/FooTest.php",
+		info:     &testParsedInfo{ClassName: "FooTest"},
+	}
+	protocol := `["START","testReverse"]
+["PROPERTY",42,7,3,["array",[[["int","0"],["array",[[["int","0"],["int","1"]],[["int","1"],["int","0"]]]]]]]]
+["ASSERT_SAME_FAILED",["int","1"],["int","0"],"",12]
+["OUTPUT",["string",""]]
+["TIME",1000]
+["START","testThrows"]
+["PROPERTY",42,1,0,["array",[[["int","0"],["string",""]]]]]
+["ASSERT_EXCEPTION_FAILED",["string","LogicException"],["string","oops"],"",20]
+["OUTPUT",["string",""]]
+["TIME",1000]
+["START","testOk"]
+["ASSERT_OK"]
+["OUTPUT",["string",""]]
+["TIME",1000]
+["FINISHED"]
+`
+
+	res, err := parseTestOutput(f, []byte(protocol))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.asserts != 3 || len(res.failures) != 2 {
+		t.Fatalf("unexpected result: asserts=%d failures=%+v", res.asserts, res.failures)
+	}
+
+	first := res.failures[0]
+	if first.Line != 12 || first.Reason != "Failed asserting that 0 is identical to 1" {
+		t.Errorf("unexpected first failure: %+v", first)
+	}
+	wantCounterexample := `Array &0 (
+    0 => Array &1 (
+        0 => 1
+        1 => 0
+    )
+)`
+	if first.Property == nil {
+		t.Fatalf("first failure: property info is missing")
+	}
+	if p := first.Property; p.Seed != 42 || p.Runs != 7 || p.Shrinks != 3 || p.Counterexample != wantCounterexample {
+		t.Errorf("unexpected property info: %+v", p)
+	}
+
+	second := res.failures[1]
+	if second.Reason != "Failed asserting that the property holds, LogicException was thrown with message 'oops'" {
+		t.Errorf("unexpected second failure reason: %s", second.Reason)
+	}
+	if second.Property == nil || second.Property.Runs != 1 {
+		t.Errorf("unexpected second failure property info: %+v", second.Property)
+	}
+}
+
+func TestParseKphpunitFailure(t *testing.T) {
+	f := &testFile{
+		fullName: "/project/tests/FooTest.php",
+		info: &testParsedInfo{
+			ClassName:   "FooTest",
+			methodLines: map[string]int{"testCount": 7},
+		},
+	}
+	protocol := `["START","testCount"]
+["ASSERT_KPHPUNIT_FAILED",["string","KPHPUnit\\Framework\\AssertionFailedException"],["string","count mismatch"],"",0]
+["OUTPUT",["string",""]]
+["TIME",1000]
+["FINISHED"]
+`
+
+	res, err := parseTestOutput(f, []byte(protocol))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.asserts != 1 || len(res.failures) != 1 {
+		t.Fatalf("unexpected result: asserts=%d failures=%+v", res.asserts, res.failures)
+	}
+	failure := res.failures[0]
+	wantReason := `Failed asserting with an assertion that is not supported by ktest, KPHPUnit\Framework\AssertionFailedException was thrown with message 'count mismatch'`
+	if failure.Line != 7 || failure.Reason != wantReason {
+		t.Errorf("unexpected failure: %+v", failure)
+	}
+}
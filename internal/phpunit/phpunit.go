@@ -1,6 +1,7 @@
 package phpunit
 
 import (
+	"context"
 	"io"
 	"time"
 )
@@ -9,10 +10,114 @@ type RunConfig struct {
 	ProjectRoot string
 	TestTarget  string
 	TestArgv    []string
-	SrcDir      string
+
+	// SrcDir is a project sources root. It's used along with the
+	// composer.json "autoload" paths, so it can be left empty
+	// for the projects that declare all of their sources there.
+	SrcDir string
 
 	KphpCommand string
 
+	// PHPVersion is a "major.minor" PHP language version used to parse
+	// the test files and sources. If empty, phpsyntax.DefaultVersion is used.
+	PHPVersion string
+
+	// OrderBy controls the test execution order.
+	// Supported values are "default" and "random".
+	OrderBy string
+
+	// RandomOrderSeed is used to shuffle the tests when OrderBy is "random".
+	// If 0, a new seed is generated for every run.
+	RandomOrderSeed int64
+
+	// ShardTotal splits the test classes into the specified number of shards,
+	// only the classes from the ShardIndex shard are executed.
+	// The shards are selected deterministically, so every shard
+	// can be executed on its own machine. If 0, all classes are executed.
+	ShardTotal int
+
+	// ShardIndex is a shard to execute, starting from 0.
+	ShardIndex int
+
+	// ShardTimingsFile is a JSON report of a previous run (see WriteJSONReport).
+	// If set, the classes are distributed over the shards by their
+	// compile and run time, otherwise every class has the same weight.
+	ShardTimingsFile string
+
+	// StateFile is a file that keeps the failed tests between the runs.
+	// If empty, the run state is not saved. See DefaultStateFile.
+	StateFile string
+
+	// RerunFailed executes only the tests that failed during the previous
+	// runs according to the StateFile. The classes that couldn't be
+	// compiled or executed are re-run completely.
+	RerunFailed bool
+
+	// TestFilter selects the test files to run.
+	// If nil, all test files are executed.
+	TestFilter func(filename string) bool
+
+	// NoCompatCheck disables the KPHP compatibility checks
+	// that are performed before the tests compilation.
+	NoCompatCheck bool
+
+	// StopOnSharedBuildError stops the run after the first build error
+	// that is reported for the code outside of the test files.
+	// Such errors usually make every test class fail to compile.
+	StopOnSharedBuildError bool
+
+	// StopOnFailure stops the run after the first failed test.
+	// The remaining methods of the failed test class are skipped
+	// and the remaining test classes are not compiled.
+	// Like in PHPUnit, the errors stop the run too.
+	StopOnFailure bool
+
+	// StopOnError stops the run after the first test class
+	// that can't be compiled or executed.
+	StopOnError bool
+
+	// Coverage enables the line coverage collection.
+	// The project sources are instrumented inside the build dir.
+	Coverage bool
+
+	// Retries is a number of times a failed test method is re-executed.
+	// Every retry runs only that method, in a fresh process.
+	// The methods that pass on a retry are reported as flaky.
+	Retries int
+
+	// UpdateSnapshots makes assertMatchesSnapshot write the missing
+	// and mismatching snapshots instead of reporting a failure.
+	UpdateSnapshots bool
+
+	// PropertySeed is a seed for the forAll generators.
+	// If 0, a new seed is generated for every run.
+	PropertySeed int64
+
+	// PropertyRuns is a number of the generated inputs every forAll checks.
+	// If 0, the runtime default of 100 is used.
+	PropertyRuns int
+
+	// MemoryLimitPerTest is a peak memory usage limit in bytes.
+	// The tests that exceed it are reported as failed.
+	// If 0, the memory usage is not limited.
+	//
+	// KPHP can't reset the process peak memory usage, so when the limit
+	// is set, every test method is executed in a separate process.
+	MemoryLimitPerTest int64
+
+	// StubsFile is a PHP file with the @ktest-stub declarations.
+	// The calls of the stubbed functions and static methods inside
+	// the sources are rewritten to go through the stubs when a test
+	// enables them with $this->stub(). If empty, nothing is stubbed.
+	StubsFile string
+
+	// BuildDir is a build directory to use instead of a temporary one.
+	// It's never removed by the runner, so it can be re-used between runs.
+	BuildDir string
+
+	// Events are called as the test classes are processed.
+	Events Events
+
 	Output     io.Writer
 	DebugPrint func(string)
 
@@ -24,6 +129,45 @@ type RunResult struct {
 	Assertions int
 	Failures   []TestFailure
 	Time       time.Duration
+
+	// RandomOrderSeed is a seed that was used to shuffle the tests.
+	// It's 0 unless the random order was requested.
+	RandomOrderSeed int64
+
+	// BuildErrors lists the test files that can't be compiled.
+	BuildErrors []BuildError
+
+	// NotRun is a number of tests that were skipped due to the early stop.
+	// The skipped tests are not included into the Tests.
+	NotRun int
+
+	// Coverage is nil unless the coverage collection was requested.
+	Coverage *CoverageProfile
+
+	// Classes lists the executed test classes in the execution order.
+	Classes []*ClassResult
+
+	// Flaky lists the tests that failed, but passed on a retry.
+	// They're not included into the Failures.
+	Flaky []FlakyTest
+
+	// PropertySeed is a seed that was used by the forAll generators.
+	PropertySeed int64
+
+	// UpdatedSnapshots lists the snapshot files written in the update mode.
+	// The paths are relative to the project root.
+	UpdatedSnapshots []string
+}
+
+// FlakyTest describes a test that passed only after a retry.
+type FlakyTest struct {
+	Name string
+
+	// Retries is a number of retries it took to pass the test.
+	Retries int
+
+	// Failures are the failures of the initial test run.
+	Failures []TestFailure
 }
 
 type TestFailure struct {
@@ -32,12 +176,116 @@ type TestFailure struct {
 	Message string
 	File    string
 	Line    int
+
+	// Diff is set for the failed comparisons of arrays and multi-line strings.
+	Diff *ValuesDiff
+
+	// Output is everything the test printed to the stdout.
+	Output string
+
+	// Property is set if the failure is caused by a falsified forAll property.
+	Property *PropertyFailure
+}
+
+// PropertyFailure describes a minimal forAll counterexample.
+type PropertyFailure struct {
+	// Seed reproduces the failure when used as RunConfig.PropertySeed.
+	Seed int64
+
+	// Runs is a number of the generated inputs that were checked.
+	Runs int
+
+	// Shrinks is a number of the successful simplification steps.
+	Shrinks int
+
+	// Counterexample is the exported array of the property arguments.
+	Counterexample string
+}
+
+// ValuesDiff holds the pretty-printed values of a failed comparison.
+type ValuesDiff struct {
+	Reason   string
+	Expected string
+	Actual   string
+}
+
+// Events holds the optional run progress callbacks.
+// They're called synchronously, from the goroutine that executes Run.
+type Events struct {
+	// ClassStarted is called before a test class is compiled.
+	ClassStarted func(filename, className string)
+
+	// ClassFinished is called after a test class is compiled and executed.
+	ClassFinished func(result *ClassResult)
+}
+
+// ClassResult describes a single test class run.
+type ClassResult struct {
+	File      string
+	ClassName string
+
+	// Tests is a number of the test methods inside the class.
+	Tests      int
+	Assertions int
+	Failures   []TestFailure
+
+	// Flaky lists the test methods that passed on a retry.
+	Flaky []FlakyTest
+
+	// BuildError is set if the class can't be compiled.
+	BuildError *BuildError
+
+	// Err is set if the compiled tests can't be executed
+	// or their results can't be collected.
+	Err error
+
+	// CompileTime is the kphp2cpp execution time.
+	CompileTime time.Duration
+
+	// RunTime is the compiled test binary execution time,
+	// the failed tests retries are included.
+	RunTime time.Duration
+
+	// MaxRSS is the test binary maximum resident set size in bytes.
+	// It's 0 if the platform doesn't report it.
+	MaxRSS int64
+
+	// Methods lists the executed test methods in the execution order.
+	Methods []MethodResult
+}
+
+func (res *ClassResult) isFlaky(name string) bool {
+	for _, flaky := range res.Flaky {
+		if flaky.Name == name {
+			return true
+		}
+	}
+	return false
 }
 
-func Run(conf *RunConfig) (*RunResult, error) {
+// MethodResult describes a single test method run.
+type MethodResult struct {
+	Name string
+
+	// Time is the test method execution time measured inside the test binary.
+	Time time.Duration
+
+	// MemoryUsage is the memory_get_usage() difference between the test
+	// start and finish, in bytes. A positive value may signal a leak.
+	MemoryUsage int64
+
+	// PeakMemory is the test peak memory usage in bytes relative to the
+	// test start memory usage. The peak can't be measured if it's lower
+	// than the peak of the previously executed tests, it's 0 in that case.
+	PeakMemory int64
+}
+
+// Run compiles and runs the tests.
+// Canceling the ctx interrupts the run, the ctx error is returned in that case.
+func Run(ctx context.Context, conf *RunConfig) (*RunResult, error) {
 	startTime := time.Now()
 	r := newRunner(conf)
-	result, err := r.Run()
+	result, err := r.Run(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -48,6 +296,17 @@ func Run(conf *RunConfig) (*RunResult, error) {
 type FormatConfig struct {
 	PrintTime     bool
 	ShortLocation bool
+
+	// Verbose enables the per-class and per-method timings listing.
+	Verbose bool
+
+	// Slowest is a number of the slowest test methods to report.
+	// If 0, the report is not printed.
+	Slowest int
+
+	// CompactDiff disables the unified diffs for the failed comparisons,
+	// one-line reasons are printed instead.
+	CompactDiff bool
 }
 
 func FormatResult(w io.Writer, conf *FormatConfig, result *RunResult) {
@@ -2,6 +2,7 @@ package phpunit
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"io/ioutil"
 	"os/exec"
@@ -51,13 +52,18 @@ func TestPhpunit(t *testing.T) {
 		workdir := absFilepath(t, testDir)
 		initComposer(t, workdir)
 
+		// The coverage* cases run with the line coverage enabled,
+		// the coverage summary is a part of their golden output.
+		coverage := strings.HasPrefix(filename, "coverage")
+
 		var output bytes.Buffer
-		result, err := Run(&RunConfig{
+		result, err := Run(context.Background(), &RunConfig{
 			ProjectRoot: workdir,
 			SrcDir:      "src",
 			TestTarget:  filepath.Join(workdir, "tests"),
 			KphpCommand: kenv.FindKphpBinary(),
 			Output:      &output,
+			Coverage:    coverage,
 		})
 		if err != nil {
 			t.Fatal(err)
@@ -67,6 +73,19 @@ func TestPhpunit(t *testing.T) {
 			ShortLocation: true,
 		}
 		FormatResult(&output, formatConfig, result)
+		if coverage {
+			if result.Coverage == nil {
+				t.Fatal("no coverage profile collected")
+			}
+			covered := 0
+			for _, f := range result.Coverage.Files {
+				covered += f.CoveredLines()
+			}
+			if covered == 0 {
+				t.Errorf("no covered lines reported")
+			}
+			FormatCoverage(&output, result.Coverage)
+		}
 		have := strings.TrimSpace(output.String())
 		want := strings.TrimSpace(string(goldenData))
 		if have != want {
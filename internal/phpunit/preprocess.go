@@ -17,7 +17,7 @@ func applyTextEdits(contents []byte, fixes []textEdit) []byte {
 		return nil
 	}
 
-	sort.Slice(fixes, func(i, j int) bool {
+	sort.SliceStable(fixes, func(i, j int) bool {
 		return fixes[i].StartPos < fixes[j].StartPos
 	})
 
@@ -43,3 +43,94 @@ func writeTextEdits(buf *bytes.Buffer, contents []byte, fixes []textEdit) {
 	}
 	buf.Write(contents[offset:])
 }
+
+// sourceMap translates the preprocessed file positions back
+// to the original file positions.
+type sourceMap struct {
+	spans []sourceSpan
+
+	newLineStarts  []int
+	origLineStarts []int
+}
+
+// sourceSpan maps [newStart, newStart+length) to the original contents
+// starting from origStart. Replacements are mapped to their original start.
+type sourceSpan struct {
+	newStart  int
+	origStart int
+	length    int
+	replaced  bool
+}
+
+// newSourceMap creates a source map for the contents that
+// were produced by the applyTextEdits(contents, fixes).
+func newSourceMap(contents []byte, fixes []textEdit) *sourceMap {
+	m := &sourceMap{origLineStarts: lineStarts(contents)}
+
+	sort.SliceStable(fixes, func(i, j int) bool {
+		return fixes[i].StartPos < fixes[j].StartPos
+	})
+
+	var buf bytes.Buffer
+	offset := 0
+	for _, fix := range fixes {
+		if offset > fix.StartPos {
+			continue
+		}
+		m.spans = append(m.spans, sourceSpan{
+			newStart:  buf.Len(),
+			origStart: offset,
+			length:    fix.StartPos - offset,
+		})
+		buf.Write(contents[offset:fix.StartPos])
+		m.spans = append(m.spans, sourceSpan{
+			newStart:  buf.Len(),
+			origStart: fix.StartPos,
+			length:    len(fix.Replacement),
+			replaced:  true,
+		})
+		buf.WriteString(fix.Replacement)
+		offset = fix.EndPos
+	}
+	m.spans = append(m.spans, sourceSpan{
+		newStart:  buf.Len(),
+		origStart: offset,
+		length:    len(contents) - offset,
+	})
+	buf.Write(contents[offset:])
+
+	m.newLineStarts = lineStarts(buf.Bytes())
+	return m
+}
+
+// OrigLine returns the original file line for the preprocessed file line.
+// Lines are 1-based.
+func (m *sourceMap) OrigLine(line int) int {
+	if line < 1 || line > len(m.newLineStarts) {
+		return line
+	}
+	pos := m.newLineStarts[line-1]
+	origPos := pos
+	for _, span := range m.spans {
+		if pos >= span.newStart && pos < span.newStart+span.length {
+			origPos = span.origStart
+			if !span.replaced {
+				origPos += pos - span.newStart
+			}
+			break
+		}
+	}
+	return sort.Search(len(m.origLineStarts), func(i int) bool {
+		return m.origLineStarts[i] > origPos
+	})
+}
+
+func lineStarts(contents []byte) []int {
+	starts := []int{0}
+	for i, ch := range contents {
+		if ch == '\n' {
+			starts = append(starts, i+1)
+		}
+	}
+	return starts
+}
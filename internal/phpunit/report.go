@@ -0,0 +1,284 @@
+package phpunit
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// Test statuses used in the JSON report.
+const (
+	statusPassed = "passed"
+	statusFailed = "failed"
+	statusFlaky  = "flaky"
+)
+
+type jsonReport struct {
+	Tests       int               `json:"tests"`
+	Assertions  int               `json:"assertions"`
+	Failures    int               `json:"failures"`
+	Flaky       int               `json:"flaky"`
+	BuildErrors int               `json:"build_errors"`
+	NotRun      int               `json:"not_run"`
+	TimeMs      int64             `json:"time_ms"`
+	Classes     []jsonReportClass `json:"classes"`
+}
+
+type jsonReportClass struct {
+	File          string             `json:"file"`
+	Class         string             `json:"class"`
+	Status        string             `json:"status"`
+	Error         string             `json:"error,omitempty"`
+	CompileTimeMs int64              `json:"compile_time_ms"`
+	RunTimeMs     int64              `json:"run_time_ms"`
+	MaxRSS        int64              `json:"max_rss"`
+	Tests         []jsonReportMethod `json:"tests"`
+}
+
+type jsonReportMethod struct {
+	Name        string              `json:"name"`
+	Status      string              `json:"status"`
+	Retries     int                 `json:"retries,omitempty"`
+	TimeUs      int64               `json:"time_us"`
+	MemoryUsage int64               `json:"memory_usage"`
+	PeakMemory  int64               `json:"peak_memory"`
+	Failures    []jsonReportFailure `json:"failures,omitempty"`
+}
+
+type jsonReportFailure struct {
+	Reason  string `json:"reason"`
+	Message string `json:"message,omitempty"`
+	File    string `json:"file"`
+	Line    int    `json:"line"`
+}
+
+// WriteJSONReport writes the run result as a JSON document.
+//
+// Every class has a "passed", "failed", "build_error" or "run_error" status.
+// Every executed test method has a "passed", "failed" or "flaky" status,
+// the flaky tests keep the failures of their first run.
+func WriteJSONReport(w io.Writer, result *RunResult) error {
+	report := jsonReport{
+		Tests:       result.Tests,
+		Assertions:  result.Assertions,
+		Failures:    len(result.Failures),
+		Flaky:       len(result.Flaky),
+		BuildErrors: len(result.BuildErrors),
+		NotRun:      result.NotRun,
+		TimeMs:      result.Time.Milliseconds(),
+		Classes:     []jsonReportClass{},
+	}
+
+	for _, class := range result.Classes {
+		c := jsonReportClass{
+			File:          class.File,
+			Class:         class.ClassName,
+			Status:        statusPassed,
+			CompileTimeMs: class.CompileTime.Milliseconds(),
+			RunTimeMs:     class.RunTime.Milliseconds(),
+			MaxRSS:        class.MaxRSS,
+			Tests:         []jsonReportMethod{},
+		}
+		switch {
+		case class.BuildError != nil:
+			c.Status = "build_error"
+			c.Error = class.BuildError.Output
+		case class.Err != nil:
+			c.Status = "run_error"
+			c.Error = class.Err.Error()
+		case len(class.Failures) != 0:
+			c.Status = statusFailed
+		}
+
+		for _, m := range class.Methods {
+			name := class.ClassName + "::" + m.Name
+			method := jsonReportMethod{
+				Name:        m.Name,
+				Status:      statusPassed,
+				TimeUs:      m.Time.Microseconds(),
+				MemoryUsage: m.MemoryUsage,
+				PeakMemory:  m.PeakMemory,
+			}
+			for _, failure := range class.Failures {
+				if failure.Name == name {
+					method.Status = statusFailed
+					method.Failures = append(method.Failures, newJSONReportFailure(failure))
+				}
+			}
+			for _, flaky := range class.Flaky {
+				if flaky.Name == name {
+					method.Status = statusFlaky
+					method.Retries = flaky.Retries
+					for _, failure := range flaky.Failures {
+						method.Failures = append(method.Failures, newJSONReportFailure(failure))
+					}
+				}
+			}
+			c.Tests = append(c.Tests, method)
+		}
+
+		report.Classes = append(report.Classes, c)
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(report)
+}
+
+func newJSONReportFailure(failure TestFailure) jsonReportFailure {
+	return jsonReportFailure{
+		Reason:  failure.Reason,
+		Message: failure.Message,
+		File:    failure.File,
+		Line:    failure.Line,
+	}
+}
+
+type junitTestSuites struct {
+	XMLName    xml.Name         `xml:"testsuites"`
+	Name       string           `xml:"name,attr"`
+	Tests      int              `xml:"tests,attr"`
+	Assertions int              `xml:"assertions,attr"`
+	Failures   int              `xml:"failures,attr"`
+	Errors     int              `xml:"errors,attr"`
+	Time       string           `xml:"time,attr"`
+	Suites     []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name       string          `xml:"name,attr"`
+	File       string          `xml:"file,attr"`
+	Tests      int             `xml:"tests,attr"`
+	Assertions int             `xml:"assertions,attr"`
+	Failures   int             `xml:"failures,attr"`
+	Errors     int             `xml:"errors,attr"`
+	Time       string          `xml:"time,attr"`
+	Cases      []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	Name      string         `xml:"name,attr"`
+	ClassName string         `xml:"classname,attr"`
+	File      string         `xml:"file,attr"`
+	Time      string         `xml:"time,attr"`
+	Failures  []junitProblem `xml:"failure,omitempty"`
+	Errors    []junitProblem `xml:"error,omitempty"`
+
+	// FlakyFailures is a Maven Surefire extension for the tests that passed on a retry.
+	FlakyFailures []junitProblem `xml:"flakyFailure,omitempty"`
+}
+
+type junitProblem struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr"`
+	Text    string `xml:",chardata"`
+}
+
+// WriteJUnitReport writes the run result in the JUnit XML format.
+//
+// Every test class is reported as a test suite. The classes that can't
+// be compiled or executed are reported as a single errored test case.
+func WriteJUnitReport(w io.Writer, result *RunResult) error {
+	seconds := func(d time.Duration) string {
+		return fmt.Sprintf("%.6f", d.Seconds())
+	}
+	newFailure := func(failure TestFailure) junitProblem {
+		text := failure.Reason
+		if failure.Message != "" {
+			text = failure.Message + "\n" + text
+		}
+		return junitProblem{
+			Message: failure.Reason,
+			Type:    "AssertionFailedException",
+			Text:    fmt.Sprintf("%s\n\n%s:%d\n", text, failure.File, failure.Line),
+		}
+	}
+
+	doc := junitTestSuites{
+		Name:       "ktest",
+		Assertions: result.Assertions,
+		Time:       seconds(result.Time),
+	}
+	for _, class := range result.Classes {
+		suite := junitTestSuite{
+			Name:       class.ClassName,
+			File:       class.File,
+			Assertions: class.Assertions,
+			Time:       seconds(class.CompileTime + class.RunTime),
+		}
+		var classErr string
+		switch {
+		case class.BuildError != nil:
+			classErr = "build error"
+			if len(class.BuildError.Diagnostics) == 0 {
+				classErr += ": " + class.BuildError.Output
+			}
+			for _, d := range class.BuildError.Diagnostics {
+				classErr += fmt.Sprintf("\n%s:%d: %s", d.File, d.Line, d.Message)
+			}
+		case class.Err != nil:
+			classErr = class.Err.Error()
+		}
+		if classErr != "" {
+			suite.Tests = 1
+			suite.Errors = 1
+			suite.Cases = append(suite.Cases, junitTestCase{
+				Name:      class.ClassName,
+				ClassName: class.ClassName,
+				File:      class.File,
+				Time:      seconds(0),
+				Errors: []junitProblem{{
+					Message: strings.SplitN(classErr, "\n", 2)[0],
+					Type:    "Error",
+					Text:    classErr,
+				}},
+			})
+		}
+
+		for _, m := range class.Methods {
+			name := class.ClassName + "::" + m.Name
+			testCase := junitTestCase{
+				Name:      m.Name,
+				ClassName: class.ClassName,
+				File:      class.File,
+				Time:      seconds(m.Time),
+			}
+			for _, failure := range class.Failures {
+				if failure.Name == name {
+					testCase.Failures = append(testCase.Failures, newFailure(failure))
+				}
+			}
+			for _, flaky := range class.Flaky {
+				if flaky.Name == name {
+					for _, failure := range flaky.Failures {
+						testCase.FlakyFailures = append(testCase.FlakyFailures, newFailure(failure))
+					}
+				}
+			}
+			if len(testCase.Failures) != 0 {
+				suite.Failures++
+			}
+			suite.Tests++
+			suite.Cases = append(suite.Cases, testCase)
+		}
+
+		doc.Tests += suite.Tests
+		doc.Failures += suite.Failures
+		doc.Errors += suite.Errors
+		doc.Suites = append(doc.Suites, suite)
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(doc); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
@@ -0,0 +1,123 @@
+package phpunit
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"testing"
+	"time"
+)
+
+func TestWriteJSONReport(t *testing.T) {
+	flakyFailure := TestFailure{
+		Name:   "FooTest::testB",
+		Reason: "Failed asserting that 1 matches expected 2",
+		File:   "/project/tests/FooTest.php",
+		Line:   10,
+	}
+	result := &RunResult{
+		Tests:      2,
+		Assertions: 2,
+		Time:       2 * time.Second,
+		Flaky: []FlakyTest{
+			{Name: "FooTest::testB", Retries: 1, Failures: []TestFailure{flakyFailure}},
+		},
+		Classes: []*ClassResult{
+			{
+				File:      "/project/tests/FooTest.php",
+				ClassName: "FooTest",
+				Tests:     2,
+				Flaky: []FlakyTest{
+					{Name: "FooTest::testB", Retries: 1, Failures: []TestFailure{flakyFailure}},
+				},
+				Methods: []MethodResult{
+					{Name: "testA", Time: time.Millisecond},
+					{Name: "testB", Time: 2 * time.Millisecond},
+				},
+			},
+		},
+	}
+
+	var buf bytes.Buffer
+	if err := WriteJSONReport(&buf, result); err != nil {
+		t.Fatal(err)
+	}
+	var report jsonReport
+	if err := json.Unmarshal(buf.Bytes(), &report); err != nil {
+		t.Fatalf("decode report: %v", err)
+	}
+
+	if report.Flaky != 1 || report.Failures != 0 || report.TimeMs != 2000 {
+		t.Errorf("unexpected report counters: %+v", report)
+	}
+	if len(report.Classes) != 1 || report.Classes[0].Status != statusPassed {
+		t.Fatalf("unexpected classes: %+v", report.Classes)
+	}
+	methods := report.Classes[0].Tests
+	if len(methods) != 2 {
+		t.Fatalf("unexpected methods: %+v", methods)
+	}
+	if methods[0].Status != statusPassed || len(methods[0].Failures) != 0 {
+		t.Errorf("testA: unexpected result: %+v", methods[0])
+	}
+	if methods[1].Status != statusFlaky || methods[1].Retries != 1 || len(methods[1].Failures) != 1 {
+		t.Errorf("testB: unexpected result: %+v", methods[1])
+	}
+	if methods[1].TimeUs != 2000 {
+		t.Errorf("testB: time mismatch: have %d, want 2000", methods[1].TimeUs)
+	}
+}
+
+func TestWriteJUnitReport(t *testing.T) {
+	result := &RunResult{
+		Tests:      2,
+		Assertions: 1,
+		Failures: []TestFailure{
+			{Name: "FooTest::testB", Reason: "Failed asserting that false is true", File: "FooTest.php", Line: 12},
+		},
+		Classes: []*ClassResult{
+			{
+				File:      "FooTest.php",
+				ClassName: "FooTest",
+				Tests:     2,
+				Failures: []TestFailure{
+					{Name: "FooTest::testB", Reason: "Failed asserting that false is true", File: "FooTest.php", Line: 12},
+				},
+				Methods: []MethodResult{
+					{Name: "testA", Time: time.Millisecond},
+					{Name: "testB", Time: time.Millisecond},
+				},
+			},
+			{
+				File:       "BarTest.php",
+				ClassName:  "BarTest",
+				Tests:      3,
+				BuildError: &BuildError{TestFile: "BarTest.php", TestClass: "BarTest", Output: "oops"},
+			},
+		},
+	}
+
+	var buf bytes.Buffer
+	if err := WriteJUnitReport(&buf, result); err != nil {
+		t.Fatal(err)
+	}
+	var doc junitTestSuites
+	if err := xml.Unmarshal(buf.Bytes(), &doc); err != nil {
+		t.Fatalf("decode report: %v", err)
+	}
+
+	if doc.Tests != 3 || doc.Failures != 1 || doc.Errors != 1 {
+		t.Errorf("unexpected counters: tests=%d failures=%d errors=%d", doc.Tests, doc.Failures, doc.Errors)
+	}
+	if len(doc.Suites) != 2 {
+		t.Fatalf("expected 2 suites, found %d", len(doc.Suites))
+	}
+	foo := doc.Suites[0]
+	if len(foo.Cases) != 2 || len(foo.Cases[0].Failures) != 0 || len(foo.Cases[1].Failures) != 1 {
+		t.Errorf("unexpected FooTest cases: %+v", foo.Cases)
+	}
+	bar := doc.Suites[1]
+	if len(bar.Cases) != 1 || len(bar.Cases[0].Errors) != 1 || bar.Cases[0].Errors[0].Message != "build error: oops" {
+		t.Errorf("unexpected BarTest cases: %+v", bar.Cases)
+	}
+}
@@ -2,35 +2,78 @@ package phpunit
 
 import (
 	"bytes"
+	"context"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
+	"math/rand"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"sort"
 	"strings"
 	"text/template"
+	"time"
 
+	"github.com/VKCOM/php-parser/pkg/version"
+	"github.com/VKCOM/php-parser/pkg/visitor/traverser"
 	"github.com/quasilyte/ktest/internal/fileutil"
-	"github.com/z7zmey/php-parser/pkg/conf"
-	"github.com/z7zmey/php-parser/pkg/errors"
-	"github.com/z7zmey/php-parser/pkg/parser"
-	"github.com/z7zmey/php-parser/pkg/version"
-	"github.com/z7zmey/php-parser/pkg/visitor/traverser"
+	"github.com/quasilyte/ktest/internal/phpsyntax"
 )
 
 type runner struct {
 	conf *RunConfig
 
+	phpVersion *version.Version
+
+	// prevState is a previous run state, it's loaded to re-run the failed tests.
+	prevState *runState
+
 	result RunResult
 
 	testDir   string
 	testFiles []*testFile
 
+	// autoload holds the composer.json autoload paths, they are mirrored into the build dir.
+	autoload *composerAutoload
+
+	// sourceRoots are the absolute paths of the project sources, see composerAutoload.sourceRoots.
+	sourceRoots []string
+
 	buildDir      string
 	buildDirTests string
 	buildDirMains string
+
+	coverageCounters []coverageCounter
+	coverageHits     []int
+
+	// sourceMaps maps the original sources to their build dir copies lines.
+	// Only modified copies have a source map.
+	sourceMaps map[string]*sourceMap
+
+	// sourceOverrides maps absolute source file names to the contents
+	// that should be used instead of the real file contents.
+	// If not nil, sources are copied into the build dir.
+	sourceOverrides map[string][]byte
+
+	// stubs maps the lowercased stub targets to their declarations.
+	stubs map[string]*stubDecl
+
+	// usesClock is set if any test file uses the clock control API.
+	// The time and random built-in calls are rewritten in this case, see clockFunctions.
+	usesClock bool
+
+	// ctx is used to interrupt the build and run commands.
+	ctx context.Context
+
+	// deadline limits the total run time; zero value means "no limit".
+	deadline time.Time
+
+	// quiet suppresses the per-class error logging.
+	quiet bool
+
+	runErrors int
 }
 
 type testFile struct {
@@ -46,22 +89,58 @@ type testFile struct {
 	contents             []byte
 	preprocessedContents []byte
 	generatedMain        []byte
+
+	// sourceMap maps preprocessedContents lines to the contents lines.
+	sourceMap *sourceMap
 }
 
 type testParsedInfo struct {
 	ClassName   string
 	TestMethods []string
 
+	// methodLines maps the test methods to their declaration lines.
+	methodLines map[string]int
+
 	fixes []textEdit
+
+	// usesClock reports whether the tests control the clock or the random
+	// source, like with $this->freezeTime(). The calls of the time and random
+	// built-ins inside the test file are collected to clockFixes.
+	usesClock  bool
+	clockFixes []textEdit
+
+	// stubCalls are the $this->stub() calls with a literal target.
+	stubCalls []stubCall
+
+	// unsupportedCalls are the $this->assert*() and $this->expect*() calls
+	// that are not implemented by the ktest runtime. They're reported
+	// unless the test class declares such methods itself.
+	unsupportedCalls []methodCall
+
+	// declaredMethods is a set of the lowercased test class method names.
+	declaredMethods map[string]bool
+}
+
+// methodCall is a $this method call inside a test class.
+type methodCall struct {
+	method string
+	line   int
 }
 
 func newRunner(conf *RunConfig) *runner {
 	return &runner{conf: conf}
 }
 
-func (r *runner) Run() (*RunResult, error) {
+func (r *runner) Run(ctx context.Context) (*RunResult, error) {
+	r.ctx = ctx
+	if !r.deadline.IsZero() {
+		var cancel context.CancelFunc
+		r.ctx, cancel = context.WithDeadline(r.ctx, r.deadline)
+		defer cancel()
+	}
+
 	defer func() {
-		if r.buildDir == "" || r.conf.NoCleanup {
+		if r.buildDir == "" || r.conf.BuildDir != "" || r.conf.NoCleanup {
 			return
 		}
 		if err := os.RemoveAll(r.buildDir); err != nil {
@@ -73,16 +152,28 @@ func (r *runner) Run() (*RunResult, error) {
 		name string
 		fn   func() error
 	}{
+		{"parse php version", r.stepParsePHPVersion},
 		{"find test files", r.stepFindTestFiles},
-		{"prepare temp build dir", r.stepPrepareTempBuildDir},
+		{"filter test files", r.stepFilterTestFiles},
+		{"filter failed test files", r.stepFilterFailedTestFiles},
 		{"parse test files", r.stepParseTestFiles},
-		{"filter only parsed files", r.stepFilterOnlyParsedFiles},
+		{"filter failed test methods", r.stepFilterFailedTestMethods},
+		{"parse stubs", r.stepParseStubs},
+		{"check stub calls", r.stepCheckStubCalls},
+		{"load composer autoload", r.stepLoadComposerAutoload},
+		{"prepare temp build dir", r.stepPrepareTempBuildDir},
+		{"copy sources", r.stepCopySources},
+		{"check kphp compatibility", r.stepCheckCompatibility},
 		{"sort test files", r.stepSortTestFiles},
+		{"select shard", r.stepSelectShard},
+		{"shuffle test files", r.stepShuffleTestFiles},
 		{"preprocess contents", r.stepPreprocessContents},
 		{"generate test main", r.stepGenerateTestMain},
 		{"write preprocessed test files", r.stepWritePreprocessedTestFiles},
 		{"write test main", r.stepWriteTestMain},
 		{"run kphp tests", r.stepRunKphpTests},
+		{"collect coverage", r.stepCollectCoverage},
+		{"save run state", r.stepSaveState},
 	}
 
 	for _, step := range steps {
@@ -90,16 +181,36 @@ func (r *runner) Run() (*RunResult, error) {
 			return nil, fmt.Errorf("%s: %w", step.name, err)
 		}
 	}
+	// The deadline interrupts the run without an error,
+	// but the caller context cancellation is reported.
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 
 	return &r.result, nil
 }
 
+func (r *runner) logf(format string, args ...interface{}) {
+	if !r.quiet {
+		log.Printf(format, args...)
+	}
+}
+
 func (r *runner) debugf(format string, args ...interface{}) {
 	if r.conf.DebugPrint != nil {
 		r.conf.DebugPrint(fmt.Sprintf(format, args...))
 	}
 }
 
+func (r *runner) stepParsePHPVersion() error {
+	v, err := phpsyntax.ParseVersion(r.conf.PHPVersion)
+	if err != nil {
+		return err
+	}
+	r.phpVersion = v
+	return nil
+}
+
 func (r *runner) stepFindTestFiles() error {
 	var testDir string
 	var testFiles []string
@@ -138,22 +249,84 @@ func (r *runner) stepFindTestFiles() error {
 	return nil
 }
 
-func (r *runner) stepPrepareTempBuildDir() error {
-	tempDir, err := ioutil.TempDir("", "kphpunit-build")
+func (r *runner) stepFilterTestFiles() error {
+	if r.conf.TestFilter == nil {
+		return nil
+	}
+
+	filtered := r.testFiles[:0]
+	for _, f := range r.testFiles {
+		if r.conf.TestFilter(f.fullName) {
+			filtered = append(filtered, f)
+		}
+	}
+	r.testFiles = filtered
+
+	return nil
+}
+
+func (r *runner) stepLoadComposerAutoload() error {
+	autoload, err := loadComposerAutoload(r.conf.ProjectRoot)
 	if err != nil {
 		return err
 	}
+	for _, warning := range autoload.warnings {
+		r.logf("%s", warning)
+	}
+	r.autoload = autoload
+	r.sourceRoots = autoload.sourceRoots(r.conf)
+	r.debugf("source roots: %q", r.sourceRoots)
+	return nil
+}
+
+func (r *runner) stepPrepareTempBuildDir() error {
+	tempDir := r.conf.BuildDir
+	if tempDir == "" {
+		var err error
+		tempDir, err = ioutil.TempDir("", "kphpunit-build")
+		if err != nil {
+			return err
+		}
+	} else if err := fileutil.MkdirAll(tempDir); err != nil {
+		return err
+	}
 	r.buildDir = tempDir
 	r.debugf("temp build dir: %q", tempDir)
 
-	links := []string{
-		r.conf.SrcDir,
-		"vendor",
-		"composer.json",
+	// The vendor dir could be linked during the previous run.
+	if _, err := os.Lstat(filepath.Join(tempDir, "vendor")); err != nil {
+		if err := os.Symlink(filepath.Join(r.conf.ProjectRoot, "vendor"), filepath.Join(tempDir, "vendor")); err != nil {
+			return err
+		}
+	}
+	if err := r.writeComposerManifest(); err != nil {
+		return err
 	}
 
-	for _, l := range links {
-		if err := os.Symlink(filepath.Join(r.conf.ProjectRoot, l), filepath.Join(tempDir, l)); err != nil {
+	// Every autoloaded path is mirrored, so the composer autoloader
+	// finds the same classes inside the build dir. The test dir is
+	// mirrored as well to make the test helpers and fixtures available.
+	mirrored := append([]string{}, r.autoload.devPaths...)
+	if r.autoload.mapsRoot {
+		mirrored = append(mirrored, ".")
+	}
+	var copied []string
+	for _, root := range r.sourceRoots {
+		if r.copySources() {
+			copied = append(copied, root)
+		} else {
+			rel, err := filepath.Rel(r.conf.ProjectRoot, root)
+			if err != nil {
+				return err
+			}
+			mirrored = append(mirrored, rel)
+		}
+	}
+	if testDirRel := filepath.Clean(strings.TrimPrefix(r.testDir, r.conf.ProjectRoot)); !filepath.IsAbs(testDirRel) && testDirRel != "." {
+		mirrored = append(mirrored, testDirRel)
+	}
+	for _, rel := range outermostPaths(mirrored) {
+		if err := r.mirrorPath(rel, copied); err != nil {
 			return err
 		}
 	}
@@ -169,9 +342,155 @@ func (r *runner) stepPrepareTempBuildDir() error {
 		return err
 	}
 
+	if err := fileutil.MkdirAll(filepath.Join(tempDir, "protocol")); err != nil {
+		return err
+	}
+	if err := fileutil.MkdirAll(filepath.Join(tempDir, "coverage")); err != nil {
+		return err
+	}
+	if err := fileutil.WriteFile(r.runtimeFilename(), []byte(runtimeSource)); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// writeComposerManifest makes the project composer.json available inside the build dir.
+// It's symlinked unless some of the autoloaded paths have to be relocated.
+func (r *runner) writeComposerManifest() error {
+	dst := filepath.Join(r.buildDir, "composer.json")
+	// The previous run could have left either a link or a rewritten copy.
+	if err := os.Remove(dst); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	manifest, err := r.autoload.buildDirManifest(r.conf.ProjectRoot)
+	if err != nil {
+		return fmt.Errorf("composer.json: %v", err)
+	}
+	if manifest == nil {
+		return os.Symlink(filepath.Join(r.conf.ProjectRoot, "composer.json"), dst)
+	}
+	return fileutil.WriteFile(dst, manifest)
+}
+
+// copySources reports whether sources should be copied into the build dir
+// instead of being symlinked.
+func (r *runner) copySources() bool {
+	return r.conf.Coverage || r.sourceOverrides != nil || len(r.stubs) != 0 || r.usesClock
+}
+
+func (r *runner) stepCopySources() error {
+	if !r.copySources() {
+		return nil
+	}
+
+	if r.conf.Coverage {
+		if err := fileutil.WriteFile(r.coverageRuntimeFilename(), []byte(coverageRuntimeSource)); err != nil {
+			return err
+		}
+	}
+	if len(r.stubs) != 0 {
+		if err := r.writeStubShims(); err != nil {
+			return err
+		}
+	}
+
+	rewrites := r.callRewrites()
+	r.sourceMaps = make(map[string]*sourceMap)
+	for _, root := range r.sourceRoots {
+		if err := r.copySourceRoot(root, rewrites); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
+func (r *runner) copySourceRoot(root string, rewrites map[string]string) error {
+	rootRel, err := filepath.Rel(r.conf.ProjectRoot, root)
+	if err != nil {
+		return err
+	}
+	if err := os.RemoveAll(filepath.Join(r.buildDir, buildDirRel(rootRel))); err != nil {
+		return err
+	}
+
+	return filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(r.conf.ProjectRoot, path)
+		if err != nil {
+			return err
+		}
+		src, ok := r.sourceOverrides[path]
+		if !ok {
+			src, err = ioutil.ReadFile(path)
+			if err != nil {
+				return err
+			}
+		}
+		dst := filepath.Join(r.buildDir, buildDirRel(rel))
+		// The stubs themselves call the stubbed targets,
+		// so their calls are never rewritten.
+		rewriteCalls := len(rewrites) != 0 && path != r.conf.StubsFile
+		if (!r.conf.Coverage && !rewriteCalls) || !strings.HasSuffix(path, ".php") {
+			return fileutil.WriteFile(dst, src)
+		}
+
+		rootNode, parserErrors := phpsyntax.Parse(src, r.phpVersion)
+		if len(parserErrors) != 0 {
+			for _, parseErr := range parserErrors {
+				r.logf("%s: parse error: %v", path, parseErr)
+			}
+			return fileutil.WriteFile(dst, src)
+		}
+		var edits []textEdit
+		if r.conf.Coverage {
+			v := &coverageVisitor{
+				filename: rel,
+				counters: r.coverageCounters,
+			}
+			traverser.NewTraverser(v).Traverse(rootNode)
+			r.coverageCounters = v.counters
+			edits = append(edits, v.edits...)
+		}
+		if rewriteCalls {
+			v := newCallsRewriteVisitor(rewrites)
+			traverser.NewTraverser(v).Traverse(rootNode)
+			edits = append(edits, v.edits...)
+		}
+		if len(edits) == 0 {
+			return fileutil.WriteFile(dst, src)
+		}
+		instrumented := applyTextEdits(src, edits)
+		r.sourceMaps[path] = newSourceMap(src, edits)
+		return fileutil.WriteFile(dst, instrumented)
+	})
+}
+
+func (r *runner) runtimeFilename() string {
+	return filepath.Join(r.buildDir, "ktest", "runtime.php")
+}
+
+// methodsEnvVar restricts the test methods executed by the test binary,
+// see KTest\Runtime::setFilter.
+const methodsEnvVar = "KTEST_METHODS"
+
+func (r *runner) protocolFilename(f *testFile) string {
+	return filepath.Join(r.buildDir, "protocol", fmt.Sprintf("%d.txt", f.id))
+}
+
+func (r *runner) coverageRuntimeFilename() string {
+	return filepath.Join(r.buildDir, "ktest", "coverage.php")
+}
+
+func (r *runner) coverageFilename(f *testFile) string {
+	return filepath.Join(r.buildDir, "coverage", fmt.Sprintf("%d.txt", f.id))
+}
+
 func (r *runner) stepParseTestFiles() error {
 	for _, f := range r.testFiles {
 		src, err := ioutil.ReadFile(f.fullName)
@@ -179,36 +498,32 @@ func (r *runner) stepParseTestFiles() error {
 			return fmt.Errorf("read file: %w", err)
 		}
 		f.contents = src
-		var parserErrors []*errors.Error
-		errorHandler := func(e *errors.Error) {
-			parserErrors = append(parserErrors, e)
-		}
-		rootNode, err := parser.Parse(src, conf.Config{
-			Version:          &version.Version{Major: 7, Minor: 4},
-			ErrorHandlerFunc: errorHandler,
-		})
+		rootNode, parserErrors := phpsyntax.Parse(src, r.phpVersion)
 		if len(parserErrors) != 0 {
-			for _, parseErr := range parserErrors {
-				log.Printf("%s: parse error: %v", f.fullName, parseErr)
+			for _, parseErr := range parserErrors[1:] {
+				r.logf("%s: parse error: %v", f.fullName, parseErr)
 			}
-			continue
+			return fmt.Errorf("%s: parse error: %v (PHP version %d.%d)",
+				f.fullName, parserErrors[0], r.phpVersion.Major, r.phpVersion.Minor)
 		}
 		f.info = &testParsedInfo{}
-		visitor := &astVisitor{out: f.info}
+		visitor := &astVisitor{
+			out:      f.info,
+			filename: relativeTestFile(r.conf.ProjectRoot, f.fullName),
+		}
 		traverser.NewTraverser(visitor).Traverse(rootNode)
-	}
-
-	return nil
-}
-
-func (r *runner) stepFilterOnlyParsedFiles() error {
-	parsedFiles := make([]*testFile, 0, len(r.testFiles))
-	for _, f := range r.testFiles {
-		if f.info != nil {
-			parsedFiles = append(parsedFiles, f)
+		for _, call := range f.info.unsupportedCalls {
+			if f.info.declaredMethods[strings.ToLower(call.method)] {
+				continue
+			}
+			return fmt.Errorf("%s:%d: $this->%s() is not supported by ktest, see the supported assertions list in the README",
+				f.fullName, call.line, call.method)
+		}
+		if f.info.usesClock {
+			f.info.fixes = append(f.info.fixes, f.info.clockFixes...)
+			r.usesClock = true
 		}
 	}
-	r.testFiles = parsedFiles
 
 	return nil
 }
@@ -225,21 +540,86 @@ func (r *runner) stepSortTestFiles() error {
 	return nil
 }
 
+func (r *runner) stepShuffleTestFiles() error {
+	switch r.conf.OrderBy {
+	case "", "default":
+		return nil
+	case "random":
+		// OK.
+	default:
+		return fmt.Errorf("unexpected order-by value: %q", r.conf.OrderBy)
+	}
+
+	seed := r.conf.RandomOrderSeed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	r.result.RandomOrderSeed = seed
+	fmt.Fprintf(r.conf.Output, "Random order seed: %d\n\n", seed)
+
+	random := rand.New(rand.NewSource(seed))
+	random.Shuffle(len(r.testFiles), func(i, j int) {
+		r.testFiles[i], r.testFiles[j] = r.testFiles[j], r.testFiles[i]
+	})
+	for i, f := range r.testFiles {
+		f.id = i
+		methods := f.info.TestMethods
+		random.Shuffle(len(methods), func(i, j int) {
+			methods[i], methods[j] = methods[j], methods[i]
+		})
+	}
+
+	return nil
+}
+
 func (r *runner) stepPreprocessContents() error {
 	for _, f := range r.testFiles {
 		f.preprocessedContents = applyTextEdits(f.contents, f.info.fixes)
+		f.sourceMap = newSourceMap(f.contents, f.info.fixes)
 	}
 
 	return nil
 }
 
 func (r *runner) stepGenerateTestMain() error {
+	// The generators use a 31-bit random source, see KTest\Random.
+	r.result.PropertySeed = r.conf.PropertySeed & 0x7fffffff
+	if r.result.PropertySeed == 0 {
+		r.result.PropertySeed = rand.New(rand.NewSource(time.Now().UnixNano())).Int63n(0x7fffffff) + 1
+	}
+
 	for _, f := range r.testFiles {
 		var generated bytes.Buffer
 		templateData := map[string]interface{}{
-			"TestFilename":  filepath.Join(r.buildDirTests, f.shortName),
-			"TestClassName": f.info.ClassName,
-			"TestMethods":   f.info.TestMethods,
+			"RuntimeFilename":  r.runtimeFilename(),
+			"ProtocolFilename": r.protocolFilename(f),
+			"TestFilename":     filepath.Join(r.buildDirTests, f.shortName),
+			"TestClassName":    f.info.ClassName,
+			"TestMethods":      f.info.TestMethods,
+			"MemoryLimit":      r.conf.MemoryLimitPerTest,
+			"MethodsEnvVar":    methodsEnvVar,
+			// A failure can turn out to be flaky, so the retries
+			// require the remaining methods to be executed.
+			"StopOnFailure": r.conf.StopOnFailure && r.conf.Retries == 0,
+			"PropertySeed":  r.result.PropertySeed,
+			"PropertyRuns":  r.conf.PropertyRuns,
+			"UsesClock":     r.usesClock,
+
+			"SnapshotRoot":    escapePHPString(filepath.Clean(r.conf.ProjectRoot)),
+			"UpdateSnapshots": r.conf.UpdateSnapshots,
+		}
+		if r.conf.Coverage {
+			templateData["Coverage"] = map[string]interface{}{
+				"RuntimeFilename": r.coverageRuntimeFilename(),
+				"OutputFilename":  r.coverageFilename(f),
+				"NumCounters":     len(r.coverageCounters),
+			}
+		}
+		if len(r.stubs) != 0 {
+			templateData["Stubs"] = map[string]interface{}{
+				"Filename":     r.buildDirFilename(r.conf.StubsFile),
+				"ShimFilename": r.stubShimFilename(),
+			}
 		}
 		if err := testMainTemplate.Execute(&generated, templateData); err != nil {
 			return fmt.Errorf("%s: %w", f.fullName, err)
@@ -252,23 +632,64 @@ func (r *runner) stepGenerateTestMain() error {
 
 var testMainTemplate = template.Must(template.New("test_main").Parse(`<?php
 
+require_once '{{.RuntimeFilename}}';
+
+{{- if .Coverage}}
+require_once '{{.Coverage.RuntimeFilename}}';
+{{- end}}
+
+{{- if .Stubs}}
+require_once '{{.Stubs.Filename}}';
+require_once '{{.Stubs.ShimFilename}}';
+{{- end}}
+
 require_once '{{.TestFilename}}';
 
-use KPHPUnit\Framework\TestCase;
-use KPHPUnit\Framework\AssertionFailedException;
+use KTest\AssertionFailedException;
 
 function __kphpunit_main() {
+  \KTest\Runtime::open('{{.ProtocolFilename}}');
+  {{- if .MemoryLimit}}
+  \KTest\Runtime::$memoryLimit = {{.MemoryLimit}};
+  {{- end}}
+  {{- if .StopOnFailure}}
+  \KTest\Runtime::$stopOnFailure = true;
+  {{- end}}
+  \KTest\Runtime::$propertySeed = {{.PropertySeed}};
+  \KTest\Runtime::$snapshotRoot = '{{.SnapshotRoot}}';
+  {{- if .UpdateSnapshots}}
+  \KTest\Runtime::$updateSnapshots = true;
+  {{- end}}
+  {{- if .PropertyRuns}}
+  \KTest\Runtime::$propertyRuns = {{.PropertyRuns}};
+  {{- end}}
+  {{- if .Coverage}}
+  \KTest\Coverage::init({{.Coverage.NumCounters}});
+  {{- end}}
+  \KTest\Runtime::setFilter((string)getenv('{{.MethodsEnvVar}}'));
   $test = new {{.TestClassName}}();
   {{range .TestMethods}}
-  try {
-    echo '["START","{{.}}"]' . "\n";
-    $test->{{.}}();
-    fprintf(STDERR, '.');
-  } catch (AssertionFailedException $e) {
-    fprintf(STDERR, 'F');
+  if (\KTest\Runtime::shouldRun('{{.}}')) {
+    {{- if $.UsesClock}}
+    \KTest\Clock::reset();
+    {{- end}}
+    \KTest\Runtime::startTest('{{.}}');
+    try {
+      $test->{{.}}();
+      $passed = \KTest\Runtime::finishTest(true);
+    } catch (AssertionFailedException $e) {
+      $passed = \KTest\Runtime::finishTest(false);
+    } catch (\KPHPUnit\Framework\AssertionFailedException $e) {
+      \KTest\Runtime::kphpunitFailed($e);
+      $passed = \KTest\Runtime::finishTest(false);
+    }
+    fprintf(STDERR, $passed ? '.' : 'F');
   }
   {{- end}}
-  echo '["FINISHED"]' . "\n";
+  \KTest\Runtime::write(['FINISHED']);
+  {{- if .Coverage}}
+  \KTest\Coverage::dump('{{.Coverage.OutputFilename}}');
+  {{- end}}
 }
 
 __kphpunit_main();
@@ -304,57 +725,296 @@ func (r *runner) stepRunKphpTests() error {
 		testsTotal += len(f.info.TestMethods)
 	}
 
+	if r.conf.Coverage {
+		r.coverageHits = make([]int, len(r.coverageCounters))
+	}
+
 	testsCompleted := 0
+loop:
 	for _, f := range r.testFiles {
+		if r.ctx.Err() != nil {
+			break // Interrupted, see Run
+		}
 		testsCompleted += len(f.info.TestMethods)
+		completed := float64(testsCompleted) / float64(testsTotal) * 100.0
 
-		// 1. Build.
-		args := []string{
-			"--mode", "cli",
-			"--destination-directory", r.buildDir,
+		if r.conf.Events.ClassStarted != nil {
+			r.conf.Events.ClassStarted(f.fullName, f.info.ClassName)
 		}
-		if composerMode {
-			args = append(args, "--composer-root", r.conf.ProjectRoot)
+		res := r.runTestFile(f, composerMode)
+		r.result.Classes = append(r.result.Classes, res)
+		if r.conf.Events.ClassFinished != nil {
+			r.conf.Events.ClassFinished(res)
 		}
-		args = append(args, f.mainFilename)
-		buildCommand := exec.Command(r.conf.KphpCommand, args...)
-		buildCommand.Dir = r.buildDir
-		out, err := buildCommand.CombinedOutput()
-		if err != nil {
-			log.Printf("%s: build error: %v: %s", f.fullName, err, out)
-			continue
+
+		switch {
+		case res.BuildError != nil:
+			r.result.BuildErrors = append(r.result.BuildErrors, *res.BuildError)
+			fmt.Fprintf(r.conf.Output, " %d / %d (%2d%%) %s\n", testsCompleted, testsTotal, int(completed), "BUILD ERROR")
+			if r.conf.StopOnSharedBuildError && res.BuildError.IsShared() {
+				r.result.NotRun += testsTotal - testsCompleted
+				r.logf("%s: stopping after a build error in the shared code", f.fullName)
+				break loop
+			}
+			if r.conf.StopOnError || r.conf.StopOnFailure {
+				r.result.NotRun += testsTotal - testsCompleted
+				r.logf("%s: stopping after a build error", f.fullName)
+				break loop
+			}
+
+		case res.Err != nil:
+			r.runErrors++
+			r.logf("%s: %v", f.fullName, res.Err)
+			if r.conf.StopOnError || r.conf.StopOnFailure {
+				r.result.NotRun += testsTotal - testsCompleted
+				r.logf("%s: stopping after a run error", f.fullName)
+				break loop
+			}
+
+		default:
+			status := "OK"
+			if len(res.Failures) != 0 {
+				status = "FAIL"
+			} else if len(res.Flaky) != 0 {
+				status = "FLAKY"
+			}
+			fmt.Fprintf(r.conf.Output, " %d / %d (%2d%%) %s\n", testsCompleted, testsTotal, int(completed), status)
+
+			r.result.Failures = append(r.result.Failures, res.Failures...)
+			r.result.Flaky = append(r.result.Flaky, res.Flaky...)
+			r.result.Assertions += res.Assertions
+
+			if r.conf.StopOnFailure && len(res.Failures) != 0 {
+				// The test binary skips the methods after the failed one.
+				testsCompleted -= res.Tests - len(res.Methods)
+				r.result.NotRun += testsTotal - testsCompleted
+				r.logf("%s: stopping after a failure", f.fullName)
+				break loop
+			}
 		}
+	}
+	r.result.Tests = testsCompleted
+
+	return nil
+}
 
-		// 2. Run.
-		executableName := filepath.Join(r.buildDir, "cli")
-		runCommand := exec.Command(executableName)
-		runCommand.Dir = r.buildDir
-		var runStdout bytes.Buffer
-		runCommand.Stderr = r.conf.Output
-		runCommand.Stdout = &runStdout
-		if err := runCommand.Run(); err != nil {
-			log.Printf("%s: run error: %v", f.fullName, err)
-			continue
+// runTestFile builds and runs a single test class.
+func (r *runner) runTestFile(f *testFile, composerMode bool) *ClassResult {
+	res := &ClassResult{
+		File:      f.fullName,
+		ClassName: f.info.ClassName,
+		Tests:     len(f.info.TestMethods),
+	}
+
+	// 1. Build.
+	args := []string{
+		"--mode", "cli",
+		"--destination-directory", r.buildDir,
+	}
+	if composerMode {
+		// The build dir mirrors the autoloaded paths, so the classes
+		// are loaded from the instrumented copies when there are any.
+		args = append(args, "--composer-root", r.buildDir)
+	}
+	args = append(args, f.mainFilename)
+	buildCommand := exec.CommandContext(r.ctx, r.conf.KphpCommand, args...)
+	buildCommand.Dir = r.buildDir
+	buildStart := time.Now()
+	out, err := buildCommand.CombinedOutput()
+	res.CompileTime = time.Since(buildStart)
+	if err != nil {
+		r.debugf("%s: build error: %v", f.fullName, err)
+		buildErr := r.newBuildError(f, out)
+		res.BuildError = &buildErr
+		return res
+	}
+
+	// 2. Run.
+	var parsed *testFileResult
+	if r.conf.MemoryLimitPerTest > 0 {
+		parsed, err = r.runTestMethods(f, res)
+	} else {
+		parsed, err = r.runTestBinary(f, res, r.conf.Output)
+		if err == nil {
+			r.collectCoverage(f)
+		}
+	}
+	if err != nil {
+		res.Err = err
+		return res
+	}
+	res.Assertions = parsed.asserts
+	res.Failures = parsed.failures
+	res.Methods = parsed.methods
+
+	// 3. Retry failed tests.
+	if r.conf.Retries > 0 {
+		r.retryFailedTests(f, res)
+	}
+
+	return res
+}
+
+// runTestBinary executes the compiled test binary and parses its results.
+// If methods are specified, only they are executed.
+func (r *runner) runTestBinary(f *testFile, res *ClassResult, stderr io.Writer, methods ...string) (*testFileResult, error) {
+	// Remove the protocol file left by the previous run, if any.
+	os.Remove(r.protocolFilename(f))
+	executableName := filepath.Join(r.buildDir, "cli")
+	runCommand := exec.CommandContext(r.ctx, executableName)
+	runCommand.Dir = r.buildDir
+	if len(methods) != 0 {
+		runCommand.Env = append(os.Environ(), methodsEnvVar+"="+strings.Join(methods, ","))
+	}
+	var runStdout bytes.Buffer
+	runCommand.Stderr = stderr
+	runCommand.Stdout = &runStdout
+	runStart := time.Now()
+	err := runCommand.Run()
+	res.RunTime += time.Since(runStart)
+	if runCommand.ProcessState != nil {
+		if rss := maxRSS(runCommand.ProcessState); rss > res.MaxRSS {
+			res.MaxRSS = rss
 		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("run error: %w", err)
+	}
+	if runStdout.Len() != 0 {
+		// The test methods output is captured by the runtime,
+		// this is something printed outside of them.
+		r.debugf("%s: stdout: %s", f.fullName, runStdout.Bytes())
+	}
+
+	protocol, err := ioutil.ReadFile(r.protocolFilename(f))
+	if err != nil {
+		return nil, fmt.Errorf("read test protocol: %w", err)
+	}
+	parsed, err := parseTestOutput(f, protocol)
+	if err != nil {
+		return nil, fmt.Errorf("parse test output: %w", err)
+	}
+	r.result.UpdatedSnapshots = append(r.result.UpdatedSnapshots, parsed.updatedSnapshots...)
+	return parsed, nil
+}
 
-		// 3. Parse output.
-		parsed, err := parseTestOutput(f, runStdout.Bytes())
+// runTestMethods executes every test method in a separate process and merges the results.
+//
+// KPHP can't reset the process peak memory usage, so this is how the memory limit
+// is checked for every test: otherwise a test that runs after a heavier one
+// would never exceed the peak of the earlier tests.
+func (r *runner) runTestMethods(f *testFile, res *ClassResult) (*testFileResult, error) {
+	merged := &testFileResult{
+		finished: true,
+		outputs:  make(map[string]string),
+	}
+	stopOnFailure := r.conf.StopOnFailure && r.conf.Retries == 0
+	for _, method := range f.info.TestMethods {
+		parsed, err := r.runTestBinary(f, res, r.conf.Output, method)
 		if err != nil {
-			log.Printf("%s: parse test output: %v", f.fullName, err)
-			continue
+			return nil, err
+		}
+		r.collectCoverage(f)
+		merged.finished = merged.finished && parsed.finished
+		merged.asserts += parsed.asserts
+		merged.failures = append(merged.failures, parsed.failures...)
+		merged.methods = append(merged.methods, parsed.methods...)
+		merged.updatedSnapshots = append(merged.updatedSnapshots, parsed.updatedSnapshots...)
+		for name, output := range parsed.outputs {
+			merged.outputs[name] = output
 		}
+		if stopOnFailure && len(parsed.failures) != 0 {
+			break
+		}
+	}
+	return merged, nil
+}
+
+// collectCoverage adds the coverage hits of the last test binary run.
+// The coverage file is overwritten by every run, so it's collected
+// before the failed tests are retried.
+func (r *runner) collectCoverage(f *testFile) {
+	if !r.conf.Coverage {
+		return
+	}
+	if err := r.addCoverageHits(f); err != nil {
+		r.logf("%s: collect coverage: %v", f.fullName, err)
+	}
+}
 
-		status := "OK"
-		if len(parsed.failures) != 0 {
-			status = "FAIL"
+// retryFailedTests re-runs every failed test method in a separate process.
+// The methods that pass on a retry are moved from the failures to the flaky tests.
+func (r *runner) retryFailedTests(f *testFile, res *ClassResult) {
+	var failedMethods []string
+	failuresByName := make(map[string][]TestFailure)
+	for _, failure := range res.Failures {
+		if _, ok := failuresByName[failure.Name]; !ok {
+			failedMethods = append(failedMethods, failure.Name)
 		}
-		completed := float64(testsCompleted) / float64(testsTotal) * 100.0
-		fmt.Fprintf(r.conf.Output, " %d / %d (%2d%%) %s\n", testsCompleted, testsTotal, int(completed), status)
+		failuresByName[failure.Name] = append(failuresByName[failure.Name], failure)
+	}
+
+	for _, name := range failedMethods {
+		method := strings.TrimPrefix(name, f.info.ClassName+"::")
+		for attempt := 1; attempt <= r.conf.Retries; attempt++ {
+			if r.ctx.Err() != nil {
+				return
+			}
+			var stderr bytes.Buffer
+			parsed, err := r.runTestBinary(f, res, &stderr, method)
+			r.debugf("%s: retry %d: stderr: %s", name, attempt, stderr.Bytes())
+			if err != nil {
+				r.debugf("%s: retry %d: %v", name, attempt, err)
+				continue
+			}
+			if !parsed.finished || len(parsed.failures) != 0 {
+				continue
+			}
+			res.Flaky = append(res.Flaky, FlakyTest{
+				Name:     name,
+				Retries:  attempt,
+				Failures: failuresByName[name],
+			})
+			break
+		}
+	}
 
-		r.result.Failures = append(r.result.Failures, parsed.failures...)
-		r.result.Assertions += parsed.asserts
+	if len(res.Flaky) == 0 {
+		return
 	}
-	r.result.Tests = testsCompleted
+	failures := res.Failures[:0]
+	for _, failure := range res.Failures {
+		if !res.isFlaky(failure.Name) {
+			failures = append(failures, failure)
+		}
+	}
+	res.Failures = failures
+}
+
+func (r *runner) addCoverageHits(f *testFile) error {
+	data, err := ioutil.ReadFile(r.coverageFilename(f))
+	if err != nil {
+		return err
+	}
+	hits, err := parseCoverageCounters(data)
+	if err != nil {
+		return err
+	}
+	if len(hits) != len(r.coverageCounters) {
+		return fmt.Errorf("expected %d counters, got %d", len(r.coverageCounters), len(hits))
+	}
+	for i, n := range hits {
+		r.coverageHits[i] += n
+	}
+	return nil
+}
+
+func (r *runner) stepCollectCoverage() error {
+	if !r.conf.Coverage {
+		return nil
+	}
+
+	r.result.Coverage = buildCoverageProfile(r.coverageCounters, r.coverageHits)
 
 	return nil
 }
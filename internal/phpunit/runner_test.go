@@ -0,0 +1,79 @@
+package phpunit
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/quasilyte/ktest/internal/fileutil"
+)
+
+func TestRunTestMethods(t *testing.T) {
+	buildDir, err := ioutil.TempDir("", "ktest-run-methods")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(buildDir)
+
+	// The fake test binary reports a memory limit failure for testAfterHeavy,
+	// the real one could only do that when it runs in its own process.
+	cli := `#!/bin/sh
+echo "$KTEST_METHODS" >> runs.txt
+{
+  echo '["START","'"$KTEST_METHODS"'"]'
+  echo '["ASSERT_OK"]'
+  if [ "$KTEST_METHODS" = testAfterHeavy ]; then
+    echo '["ASSERT_MEMORY_LIMIT_FAILED",["int","1024"],["int","2048"],"",0]'
+  fi
+  echo '["OUTPUT",["string",""]]'
+  echo '["TIME",1000]'
+  echo '["MEMORY",0,2048]'
+  echo '["FINISHED"]'
+} > protocol/0.txt
+`
+	if err := fileutil.WriteFile(filepath.Join(buildDir, "cli"), []byte(cli)); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(filepath.Join(buildDir, "cli"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := fileutil.MkdirAll(filepath.Join(buildDir, "protocol")); err != nil {
+		t.Fatal(err)
+	}
+
+	f := &testFile{
+		fullName: "/project/tests/FooTest.php",
+		info: &testParsedInfo{
+			ClassName:   "FooTest",
+			TestMethods: []string{"testHeavy", "testAfterHeavy", "testLight"},
+		},
+	}
+	r := &runner{
+		ctx:      context.Background(),
+		conf:     &RunConfig{MemoryLimitPerTest: 1024},
+		buildDir: buildDir,
+	}
+	res := &ClassResult{}
+	parsed, err := r.runTestMethods(f, res)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	runs, err := ioutil.ReadFile(filepath.Join(buildDir, "runs.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have := strings.Fields(string(runs)); !reflect.DeepEqual(have, f.info.TestMethods) {
+		t.Errorf("runs mismatch:\nhave: %q\nwant: %q", have, f.info.TestMethods)
+	}
+	if !parsed.finished || parsed.asserts != 3 || len(parsed.methods) != 3 {
+		t.Errorf("unexpected result: finished=%v asserts=%d methods=%d", parsed.finished, parsed.asserts, len(parsed.methods))
+	}
+	if len(parsed.failures) != 1 || parsed.failures[0].Name != "FooTest::testAfterHeavy" {
+		t.Errorf("unexpected failures: %+v", parsed.failures)
+	}
+}
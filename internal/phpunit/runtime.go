@@ -0,0 +1,753 @@
+package phpunit
+
+// runtimeSource is a PHP code that is compiled into every test main.
+//
+// It implements the assertions and writes the test results
+// to the protocol file, so the stdout is left to the tests.
+// The test output is captured with an output buffer and
+// reported with a separate OUTPUT op.
+//
+// The protocol file consists of the JSON lines:
+//
+//	["START", test]
+//	["OUTPUT", text]
+//	["TIME", nanoseconds]
+//	["MEMORY", usage, peak]
+//	["SNAPSHOT", line, file, value]
+//	["PROPERTY", seed, runs, shrinks, args]
+//	["ASSERT_OK"]
+//	["ASSERT_*_FAILED", expected, actual, message, line]
+//	["FINISHED"]
+//
+// The executed test methods can be restricted with the $KTEST_METHODS
+// environment variable, it's used to re-run the failed tests.
+// If $stopOnFailure is set, the methods after the first failed one are skipped.
+//
+// The test class assertions are rewritten to this runtime, the kphpunit ones
+// that are called outside of it, like inside a base test case, throw their own
+// exception: the generated main reports it with the ASSERT_KPHPUNIT_FAILED op.
+//
+// assertMatchesSnapshot compares the value exported by the export method with
+// the snapshot file, the mismatch fails the test right away. The snapshot name is
+// reported in place of the ASSERT_SNAPSHOT_*_FAILED message. If $updateSnapshots
+// is set, the mismatching snapshots are written and reported with the
+// ["SNAPSHOT_UPDATED", name] op. See snapshotDir for the files layout.
+//
+// The values (expected, actual and the output text) are encoded with a type tag,
+// see decodeTypedValue for the encoding description.
+//
+// The MEMORY op reports the memory_get_usage() difference between the test
+// start and finish and the test peak usage. There is no way to reset the peak
+// usage counter, so the peak is measured relative to the test start memory usage
+// and is reported as 0 if the test didn't exceed the peak of the previous tests.
+// When the memory limit is set, ktest runs every test method in its own process,
+// so no test is hidden behind the peak of another one.
+// If $memoryLimit is set, the tests with a greater peak usage fail with
+// the ASSERT_MEMORY_LIMIT_FAILED op (the line is reported as 0).
+//
+// forAll runs a property with the values produced by the Gen generators.
+// The assertions are not reported while the counterexample is searched and shrunk.
+// When the property fails, a PROPERTY op with the minimal counterexample is written,
+// it's followed by the failure of that counterexample. Exceptions other than the
+// assertion failures are reported with the ASSERT_EXCEPTION_FAILED op.
+// The generators use their own random source, so a failure can be replayed
+// by running the test with the reported seed (-property-seed).
+// forAll and Gen exist only in this runtime, the property tests can't be
+// executed by PHPUnit.
+//
+// The Clock replaces the time and random built-ins when the tests control them,
+// the generated main resets it before every test method.
+const runtimeSource = `<?php
+
+namespace KTest;
+
+class AssertionFailedException extends \Exception {}
+
+/**
+ * The test classes can use this trait to run with PHPUnit, see php/KTest/SnapshotAssertions.php.
+ * The assertMatchesSnapshot calls are rewritten to the Runtime, so it's empty here.
+ */
+trait SnapshotAssertions {}
+
+class Random {
+  /** @var int */
+  private $state;
+
+  public function __construct(int $seed) {
+    $this->state = $seed & 0x7fffffff;
+  }
+
+  // A 31-bit LCG never overflows the int, so it behaves
+  // identically in PHP and KPHP.
+  public function next(): int {
+    $this->state = ($this->state * 1103515245 + 12345) & 0x7fffffff;
+    return $this->state;
+  }
+
+  // next63 combines three draws into a non-negative 63-bit value.
+  private function next63(): int {
+    return ((($this->next() << 31) | $this->next()) << 1) | ($this->next() & 1);
+  }
+
+  public function intn(int $min, int $max): int {
+    if ($min > $max) {
+      throw new \Exception('Argument #1 ($min) must be less than or equal to argument #2 ($max)');
+    }
+    if ($min < 0 && $max > PHP_INT_MAX + $min) {
+      // $max - $min doesn't fit into an int, but the range covers
+      // at least a half of all ints: retry until a value falls into it.
+      do {
+        $n = $this->next63();
+        if (($this->next() & 1) === 1) {
+          $n = $n + PHP_INT_MIN;
+        }
+      } while ($n < $min || $n > $max);
+      return $n;
+    }
+    $span = $max - $min;
+    if ($span < 0x7fffffff) {
+      return $min + $this->next() % ($span + 1);
+    }
+    if ($span === PHP_INT_MAX) {
+      return $min + $this->next63();
+    }
+    return $min + $this->next63() % ($span + 1);
+  }
+}
+
+// Clock replaces the time and random built-ins while the clock is controlled.
+// Otherwise, the built-ins are called.
+class Clock {
+  /** @var ?int */
+  private static $frozenNanos = null;
+  /** @var ?Random */
+  private static $random = null;
+
+  public static function reset() {
+    self::$frozenNanos = null;
+    self::$random = null;
+  }
+
+  public static function freeze(?int $timestamp) {
+    if ($timestamp === null) {
+      self::$frozenNanos = (int)((float)\microtime(true) * 1e9);
+    } else {
+      self::$frozenNanos = $timestamp * 1000000000;
+    }
+  }
+
+  public static function advance(float $seconds) {
+    if (self::$frozenNanos === null) {
+      self::freeze(null);
+    }
+    self::$frozenNanos = (int)self::$frozenNanos + (int)($seconds * 1e9);
+  }
+
+  public static function seed(int $seed) {
+    self::$random = new Random($seed);
+  }
+
+  public static function time(): int {
+    if (self::$frozenNanos === null) {
+      return \time();
+    }
+    return intdiv((int)self::$frozenNanos, 1000000000);
+  }
+
+  /** @return mixed */
+  public static function microtime(bool $asFloat = false) {
+    if (self::$frozenNanos === null) {
+      return \microtime($asFloat);
+    }
+    $nanos = (int)self::$frozenNanos;
+    $sec = intdiv($nanos, 1000000000);
+    $frac = ($nanos % 1000000000) / 1e9;
+    if ($asFloat) {
+      return $sec + $frac;
+    }
+    return sprintf('%.8F %d', $frac, $sec);
+  }
+
+  /** @return mixed */
+  public static function hrtime(bool $asNumber = false) {
+    if (self::$frozenNanos === null) {
+      return \hrtime($asNumber);
+    }
+    $nanos = (int)self::$frozenNanos;
+    if ($asNumber) {
+      return $nanos;
+    }
+    return [intdiv($nanos, 1000000000), $nanos % 1000000000];
+  }
+
+  public static function mt_rand(int $min = 0, ?int $max = null): int {
+    if (self::$random === null) {
+      return $max === null ? \mt_rand() : \mt_rand($min, $max);
+    }
+    return self::$random->intn($min, $max === null ? mt_getrandmax() : $max);
+  }
+
+  public static function rand(int $min = 0, ?int $max = null): int {
+    if (self::$random === null) {
+      return $max === null ? \rand() : \rand($min, $max);
+    }
+    return self::$random->intn($min, $max === null ? getrandmax() : $max);
+  }
+
+  public static function random_int(int $min, int $max): int {
+    if (self::$random === null) {
+      return \random_int($min, $max);
+    }
+    return self::$random->intn($min, $max);
+  }
+}
+
+class Gen {
+  /** @var string */
+  private $kind;
+  /** @var int */
+  private $min = 0;
+  /** @var int */
+  private $max = 0;
+  /** @var ?Gen */
+  private $elem = null;
+
+  private function __construct(string $kind) {
+    $this->kind = $kind;
+  }
+
+  public static function ints(int $min = -1000, int $max = 1000): Gen {
+    $gen = new Gen('int');
+    $gen->min = $min;
+    $gen->max = $max;
+    return $gen;
+  }
+
+  public static function bools(): Gen {
+    return new Gen('bool');
+  }
+
+  /** Generates the printable ASCII strings. */
+  public static function strings(int $maxLen = 20): Gen {
+    $gen = new Gen('string');
+    $gen->max = $maxLen;
+    return $gen;
+  }
+
+  public static function arrays(Gen $elem, int $maxLen = 10): Gen {
+    $gen = new Gen('array');
+    $gen->max = $maxLen;
+    $gen->elem = $elem;
+    return $gen;
+  }
+
+  /** @return mixed */
+  public function generate(Random $rand) {
+    switch ($this->kind) {
+    case 'int':
+      return $rand->intn($this->min, $this->max);
+    case 'bool':
+      return $rand->intn(0, 1) === 1;
+    case 'string':
+      $s = '';
+      $n = $rand->intn(0, $this->max);
+      for ($i = 0; $i < $n; $i++) {
+        $s .= chr($rand->intn(32, 126));
+      }
+      return $s;
+    default:
+      $arr = [];
+      $n = $rand->intn(0, $this->max);
+      for ($i = 0; $i < $n; $i++) {
+        $arr[] = $this->elem->generate($rand);
+      }
+      return $arr;
+    }
+  }
+
+  /**
+   * Returns the simpler values to try instead of $v, the simplest go first.
+   * @param mixed $v
+   * @return mixed[]
+   */
+  public function shrink($v): array {
+    switch ($this->kind) {
+    case 'int':
+      $target = max($this->min, min($this->max, 0));
+      $v = (int)$v;
+      if ($v === $target) {
+        return [];
+      }
+      $out = [$target];
+      $half = $target + intdiv($v - $target, 2);
+      if ($half !== $target) {
+        $out[] = $half;
+      }
+      $next = $v > $target ? $v - 1 : $v + 1;
+      if ($next !== $half && $next !== $target) {
+        $out[] = $next;
+      }
+      return $out;
+    case 'bool':
+      return $v ? [false] : [];
+    case 'string':
+      $s = (string)$v;
+      $n = strlen($s);
+      if ($n === 0) {
+        return [];
+      }
+      $out = [''];
+      if ($n > 1) {
+        $out[] = substr($s, 0, intdiv($n, 2));
+      }
+      for ($i = 0; $i < $n; $i++) {
+        $out[] = substr($s, 0, $i) . substr($s, $i + 1);
+      }
+      return $out;
+    default:
+      $arr = (array)$v;
+      $n = count($arr);
+      if ($n === 0) {
+        return [];
+      }
+      $out = [[]];
+      if ($n > 1) {
+        $out[] = array_slice($arr, 0, intdiv($n, 2));
+      }
+      for ($i = 0; $i < $n; $i++) {
+        $smaller = $arr;
+        array_splice($smaller, $i, 1);
+        $out[] = $smaller;
+      }
+      for ($i = 0; $i < $n; $i++) {
+        foreach ($this->elem->shrink($arr[$i]) as $elem) {
+          $simpler = $arr;
+          $simpler[$i] = $elem;
+          $out[] = $simpler;
+        }
+      }
+      return $out;
+    }
+  }
+}
+
+class Runtime {
+  /** @var mixed */
+  private static $protocol = false;
+
+  /** @var ?string */
+  private static $expectedOutput = null;
+  /** @var ?string */
+  private static $expectedOutputRegex = null;
+  /** @var int */
+  private static $expectedOutputLine = 0;
+
+  /** @var int */
+  private static $startTime = 0;
+  /** @var int */
+  private static $startMemory = 0;
+  /** @var int */
+  private static $startPeakMemory = 0;
+
+  /** @var int */
+  public static $memoryLimit = 0;
+
+  /** @var string[] */
+  private static $methods = [];
+
+  /** @var bool */
+  public static $stopOnFailure = false;
+  /** @var bool */
+  private static $stopped = false;
+
+  /** @var int */
+  public static $propertySeed = 0;
+  /** @var int */
+  public static $propertyRuns = 100;
+  /** @var bool */
+  private static $quiet = false;
+  /** @var mixed[] */
+  private static $lastFailure = [];
+
+  /** @var bool[] */
+  private static $stubs = [];
+
+  /** @var string */
+  public static $snapshotRoot = '';
+  /** @var bool */
+  public static $updateSnapshots = false;
+  /** @var string */
+  private static $currentTest = '';
+  /** @var int */
+  private static $snapshotCounter = 0;
+
+  public static function open(string $filename) {
+    self::$protocol = fopen($filename, 'w');
+  }
+
+  /**
+   * Restricts the executed test methods to the comma-separated list.
+   * An empty list means that all methods should be executed.
+   */
+  public static function setFilter(string $methods) {
+    if ($methods !== '') {
+      self::$methods = explode(',', $methods);
+    }
+  }
+
+  public static function shouldRun(string $name): bool {
+    if (self::$stopped) {
+      return false;
+    }
+    return count(self::$methods) === 0 || in_array($name, self::$methods, true);
+  }
+
+  /** @param mixed[] $fields */
+  public static function write(array $fields) {
+    fwrite(self::$protocol, json_encode($fields) . "\n");
+  }
+
+  public static function startTest(string $name) {
+    self::$expectedOutput = null;
+    self::$expectedOutputRegex = null;
+    self::$stubs = [];
+    self::$currentTest = $name;
+    self::$snapshotCounter = 0;
+    self::write(['START', $name]);
+    ob_start();
+    self::$startMemory = memory_get_usage();
+    self::$startPeakMemory = memory_get_peak_usage();
+    self::$startTime = hrtime(true);
+  }
+
+  /**
+   * Reports a failed kphpunit TestCase assertion. The assertions outside
+   * of the test class are not rewritten to this runtime, so they
+   * throw the kphpunit exception instead.
+   */
+  public static function kphpunitFailed(\Exception $e) {
+    self::write(['ASSERT_KPHPUNIT_FAILED', self::encode(get_class($e)), self::encode($e->getMessage()), '', 0]);
+  }
+
+  public static function finishTest(bool $passed): bool {
+    $passed = self::finish($passed);
+    if (!$passed && self::$stopOnFailure) {
+      self::$stopped = true;
+    }
+    return $passed;
+  }
+
+  private static function finish(bool $passed): bool {
+    $elapsed = hrtime(true) - self::$startTime;
+    $usage = memory_get_usage() - self::$startMemory;
+    $peak = 0;
+    if (memory_get_peak_usage() > self::$startPeakMemory) {
+      $peak = memory_get_peak_usage() - self::$startMemory;
+    }
+    $output = (string)ob_get_clean();
+    self::write(['OUTPUT', self::encode($output)]);
+    self::write(['TIME', $elapsed]);
+    self::write(['MEMORY', $usage, $peak]);
+    if (!$passed) {
+      return false;
+    }
+    if (self::$memoryLimit > 0 && $peak > self::$memoryLimit) {
+      self::write(['ASSERT_MEMORY_LIMIT_FAILED', self::encode(self::$memoryLimit), self::encode($peak), '', 0]);
+      return false;
+    }
+    if (self::$expectedOutput !== null) {
+      if ($output !== self::$expectedOutput) {
+        self::write(['ASSERT_OUTPUT_FAILED', self::encode(self::$expectedOutput), self::encode($output), '', self::$expectedOutputLine]);
+        return false;
+      }
+      self::write(['ASSERT_OK']);
+    }
+    if (self::$expectedOutputRegex !== null) {
+      if (!preg_match(self::$expectedOutputRegex, $output)) {
+        self::write(['ASSERT_OUTPUT_REGEX_FAILED', self::encode(self::$expectedOutputRegex), self::encode($output), '', self::$expectedOutputLine]);
+        return false;
+      }
+      self::write(['ASSERT_OK']);
+    }
+    return true;
+  }
+
+  public static function expectOutputString(int $line, string $expected) {
+    self::$expectedOutput = $expected;
+    self::$expectedOutputLine = $line;
+  }
+
+  public static function expectOutputRegex(int $line, string $regex) {
+    self::$expectedOutputRegex = $regex;
+    self::$expectedOutputLine = $line;
+  }
+
+  /**
+   * Routes the calls of the target through its @ktest-stub declaration
+   * until the end of the current test.
+   */
+  public static function stub(int $line, string $target) {
+    self::$stubs[strtolower(ltrim($target, '\\'))] = true;
+  }
+
+  public static function isStubbed(string $target): bool {
+    return isset(self::$stubs[$target]);
+  }
+
+  public static function freezeTime(int $line, ?int $timestamp = null) {
+    Clock::freeze($timestamp);
+  }
+
+  /** @param int|float $seconds */
+  public static function advanceTime(int $line, $seconds) {
+    Clock::advance((float)$seconds);
+  }
+
+  public static function seedRandom(int $line, int $seed) {
+    Clock::seed($seed);
+  }
+
+  /** @param mixed $cond */
+  public static function assertTrue(int $line, $cond, string $message = '') {
+    self::check($cond === true, 'ASSERT_BOOL_FAILED', true, $cond, $message, $line);
+  }
+
+  /** @param mixed $cond */
+  public static function assertFalse(int $line, $cond, string $message = '') {
+    self::check($cond === false, 'ASSERT_BOOL_FAILED', false, $cond, $message, $line);
+  }
+
+  /**
+   * @param mixed $expected
+   * @param mixed $actual
+   */
+  public static function assertSame(int $line, $expected, $actual, string $message = '') {
+    self::check($expected === $actual, 'ASSERT_SAME_FAILED', $expected, $actual, $message, $line);
+  }
+
+  /**
+   * @param mixed $expected
+   * @param mixed $actual
+   */
+  public static function assertNotSame(int $line, $expected, $actual, string $message = '') {
+    self::check($expected !== $actual, 'ASSERT_NOT_SAME_FAILED', $expected, $actual, $message, $line);
+  }
+
+  /**
+   * @param mixed $expected
+   * @param mixed $actual
+   */
+  public static function assertEquals(int $line, $expected, $actual, string $message = '') {
+    self::check($expected == $actual, 'ASSERT_EQUALS_FAILED', $expected, $actual, $message, $line);
+  }
+
+  /**
+   * @param mixed $expected
+   * @param mixed $actual
+   */
+  public static function assertNotEquals(int $line, $expected, $actual, string $message = '') {
+    self::check($expected != $actual, 'ASSERT_NOT_EQUALS_FAILED', $expected, $actual, $message, $line);
+  }
+
+  /**
+   * Compares the exported value with the snapshot file.
+   * $dir is the test class snapshots dir relative to the $snapshotRoot.
+   *
+   * @param mixed $value
+   */
+  public static function assertMatchesSnapshot(int $line, string $dir, $value) {
+    self::$snapshotCounter++;
+    $name = $dir . '/' . self::$currentTest . '.' . self::$snapshotCounter . '.txt';
+    $filename = self::$snapshotRoot . '/' . $name;
+    $actual = self::export($value);
+    $exists = file_exists($filename);
+    $expected = $exists ? rtrim((string)file_get_contents($filename), "\n") : '';
+    if ($exists && $expected === $actual) {
+      self::check(true, '', null, null, '', $line);
+      return;
+    }
+    if (self::$updateSnapshots) {
+      if (!is_dir(dirname($filename))) {
+        mkdir(dirname($filename), 0777, true);
+      }
+      file_put_contents($filename, $actual . "\n");
+      self::write(['SNAPSHOT_UPDATED', $name]);
+      self::check(true, '', null, null, '', $line);
+      return;
+    }
+    // The snapshot name is reported instead of the message.
+    if ($exists) {
+      self::check(false, 'ASSERT_SNAPSHOT_FAILED', $expected, $actual, $name, $line);
+    }
+    self::check(false, 'ASSERT_SNAPSHOT_MISSING_FAILED', '', $actual, $name, $line);
+  }
+
+  /**
+   * @param Gen[] $gens
+   * @param callable(mixed[]):void $property
+   */
+  public static function forAll(int $line, array $gens, callable $property) {
+    $rand = new Random(self::$propertySeed);
+    for ($run = 1; $run <= self::$propertyRuns; $run++) {
+      $args = [];
+      foreach ($gens as $gen) {
+        $args[] = $gen->generate($rand);
+      }
+      $failure = self::runProperty($line, $property, $args);
+      if (count($failure) === 0) {
+        continue;
+      }
+
+      $shrinks = 0;
+      $shrunk = true;
+      while ($shrunk && $shrinks < 1000) {
+        $shrunk = false;
+        foreach ($gens as $i => $gen) {
+          foreach ($gen->shrink($args[$i]) as $candidate) {
+            $simpler = $args;
+            $simpler[$i] = $candidate;
+            $simplerFailure = self::runProperty($line, $property, $simpler);
+            if (count($simplerFailure) !== 0) {
+              $args = $simpler;
+              $failure = $simplerFailure;
+              $shrinks++;
+              $shrunk = true;
+              break;
+            }
+          }
+          if ($shrunk) {
+            break;
+          }
+        }
+      }
+
+      self::write(['PROPERTY', self::$propertySeed, $run, $shrinks, self::encode($args)]);
+      self::write($failure);
+      throw new AssertionFailedException('property failed');
+    }
+    self::write(['ASSERT_OK']);
+  }
+
+  /**
+   * Runs the property and returns its failure op, if any.
+   * @param callable(mixed[]):void $property
+   * @param mixed[] $args
+   * @return mixed[]
+   */
+  private static function runProperty(int $line, callable $property, array $args): array {
+    self::$quiet = true;
+    self::$lastFailure = [];
+    try {
+      $property($args);
+    } catch (AssertionFailedException $e) {
+      self::$quiet = false;
+      return self::$lastFailure;
+    } catch (\Exception $e) {
+      self::$quiet = false;
+      return ['ASSERT_EXCEPTION_FAILED', self::encode(get_class($e)), self::encode($e->getMessage()), '', $line];
+    }
+    self::$quiet = false;
+    return [];
+  }
+
+  /**
+   * @param mixed $expected
+   * @param mixed $actual
+   */
+  private static function check(bool $ok, string $op, $expected, $actual, string $message, int $line) {
+    if ($ok) {
+      if (!self::$quiet) {
+        self::write(['ASSERT_OK']);
+      }
+      return;
+    }
+    $failure = [$op, self::encode($expected), self::encode($actual), $message, $line];
+    if (self::$quiet) {
+      self::$lastFailure = $failure;
+    } else {
+      self::write($failure);
+    }
+    throw new AssertionFailedException($message);
+  }
+
+  /**
+   * Renders a value the way SebastianBergmann\Exporter\Exporter::export does,
+   * see exportValue.
+   *
+   * @param mixed $v
+   */
+  public static function export($v): string {
+    $numArrays = 0;
+    return self::exportValue($v, 0, $numArrays);
+  }
+
+  /** @param mixed $v */
+  private static function exportValue($v, int $indentation, int &$numArrays): string {
+    if (is_null($v)) {
+      return 'null';
+    }
+    if (is_bool($v)) {
+      return $v ? 'true' : 'false';
+    }
+    if (is_int($v)) {
+      return (string)$v;
+    }
+    if (is_float($v)) {
+      $repr = var_export($v, true);
+      if (trim($repr, '-0123456789') === '') {
+        $repr .= '.0';
+      }
+      return $repr;
+    }
+    if (is_string($v)) {
+      if (preg_match('/[^\x09-\x0d\x1b\x20-\xff]/', $v)) {
+        return 'Binary String: 0x' . bin2hex($v);
+      }
+      $v = str_replace(["\r\n", "\n\r", "\r", "\n"], ['\r\n<lf>', '\n\r<lf>', '\r<lf>', '\n<lf>'], $v);
+      return "'" . str_replace('<lf>', "\n", $v) . "'";
+    }
+    $id = $numArrays;
+    $numArrays++;
+    if (count($v) === 0) {
+      return 'Array &' . $id . ' ()';
+    }
+    $whitespace = str_repeat(' ', 4 * $indentation);
+    $out = 'Array &' . $id . ' (' . "\n";
+    foreach ($v as $key => $elem) {
+      $out .= $whitespace . '    ' . self::exportValue($key, $indentation, $numArrays);
+      $out .= ' => ' . self::exportValue($elem, $indentation + 1, $numArrays) . "\n";
+    }
+    return $out . $whitespace . ')';
+  }
+
+  /**
+   * @param mixed $v
+   * @return mixed
+   */
+  public static function encode($v) {
+    if (is_null($v)) {
+      return ['null'];
+    }
+    if (is_bool($v)) {
+      return ['bool', $v ? 'true' : 'false'];
+    }
+    if (is_int($v)) {
+      return ['int', (string)$v];
+    }
+    if (is_float($v)) {
+      return ['float', var_export($v, true)];
+    }
+    if (is_string($v)) {
+      if (!preg_match('//u', $v)) {
+        return ['bytes', base64_encode($v)];
+      }
+      return ['string', $v];
+    }
+    $items = [];
+    foreach ($v as $key => $elem) {
+      $items[] = [self::encode($key), self::encode($elem)];
+    }
+    return ['array', $items];
+  }
+}
+`
@@ -0,0 +1,11 @@
+//go:build !unix
+// +build !unix
+
+package phpunit
+
+import "os"
+
+// maxRSS returns 0 as the process maximum RSS is not reported on this platform.
+func maxRSS(state *os.ProcessState) int64 {
+	return 0
+}
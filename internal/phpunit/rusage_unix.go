@@ -0,0 +1,23 @@
+//go:build unix
+// +build unix
+
+package phpunit
+
+import (
+	"os"
+	"runtime"
+	"syscall"
+)
+
+// maxRSS returns the process maximum resident set size in bytes.
+func maxRSS(state *os.ProcessState) int64 {
+	usage, ok := state.SysUsage().(*syscall.Rusage)
+	if !ok {
+		return 0
+	}
+	// Darwin reports ru_maxrss in bytes, other systems use kilobytes.
+	if runtime.GOOS == "darwin" {
+		return int64(usage.Maxrss)
+	}
+	return int64(usage.Maxrss) * 1024
+}
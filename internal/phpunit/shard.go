@@ -0,0 +1,110 @@
+package phpunit
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+)
+
+// assignShards distributes the weighted items over n shards.
+// It returns the shard index for every item.
+//
+// The heaviest items are assigned first, every item goes to the least
+// loaded shard. The ties are resolved by the indexes, so the result
+// only depends on the weights order, not on the machine it's computed on.
+func assignShards(weights []float64, n int) []int {
+	order := make([]int, len(weights))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return weights[order[i]] > weights[order[j]]
+	})
+
+	loads := make([]float64, n)
+	shards := make([]int, len(weights))
+	for _, i := range order {
+		shard := 0
+		for j := 1; j < n; j++ {
+			if loads[j] < loads[shard] {
+				shard = j
+			}
+		}
+		shards[i] = shard
+		loads[shard] += weights[i]
+	}
+	return shards
+}
+
+// loadClassTimings reads the per-class cost estimations from the JSON report
+// written by WriteJSONReport. The cost is the class compile and run time.
+func loadClassTimings(filename string) (map[string]float64, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var report jsonReport
+	if err := json.Unmarshal(data, &report); err != nil {
+		return nil, fmt.Errorf("decode %s: %v", filename, err)
+	}
+	timings := make(map[string]float64, len(report.Classes))
+	for _, c := range report.Classes {
+		timings[c.Class] = float64(c.CompileTimeMs + c.RunTimeMs)
+	}
+	return timings, nil
+}
+
+func (r *runner) stepSelectShard() error {
+	if r.conf.ShardTotal == 0 {
+		return nil
+	}
+	if r.conf.ShardIndex < 0 || r.conf.ShardIndex >= r.conf.ShardTotal {
+		return fmt.Errorf("shard index %d is out of [0, %d) range", r.conf.ShardIndex, r.conf.ShardTotal)
+	}
+
+	// Without the timings, all classes have the same weight.
+	// The classes that are missing in the timings file are
+	// assumed to have an average cost.
+	weights := make([]float64, len(r.testFiles))
+	for i := range weights {
+		weights[i] = 1
+	}
+	if r.conf.ShardTimingsFile != "" {
+		timings, err := loadClassTimings(r.conf.ShardTimingsFile)
+		if err != nil {
+			return err
+		}
+		total := 0.0
+		known := 0
+		for _, f := range r.testFiles {
+			if t, ok := timings[f.info.ClassName]; ok {
+				total += t
+				known++
+			}
+		}
+		if known != 0 {
+			average := total / float64(known)
+			for i, f := range r.testFiles {
+				if t, ok := timings[f.info.ClassName]; ok {
+					weights[i] = t
+				} else {
+					weights[i] = average
+				}
+			}
+		}
+	}
+
+	shards := assignShards(weights, r.conf.ShardTotal)
+	selected := r.testFiles[:0]
+	for i, f := range r.testFiles {
+		if shards[i] == r.conf.ShardIndex {
+			selected = append(selected, f)
+		}
+	}
+	fmt.Fprintf(r.conf.Output, "Shard %d of %d: %d of %d test classes\n\n",
+		r.conf.ShardIndex, r.conf.ShardTotal, len(selected), len(r.testFiles))
+	r.testFiles = selected
+
+	return nil
+}
@@ -0,0 +1,26 @@
+package phpunit
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestAssignShards(t *testing.T) {
+	tests := []struct {
+		weights []float64
+		n       int
+		want    []int
+	}{
+		{[]float64{1, 1, 1, 1, 1}, 2, []int{0, 1, 0, 1, 0}},
+		{[]float64{1, 1, 1}, 5, []int{0, 1, 2}},
+		{[]float64{10, 1, 1, 8, 2}, 2, []int{0, 0, 1, 1, 1}},
+		{[]float64{5, 5, 4, 3, 3}, 3, []int{0, 1, 2, 2, 0}},
+	}
+
+	for _, test := range tests {
+		have := assignShards(test.weights, test.n)
+		if !reflect.DeepEqual(have, test.want) {
+			t.Errorf("assignShards(%v, %d):\nhave: %v\nwant: %v", test.weights, test.n, have, test.want)
+		}
+	}
+}
@@ -0,0 +1,21 @@
+package phpunit
+
+import (
+	"path/filepath"
+)
+
+// snapshotDir returns the test class snapshots dir relative to the project root.
+//
+// The snapshots are stored next to the test file:
+//
+//	tests/FooTest.php
+//	tests/__snapshots__/FooTest/testBar.1.txt
+//	tests/__snapshots__/FooTest/testBar.2.txt
+//
+// The number is the snapshot assertion order inside the test method.
+// A snapshot file contains the value exported by the SebastianBergmann Exporter,
+// so the same files can be checked by the PHPUnit runs (see php/KTest/SnapshotAssertions.php).
+// The snapshots are compared by KTest\Runtime::assertMatchesSnapshot.
+func snapshotDir(testFile, className string) string {
+	return filepath.ToSlash(filepath.Join(filepath.Dir(testFile), "__snapshots__", className))
+}
@@ -0,0 +1,58 @@
+package phpunit
+
+import (
+	"testing"
+)
+
+func TestSnapshotDir(t *testing.T) {
+	have := snapshotDir("tests/Unit/FooTest.php", "FooTest")
+	if want := "tests/Unit/__snapshots__/FooTest"; have != want {
+		t.Errorf("snapshot dir mismatch:\nhave: %s\nwant: %s", have, want)
+	}
+}
+
+func TestParseSnapshotFailures(t *testing.T) {
+	f := &testFile{
+		fullName: "/project/tests/FooTest.php",
+		info:     &testParsedInfo{ClassName: "FooTest"},
+	}
+	protocol := `["START","testRender"]
+["ASSERT_OK"]
+["ASSERT_SNAPSHOT_FAILED",["string","'foo\\n\nbar'"],["string","10"],"tests/__snapshots__/FooTest/testRender.2.txt",12]
+["OUTPUT",["string",""]]
+["TIME",1000]
+["START","testMissing"]
+["ASSERT_SNAPSHOT_MISSING_FAILED",["string",""],["string","10"],"tests/__snapshots__/FooTest/testMissing.1.txt",20]
+["OUTPUT",["string",""]]
+["TIME",1000]
+["START","testUpdated"]
+["SNAPSHOT_UPDATED","tests/__snapshots__/FooTest/testUpdated.1.txt"]
+["ASSERT_OK"]
+["OUTPUT",["string",""]]
+["TIME",1000]
+["FINISHED"]
+`
+
+	res, err := parseTestOutput(f, []byte(protocol))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.asserts != 4 || len(res.failures) != 2 {
+		t.Fatalf("unexpected result: asserts=%d failures=%+v", res.asserts, res.failures)
+	}
+
+	mismatch := res.failures[0]
+	if mismatch.Line != 12 || mismatch.Message != "" || mismatch.Reason != "Failed asserting that 10 matches snapshot tests/__snapshots__/FooTest/testRender.2.txt" {
+		t.Errorf("unexpected mismatch failure: %+v", mismatch)
+	}
+	if diff := mismatch.Diff; diff == nil || diff.Expected != "'foo\\n\nbar'" || diff.Actual != "10" {
+		t.Errorf("unexpected diff: %+v", diff)
+	}
+	missing := res.failures[1]
+	if missing.Line != 20 || missing.Reason != "Failed asserting that snapshot tests/__snapshots__/FooTest/testMissing.1.txt exists" {
+		t.Errorf("unexpected missing failure: %+v", missing)
+	}
+	if len(res.updatedSnapshots) != 1 || res.updatedSnapshots[0] != "tests/__snapshots__/FooTest/testUpdated.1.txt" {
+		t.Errorf("unexpected updated snapshots: %q", res.updatedSnapshots)
+	}
+}
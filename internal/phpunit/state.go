@@ -0,0 +1,191 @@
+package phpunit
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/quasilyte/ktest/internal/fileutil"
+)
+
+// runState is the persisted outcome of the previous runs.
+// It's used to re-run only the failed tests, see RunConfig.RerunFailed.
+type runState struct {
+	// Failed lists the test classes with failed tests.
+	Failed []failedClassState `json:"failed"`
+}
+
+type failedClassState struct {
+	// File is a test file path relative to the project root.
+	File string `json:"file"`
+
+	// Methods lists the failed methods.
+	// If empty, the whole class failed, like with a build error.
+	Methods []string `json:"methods,omitempty"`
+}
+
+// DefaultStateFile returns the state file location for the project.
+// The state files are kept inside the user cache dir, one per project root.
+func DefaultStateFile(projectRoot string) (string, error) {
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	root, err := filepath.Abs(projectRoot)
+	if err != nil {
+		return "", err
+	}
+	hash := sha1.Sum([]byte(root))
+	return filepath.Join(cacheDir, "ktest", hex.EncodeToString(hash[:8]), "last-run.json"), nil
+}
+
+func loadRunState(filename string) (*runState, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var state runState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("decode %s: %v", filename, err)
+	}
+	return &state, nil
+}
+
+// update replaces the state of the executed classes with their new results.
+// The classes that were not executed keep their previous state.
+func (state *runState) update(projectRoot string, classes []*ClassResult) {
+	executed := make(map[string]bool, len(classes))
+	var failed []failedClassState
+	for _, class := range classes {
+		file := relativeTestFile(projectRoot, class.File)
+		executed[file] = true
+		switch {
+		case class.BuildError != nil, class.Err != nil:
+			failed = append(failed, failedClassState{File: file})
+		case len(class.Failures) != 0:
+			classState := failedClassState{File: file}
+			for _, failure := range class.Failures {
+				method := strings.TrimPrefix(failure.Name, class.ClassName+"::")
+				if !containsString(classState.Methods, method) {
+					classState.Methods = append(classState.Methods, method)
+				}
+			}
+			failed = append(failed, classState)
+		}
+	}
+
+	for _, classState := range state.Failed {
+		if !executed[classState.File] {
+			failed = append(failed, classState)
+		}
+	}
+	sort.SliceStable(failed, func(i, j int) bool {
+		return failed[i].File < failed[j].File
+	})
+	state.Failed = failed
+}
+
+func (state *runState) findClass(file string) *failedClassState {
+	for i := range state.Failed {
+		if state.Failed[i].File == file {
+			return &state.Failed[i]
+		}
+	}
+	return nil
+}
+
+func relativeTestFile(projectRoot, filename string) string {
+	rel, err := filepath.Rel(projectRoot, filename)
+	if err != nil {
+		return filename
+	}
+	return filepath.ToSlash(rel)
+}
+
+func (r *runner) stepFilterFailedTestFiles() error {
+	if !r.conf.RerunFailed {
+		return nil
+	}
+	if r.conf.StateFile == "" {
+		return fmt.Errorf("re-running failed tests requires a state file")
+	}
+
+	state, err := loadRunState(r.conf.StateFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return fmt.Errorf("no previous run state found at %s", r.conf.StateFile)
+		}
+		return err
+	}
+	r.prevState = state
+
+	filtered := r.testFiles[:0]
+	for _, f := range r.testFiles {
+		if state.findClass(relativeTestFile(r.conf.ProjectRoot, f.fullName)) != nil {
+			filtered = append(filtered, f)
+		}
+	}
+	r.testFiles = filtered
+	if len(r.testFiles) == 0 {
+		fmt.Fprintf(r.conf.Output, "No failed tests to re-run\n\n")
+	}
+
+	return nil
+}
+
+func (r *runner) stepFilterFailedTestMethods() error {
+	if r.prevState == nil {
+		return nil
+	}
+
+	for _, f := range r.testFiles {
+		classState := r.prevState.findClass(relativeTestFile(r.conf.ProjectRoot, f.fullName))
+		if len(classState.Methods) == 0 {
+			continue
+		}
+		var methods []string
+		for _, method := range f.info.TestMethods {
+			if containsString(classState.Methods, method) {
+				methods = append(methods, method)
+			}
+		}
+		// The failed methods could be renamed or removed since then,
+		// the whole class is executed in this case.
+		if len(methods) != 0 {
+			f.info.TestMethods = methods
+		}
+	}
+
+	return nil
+}
+
+func (r *runner) stepSaveState() error {
+	if r.conf.StateFile == "" {
+		return nil
+	}
+
+	state := r.prevState
+	if state == nil {
+		var err error
+		state, err = loadRunState(r.conf.StateFile)
+		if err != nil {
+			if !os.IsNotExist(err) {
+				r.logf("ignoring the previous run state: %v", err)
+			}
+			state = &runState{}
+		}
+	}
+	state.update(r.conf.ProjectRoot, r.result.Classes)
+
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	return fileutil.WriteFile(r.conf.StateFile, data)
+}
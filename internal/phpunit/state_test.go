@@ -0,0 +1,45 @@
+package phpunit
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestRunStateUpdate(t *testing.T) {
+	state := &runState{
+		Failed: []failedClassState{
+			{File: "tests/BarTest.php", Methods: []string{"testX"}},
+			{File: "tests/FooTest.php", Methods: []string{"testA", "testB"}},
+		},
+	}
+
+	state.update("/project/", []*ClassResult{
+		{
+			File:      "/project/tests/FooTest.php",
+			ClassName: "FooTest",
+			Failures: []TestFailure{
+				{Name: "FooTest::testB"},
+				{Name: "FooTest::testB"},
+			},
+		},
+		{
+			File:      "/project/tests/sub/BazTest.php",
+			ClassName: "BazTest",
+			Err:       errors.New("run error"),
+		},
+		{
+			File:      "/project/tests/OkTest.php",
+			ClassName: "OkTest",
+		},
+	})
+
+	want := []failedClassState{
+		{File: "tests/BarTest.php", Methods: []string{"testX"}},
+		{File: "tests/FooTest.php", Methods: []string{"testB"}},
+		{File: "tests/sub/BazTest.php"},
+	}
+	if !reflect.DeepEqual(state.Failed, want) {
+		t.Errorf("state mismatch:\nhave: %+v\nwant: %+v", state.Failed, want)
+	}
+}
@@ -0,0 +1,661 @@
+package phpunit
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/VKCOM/php-parser/pkg/ast"
+	"github.com/VKCOM/php-parser/pkg/visitor"
+	"github.com/VKCOM/php-parser/pkg/visitor/traverser"
+	"github.com/quasilyte/ktest/internal/fileutil"
+	"github.com/quasilyte/ktest/internal/phpsyntax"
+)
+
+// stubDecl is a function or a static method annotated with @ktest-stub.
+//
+// The calls of the stub target inside the sources are rewritten to the shim
+// function that calls the stub when it's enabled for the current test
+// (see KTest\Runtime::stub) and the target itself otherwise.
+type stubDecl struct {
+	// target is a stubbed function or "Class::method"
+	// fully qualified name without a leading slash.
+	target string
+
+	// callee is a fully qualified stub name, like \Tests\Stubs::time.
+	callee string
+
+	params []stubParam
+
+	void bool
+}
+
+// stubParam is a stub declaration parameter.
+//
+// The type and the default value are copied into the shim signature,
+// the class and constant names inside them are fully qualified, so they
+// resolve the same way inside the KTest\Stub namespace.
+type stubParam struct {
+	name         string
+	typ          string
+	byRef        bool
+	variadic     bool
+	defaultValue string
+}
+
+// key is a target lookup key, the names are case-insensitive in PHP.
+func (stub *stubDecl) key() string {
+	return strings.ToLower(stub.target)
+}
+
+// shimName returns the shim function name inside the KTest\Stub namespace.
+//
+// The underscores are doubled, so the encoding is reversible and
+// the different targets, like a_b\c and a\b_c, get different shims.
+func (stub *stubDecl) shimName() string {
+	return strings.NewReplacer("_", "__", `\`, "_n", "::", "_m").Replace(stub.key())
+}
+
+func (stub *stubDecl) shimParams() string {
+	parts := make([]string, len(stub.params))
+	for i, p := range stub.params {
+		s := "$" + p.name
+		if p.variadic {
+			s = "..." + s
+		}
+		if p.byRef {
+			s = "&" + s
+		}
+		if p.typ != "" {
+			s = p.typ + " " + s
+		}
+		if p.defaultValue != "" {
+			s += " = " + p.defaultValue
+		}
+		parts[i] = s
+	}
+	return strings.Join(parts, ", ")
+}
+
+func (stub *stubDecl) shimArgs() string {
+	parts := make([]string, len(stub.params))
+	for i, p := range stub.params {
+		parts[i] = "$" + p.name
+		if p.variadic {
+			parts[i] = "..." + parts[i]
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+func (r *runner) stubShimFilename() string {
+	return filepath.Join(r.buildDir, "ktest", "stubs.php")
+}
+
+func (r *runner) stepParseStubs() error {
+	if r.conf.StubsFile == "" {
+		return nil
+	}
+
+	src, err := ioutil.ReadFile(r.conf.StubsFile)
+	if err != nil {
+		return err
+	}
+	rootNode, parserErrors := phpsyntax.Parse(src, r.phpVersion)
+	if len(parserErrors) != 0 {
+		return fmt.Errorf("%s: parse error: %v (PHP version %d.%d)",
+			r.conf.StubsFile, parserErrors[0], r.phpVersion.Major, r.phpVersion.Minor)
+	}
+	v := newStubDeclVisitor(src)
+	traverser.NewTraverser(v).Traverse(rootNode)
+	if v.err != nil {
+		return fmt.Errorf("%s: %v", r.conf.StubsFile, v.err)
+	}
+
+	r.stubs = make(map[string]*stubDecl, len(v.out))
+	for _, stub := range v.out {
+		if _, ok := r.stubs[stub.key()]; ok {
+			return fmt.Errorf("%s: %s is stubbed more than once", r.conf.StubsFile, stub.target)
+		}
+		r.stubs[stub.key()] = stub
+		r.debugf("stub: %s => %s", stub.target, stub.callee)
+	}
+
+	return nil
+}
+
+// stubCall is a $this->stub() call inside a test file.
+type stubCall struct {
+	target string
+	line   int
+}
+
+// stepCheckStubCalls makes sure that every literal $this->stub() target
+// has a declaration, a typo would silently leave the target unstubbed.
+func (r *runner) stepCheckStubCalls() error {
+	for _, f := range r.testFiles {
+		for _, call := range f.info.stubCalls {
+			key := strings.ToLower(strings.TrimLeft(call.target, `\`))
+			if _, ok := r.stubs[key]; ok {
+				continue
+			}
+			if r.conf.StubsFile == "" {
+				return fmt.Errorf("%s:%d: can't stub %s: no stubs file", f.fullName, call.line, call.target)
+			}
+			return fmt.Errorf("%s:%d: can't stub %s: no @ktest-stub declaration in %s",
+				f.fullName, call.line, call.target, r.conf.StubsFile)
+		}
+	}
+	return nil
+}
+
+func (r *runner) writeStubShims() error {
+	type shim struct {
+		Name   string
+		Key    string
+		Callee string
+		Params string
+		Args   string
+		Void   bool
+
+		Fallback string
+	}
+	shims := make([]shim, 0, len(r.stubs))
+	for _, stub := range r.stubs {
+		shims = append(shims, shim{
+			Name:   stub.shimName(),
+			Key:    escapePHPString(stub.key()),
+			Callee: stub.callee,
+			Params: stub.shimParams(),
+			Args:   stub.shimArgs(),
+			Void:   stub.void,
+
+			Fallback: `\` + stub.target,
+		})
+		// The stubbed time and random built-ins still obey the clock control API.
+		if replacement, ok := clockFunctions[stub.key()]; ok && r.usesClock {
+			shims[len(shims)-1].Fallback = replacement
+		}
+	}
+	sort.Slice(shims, func(i, j int) bool {
+		return shims[i].Key < shims[j].Key
+	})
+
+	var generated bytes.Buffer
+	if err := stubShimsTemplate.Execute(&generated, shims); err != nil {
+		return err
+	}
+	return fileutil.WriteFile(r.stubShimFilename(), generated.Bytes())
+}
+
+var stubShimsTemplate = template.Must(template.New("stub_shims").Parse(`<?php
+
+namespace KTest\Stub;
+{{range .}}
+function {{.Name}}({{.Params}}) {
+  if (\KTest\Runtime::isStubbed('{{.Key}}')) {
+    {{- if .Void}}
+    {{.Callee}}({{.Args}});
+    return;
+    {{- else}}
+    return {{.Callee}}({{.Args}});
+    {{- end}}
+  }
+  {{if not .Void}}return {{end}}{{.Fallback}}({{.Args}});
+}
+{{end}}`))
+
+var stubTagRegexp = regexp.MustCompile(`@ktest-stub\s+\\?(\S+)`)
+
+// stubDeclVisitor collects the @ktest-stub declarations.
+type stubDeclVisitor struct {
+	visitor.Null
+
+	src []byte
+
+	namespace     string
+	uses          map[string]string
+	constUses     map[string]string
+	currentClass  string
+	currentParent string
+
+	out []*stubDecl
+	err error
+}
+
+func newStubDeclVisitor(src []byte) *stubDeclVisitor {
+	return &stubDeclVisitor{
+		src:       src,
+		uses:      make(map[string]string),
+		constUses: make(map[string]string),
+	}
+}
+
+func (v *stubDeclVisitor) StmtNamespace(n *ast.StmtNamespace) {
+	v.namespace = ""
+	v.uses = make(map[string]string)
+	v.constUses = make(map[string]string)
+	if name, ok := n.Name.(*ast.Name); ok {
+		v.namespace = astNameToString(name)
+	}
+}
+
+func (v *stubDeclVisitor) StmtUse(n *ast.StmtUseList) {
+	switch useListKind(n) {
+	case "":
+		addUseAliases(v.uses, n)
+	case "const":
+		addUseAliases(v.constUses, n)
+	}
+}
+
+func (v *stubDeclVisitor) StmtClass(n *ast.StmtClass) {
+	v.currentClass = ""
+	v.currentParent = ""
+	if ident, ok := n.Name.(*ast.Identifier); ok {
+		v.currentClass = v.qualify(string(ident.Value))
+	}
+	if n.Extends != nil {
+		v.currentParent = strings.TrimPrefix(v.className(n.Extends), `\`)
+	}
+}
+
+func (v *stubDeclVisitor) StmtFunction(n *ast.StmtFunction) {
+	ident, ok := n.Name.(*ast.Identifier)
+	if !ok {
+		return
+	}
+	v.addStub(n.Position.StartPos, `\`+v.qualify(string(ident.Value)), n.Params, n.ReturnType)
+}
+
+func (v *stubDeclVisitor) StmtClassMethod(n *ast.StmtClassMethod) {
+	ident, ok := n.Name.(*ast.Identifier)
+	if !ok || v.currentClass == "" {
+		return
+	}
+	callee := `\` + v.currentClass + "::" + string(ident.Value)
+	if !v.addStub(n.Position.StartPos, callee, n.Params, n.ReturnType) {
+		return
+	}
+	static := false
+	for _, m := range n.Modifiers {
+		if m, ok := m.(*ast.Identifier); ok && strings.EqualFold(string(m.Value), "static") {
+			static = true
+		}
+	}
+	if !static && v.err == nil {
+		v.err = fmt.Errorf("%s: @ktest-stub methods should be static", callee)
+	}
+}
+
+// addStub records a declaration if it's annotated with @ktest-stub.
+func (v *stubDeclVisitor) addStub(declStart int, callee string, params []ast.Vertex, returnType ast.Vertex) bool {
+	doc := v.phpdoc(declStart)
+	m := stubTagRegexp.FindStringSubmatch(doc)
+	if m == nil {
+		return false
+	}
+
+	stub := &stubDecl{
+		target: m[1],
+		callee: callee,
+		void:   strings.Contains(doc, "@return void"),
+	}
+	if name, ok := returnType.(*ast.Name); ok && strings.EqualFold(astNameToString(name), "void") {
+		stub.void = true
+	}
+	for _, p := range params {
+		p := p.(*ast.Parameter)
+		name := string(p.Var.(*ast.ExprVariable).Name.(*ast.Identifier).Value)
+		param := stubParam{
+			name:     strings.TrimPrefix(name, "$"),
+			byRef:    p.AmpersandTkn != nil,
+			variadic: p.VariadicTkn != nil,
+		}
+		if p.Type != nil {
+			param.typ = v.typeString(p.Type)
+		}
+		if p.DefaultValue != nil {
+			param.defaultValue = v.qualifiedSource(p.DefaultValue)
+		}
+		stub.params = append(stub.params, param)
+	}
+	v.out = append(v.out, stub)
+	return true
+}
+
+func (v *stubDeclVisitor) phpdoc(declStart int) string {
+	before := bytes.TrimRight(v.src[:declStart], " \t\r\n")
+	if !bytes.HasSuffix(before, []byte("*/")) {
+		return ""
+	}
+	start := bytes.LastIndex(before, []byte("/**"))
+	if start == -1 {
+		return ""
+	}
+	return string(before[start:])
+}
+
+// typeString returns the type declaration with the fully qualified class names.
+func (v *stubDeclVisitor) typeString(typ ast.Vertex) string {
+	switch typ := typ.(type) {
+	case *ast.Nullable:
+		return "?" + v.typeString(typ.Expr)
+	case *ast.Union:
+		parts := make([]string, len(typ.Types))
+		for i, t := range typ.Types {
+			parts[i] = v.typeString(t)
+		}
+		return strings.Join(parts, "|")
+	case *ast.Name, *ast.NameFullyQualified, *ast.NameRelative:
+		return v.className(typ)
+	default:
+		return v.source(typ)
+	}
+}
+
+// qualifiedSource returns the default value source code with
+// the fully qualified class and constant names.
+func (v *stubDeclVisitor) qualifiedSource(n ast.Vertex) string {
+	names := &stubNamesVisitor{decl: v}
+	traverser.NewTraverser(names).Traverse(n)
+	pos := n.GetPosition()
+	for i := range names.edits {
+		names.edits[i].StartPos -= pos.StartPos
+		names.edits[i].EndPos -= pos.StartPos
+	}
+	if src := applyTextEdits(v.src[pos.StartPos:pos.EndPos], names.edits); src != nil {
+		return string(src)
+	}
+	return v.source(n)
+}
+
+// className returns the fully qualified class name with a leading slash.
+// The built-in type names and the names that can't be resolved are returned as is.
+func (v *stubDeclVisitor) className(n ast.Vertex) string {
+	switch name := n.(type) {
+	case *ast.NameFullyQualified:
+		return `\` + strings.Join(namePartsToStrings(name.Parts), `\`)
+	case *ast.NameRelative:
+		return `\` + v.qualify(strings.Join(namePartsToStrings(name.Parts), `\`))
+	case *ast.Name:
+		parts := namePartsToStrings(name.Parts)
+		if len(parts) == 1 {
+			lower := strings.ToLower(parts[0])
+			switch {
+			case lower == "self" && v.currentClass != "":
+				return `\` + v.currentClass
+			case lower == "parent" && v.currentParent != "":
+				return `\` + v.currentParent
+			case builtinTypeNames[lower] || lower == "self" || lower == "parent" || lower == "static":
+				return parts[0]
+			}
+		}
+		if fqn, ok := v.uses[strings.ToLower(parts[0])]; ok {
+			parts[0] = fqn
+			return `\` + strings.Join(parts, `\`)
+		}
+		return `\` + v.qualify(strings.Join(parts, `\`))
+	default:
+		return v.source(n)
+	}
+}
+
+// constName returns the constant name that resolves the same way
+// inside the shim namespace.
+func (v *stubDeclVisitor) constName(n ast.Vertex) string {
+	name, ok := n.(*ast.Name)
+	if !ok || len(name.Parts) != 1 {
+		return v.className(n)
+	}
+	s := astNameToString(name)
+	if fqn, ok := v.constUses[strings.ToLower(s)]; ok {
+		return `\` + fqn
+	}
+	// Unqualified constants fall back to the global namespace,
+	// the shim namespace has no constants of its own.
+	return s
+}
+
+func (v *stubDeclVisitor) source(n ast.Vertex) string {
+	pos := n.GetPosition()
+	return string(v.src[pos.StartPos:pos.EndPos])
+}
+
+func (v *stubDeclVisitor) qualify(name string) string {
+	if v.namespace == "" {
+		return name
+	}
+	return v.namespace + `\` + name
+}
+
+// builtinTypeNames are the lowercased type names that are not class names.
+var builtinTypeNames = map[string]bool{
+	"bool":     true,
+	"int":      true,
+	"float":    true,
+	"string":   true,
+	"array":    true,
+	"callable": true,
+	"iterable": true,
+	"object":   true,
+	"mixed":    true,
+	"void":     true,
+	"null":     true,
+	"false":    true,
+}
+
+// stubNamesVisitor qualifies the class and constant names
+// inside a stub parameter default value.
+type stubNamesVisitor struct {
+	visitor.Null
+
+	decl *stubDeclVisitor
+
+	edits []textEdit
+}
+
+func (v *stubNamesVisitor) ExprClassConstFetch(n *ast.ExprClassConstFetch) {
+	v.qualify(n.Class, v.decl.className(n.Class))
+}
+
+func (v *stubNamesVisitor) ExprNew(n *ast.ExprNew) {
+	v.qualify(n.Class, v.decl.className(n.Class))
+}
+
+func (v *stubNamesVisitor) ExprConstFetch(n *ast.ExprConstFetch) {
+	v.qualify(n.Const, v.decl.constName(n.Const))
+}
+
+func (v *stubNamesVisitor) qualify(n ast.Vertex, name string) {
+	pos := n.GetPosition()
+	if name == string(v.decl.src[pos.StartPos:pos.EndPos]) {
+		return
+	}
+	v.edits = append(v.edits, textEdit{
+		StartPos:    pos.StartPos,
+		EndPos:      pos.EndPos,
+		Replacement: name,
+	})
+}
+
+// callRewrites maps the lowercased function and static method names
+// to the names their calls are replaced with inside the sources.
+func (r *runner) callRewrites() map[string]string {
+	rewrites := make(map[string]string)
+	if r.usesClock {
+		for name, replacement := range clockFunctions {
+			rewrites[name] = replacement
+		}
+	}
+	for key, stub := range r.stubs {
+		rewrites[key] = `\KTest\Stub\` + stub.shimName()
+	}
+	return rewrites
+}
+
+// callsRewriteVisitor rewrites the calls of the functions
+// and static methods, see runner.callRewrites.
+type callsRewriteVisitor struct {
+	visitor.Null
+
+	rewrites map[string]string
+
+	namespace    string
+	uses         map[string]string
+	funcUses     map[string]string
+	currentClass string
+
+	edits []textEdit
+}
+
+func newCallsRewriteVisitor(rewrites map[string]string) *callsRewriteVisitor {
+	return &callsRewriteVisitor{
+		rewrites: rewrites,
+		uses:     make(map[string]string),
+		funcUses: make(map[string]string),
+	}
+}
+
+func (v *callsRewriteVisitor) StmtNamespace(n *ast.StmtNamespace) {
+	v.namespace = ""
+	v.uses = make(map[string]string)
+	v.funcUses = make(map[string]string)
+	if name, ok := n.Name.(*ast.Name); ok {
+		v.namespace = astNameToString(name)
+	}
+}
+
+func (v *callsRewriteVisitor) StmtUse(n *ast.StmtUseList) {
+	switch useListKind(n) {
+	case "":
+		addUseAliases(v.uses, n)
+	case "function":
+		addUseAliases(v.funcUses, n)
+	}
+}
+
+// useListKind returns the lowercased use statement kind:
+// "function", "const" or "" for the class imports.
+func useListKind(n *ast.StmtUseList) string {
+	if ident, ok := n.Type.(*ast.Identifier); ok {
+		return strings.ToLower(string(ident.Value))
+	}
+	return ""
+}
+
+// addUseAliases maps the lowercased use statement aliases to the imported names.
+func addUseAliases(uses map[string]string, n *ast.StmtUseList) {
+	for _, u := range n.Uses {
+		u, ok := u.(*ast.StmtUse)
+		if !ok {
+			continue
+		}
+		name, ok := u.Use.(*ast.Name)
+		if !ok {
+			continue
+		}
+		alias := string(name.Parts[len(name.Parts)-1].(*ast.NamePart).Value)
+		if ident, ok := u.Alias.(*ast.Identifier); ok {
+			alias = string(ident.Value)
+		}
+		uses[strings.ToLower(alias)] = astNameToString(name)
+	}
+}
+
+func (v *callsRewriteVisitor) StmtClass(n *ast.StmtClass) {
+	v.currentClass = ""
+	if ident, ok := n.Name.(*ast.Identifier); ok {
+		v.currentClass = v.qualify(string(ident.Value))
+	}
+}
+
+func (v *callsRewriteVisitor) ExprFunctionCall(n *ast.ExprFunctionCall) {
+	var candidates []string
+	switch name := n.Function.(type) {
+	case *ast.NameFullyQualified:
+		candidates = []string{strings.Join(namePartsToStrings(name.Parts), `\`)}
+	case *ast.NameRelative:
+		candidates = []string{v.qualify(strings.Join(namePartsToStrings(name.Parts), `\`))}
+	case *ast.Name:
+		parts := namePartsToStrings(name.Parts)
+		if len(parts) == 1 {
+			if fqn, ok := v.funcUses[strings.ToLower(parts[0])]; ok {
+				candidates = []string{fqn}
+			} else {
+				// Unqualified function names fall back to the global namespace.
+				candidates = []string{v.qualify(parts[0]), parts[0]}
+			}
+		} else {
+			candidates = []string{v.resolveClassName(parts)}
+		}
+	default:
+		return
+	}
+
+	for _, fqn := range candidates {
+		if replacement, ok := v.rewrites[strings.ToLower(fqn)]; ok {
+			pos := n.Function.GetPosition()
+			v.rewrite(replacement, pos.StartPos, pos.EndPos)
+			return
+		}
+	}
+}
+
+func (v *callsRewriteVisitor) ExprStaticCall(n *ast.ExprStaticCall) {
+	method, ok := n.Call.(*ast.Identifier)
+	if !ok {
+		return
+	}
+	var className string
+	switch name := n.Class.(type) {
+	case *ast.NameFullyQualified:
+		className = strings.Join(namePartsToStrings(name.Parts), `\`)
+	case *ast.NameRelative:
+		className = v.qualify(strings.Join(namePartsToStrings(name.Parts), `\`))
+	case *ast.Name:
+		parts := namePartsToStrings(name.Parts)
+		switch {
+		case len(parts) == 1 && strings.EqualFold(parts[0], "self"):
+			className = v.currentClass
+		case len(parts) == 1 && (strings.EqualFold(parts[0], "static") || strings.EqualFold(parts[0], "parent")):
+			return // Can't be resolved statically
+		default:
+			className = v.resolveClassName(parts)
+		}
+	default:
+		return
+	}
+
+	if replacement, ok := v.rewrites[strings.ToLower(className+"::"+string(method.Value))]; ok {
+		v.rewrite(replacement, n.Class.GetPosition().StartPos, n.Call.GetPosition().EndPos)
+	}
+}
+
+func (v *callsRewriteVisitor) rewrite(replacement string, startPos, endPos int) {
+	v.edits = append(v.edits, textEdit{
+		StartPos:    startPos,
+		EndPos:      endPos,
+		Replacement: replacement,
+	})
+}
+
+func (v *callsRewriteVisitor) resolveClassName(parts []string) string {
+	if fqn, ok := v.uses[strings.ToLower(parts[0])]; ok {
+		parts[0] = fqn
+		return strings.Join(parts, `\`)
+	}
+	return v.qualify(strings.Join(parts, `\`))
+}
+
+func (v *callsRewriteVisitor) qualify(name string) string {
+	if v.namespace == "" {
+		return name
+	}
+	return v.namespace + `\` + name
+}
@@ -0,0 +1,188 @@
+package phpunit
+
+import (
+	"testing"
+
+	"github.com/VKCOM/php-parser/pkg/visitor/traverser"
+	"github.com/quasilyte/ktest/internal/phpsyntax"
+)
+
+func TestStubCallsRewrite(t *testing.T) {
+	stubsSrc := []byte(`<?php
+namespace Tests;
+
+use App\Http\Request;
+use App\Http\Options as HttpOptions;
+use const App\Http\DEFAULT_TIMEOUT;
+
+class Stubs extends BaseStubs {
+  /** @var int */
+  public static $now = 0;
+
+  /** @ktest-stub time */
+  public static function time(): int { return self::$now; }
+
+  /**
+   * @ktest-stub \App\Http\Client::get
+   */
+  public static function httpGet(string $url, array $headers = []) { return ''; }
+
+  /** @ktest-stub App\log */
+  public static function log(string ...$parts): void {}
+
+  /** @ktest-stub App\Http\Client::send */
+  public static function send(?Request $req, HttpOptions|array $opts = self::RETRIES, int $timeout = DEFAULT_TIMEOUT, self &$ctx = null, $mode = [Request::GET => parent::MODE, PHP_EOL]) {}
+}
+`)
+	src := []byte(`<?php
+namespace App\Service;
+
+use App\Http\Client;
+use App\Http\Client as HttpClient;
+use function App\log;
+
+class Fetcher {
+  public function fetch(string $url) {
+    log('fetch', $url);
+    $start = time() + \time();
+    $data = Client::get($url) . HttpClient::get($url, []) . \App\Http\Client::get($url);
+    return [$data, date('Y'), Client::post($url), strlen($data)];
+  }
+}
+`)
+	want := `<?php
+namespace App\Service;
+
+use App\Http\Client;
+use App\Http\Client as HttpClient;
+use function App\log;
+
+class Fetcher {
+  public function fetch(string $url) {
+    \KTest\Stub\app_nlog('fetch', $url);
+    $start = \KTest\Stub\time() + \KTest\Stub\time();
+    $data = \KTest\Stub\app_nhttp_nclient_mget($url) . \KTest\Stub\app_nhttp_nclient_mget($url, []) . \KTest\Stub\app_nhttp_nclient_mget($url);
+    return [$data, date('Y'), Client::post($url), strlen($data)];
+  }
+}
+`
+
+	v, err := phpsyntax.ParseVersion("8.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rootNode, parserErrors := phpsyntax.Parse(stubsSrc, v)
+	if len(parserErrors) != 0 {
+		t.Fatal(parserErrors[0])
+	}
+	declVisitor := newStubDeclVisitor(stubsSrc)
+	traverser.NewTraverser(declVisitor).Traverse(rootNode)
+	if declVisitor.err != nil {
+		t.Fatal(declVisitor.err)
+	}
+	stubs := make(map[string]*stubDecl)
+	for _, stub := range declVisitor.out {
+		stubs[stub.key()] = stub
+	}
+	if len(stubs) != 4 {
+		t.Fatalf("expected 4 stubs, found %d", len(stubs))
+	}
+	if stub := stubs[`app\http\client::get`]; stub.callee != `\Tests\Stubs::httpGet` || stub.shimParams() != "string $url, array $headers = []" {
+		t.Errorf("unexpected stub: %+v", stub)
+	}
+	if stub := stubs[`app\log`]; !stub.void || stub.shimArgs() != "...$parts" || stub.shimParams() != "string ...$parts" {
+		t.Errorf("unexpected stub: %+v", stub)
+	}
+	wantParams := `?\App\Http\Request $req, ` +
+		`\App\Http\Options|array $opts = \Tests\Stubs::RETRIES, ` +
+		`int $timeout = \App\Http\DEFAULT_TIMEOUT, ` +
+		`\Tests\Stubs &$ctx = null, ` +
+		`$mode = [\App\Http\Request::GET => \Tests\BaseStubs::MODE, PHP_EOL]`
+	if have := stubs[`app\http\client::send`].shimParams(); have != wantParams {
+		t.Errorf("shim params mismatch:\nhave: %s\nwant: %s", have, wantParams)
+	}
+
+	rootNode, parserErrors = phpsyntax.Parse(src, v)
+	if len(parserErrors) != 0 {
+		t.Fatal(parserErrors[0])
+	}
+	r := &runner{stubs: stubs}
+	callsVisitor := newCallsRewriteVisitor(r.callRewrites())
+	traverser.NewTraverser(callsVisitor).Traverse(rootNode)
+	have := string(applyTextEdits(src, callsVisitor.edits))
+	if have != want {
+		t.Errorf("rewrite mismatch:\n%s", unifiedDiff(want, have))
+	}
+}
+
+func TestStubShimName(t *testing.T) {
+	tests := []struct {
+		target string
+		want   string
+	}{
+		{`time`, `time`},
+		{`App\log`, `app_nlog`},
+		{`app_log`, `app__log`},
+		{`a_b\c`, `a__b_nc`},
+		{`a\b_c`, `a_nb__c`},
+		{`App\Http\Client::get`, `app_nhttp_nclient_mget`},
+	}
+
+	seen := make(map[string]string)
+	for _, test := range tests {
+		have := (&stubDecl{target: test.target}).shimName()
+		if have != test.want {
+			t.Errorf("shimName(%s): have %s, want %s", test.target, have, test.want)
+		}
+		if other, ok := seen[have]; ok {
+			t.Errorf("%s and %s share the %s shim", other, test.target, have)
+		}
+		seen[have] = test.target
+	}
+}
+
+func TestCheckStubCalls(t *testing.T) {
+	src := []byte(`<?php
+use PHPUnit\Framework\TestCase;
+
+class ClockTest extends TestCase {
+  public function testTime() {
+    $this->stub('time');
+    $this->stub('\App\Http\Client::GET');
+    $this->stub($this->target);
+    $this->stub('tiem');
+  }
+}
+`)
+	v, err := phpsyntax.ParseVersion("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rootNode, parserErrors := phpsyntax.Parse(src, v)
+	if len(parserErrors) != 0 {
+		t.Fatal(parserErrors[0])
+	}
+	f := &testFile{fullName: "/project/tests/ClockTest.php", info: &testParsedInfo{}}
+	traverser.NewTraverser(&astVisitor{out: f.info}).Traverse(rootNode)
+
+	r := &runner{
+		conf:      &RunConfig{StubsFile: "/project/tests/stubs.php"},
+		testFiles: []*testFile{f},
+		stubs: map[string]*stubDecl{
+			`time`:                 {target: `time`},
+			`app\http\client::get`: {target: `App\Http\Client::get`},
+		},
+	}
+	want := "/project/tests/ClockTest.php:9: can't stub tiem: no @ktest-stub declaration in /project/tests/stubs.php"
+	if err := r.stepCheckStubCalls(); err == nil || err.Error() != want {
+		t.Errorf("error mismatch:\nhave: %v\nwant: %s", err, want)
+	}
+
+	r.conf.StubsFile = ""
+	r.stubs = nil
+	want = "/project/tests/ClockTest.php:6: can't stub time: no stubs file"
+	if err := r.stepCheckStubCalls(); err == nil || err.Error() != want {
+		t.Errorf("error mismatch:\nhave: %v\nwant: %s", err, want)
+	}
+}
@@ -32,4 +32,8 @@ class BasicOpsTest extends TestCase {
     public function testAssertNotEqualsOk2() { $this->assertNotEquals('foo', false); }
     public function testAssertNotEqualsFail1() { $this->assertNotEquals(false, 0); }
     public function testAssertNotEqualsFail2() { $this->assertNotEquals('1', 1); }
+
+    public function testOutputOk1() { $this->expectOutputString('foo'); echo 'foo'; }
+    public function testOutputFail1() { $this->expectOutputString('foo'); echo 'bar'; }
+    public function testOutputRegexOk1() { $this->expectOutputRegex('/^a+$/'); echo 'aaa'; }
 }
@@ -0,0 +1,15 @@
+<?php
+
+namespace Grades;
+
+class Grades {
+    public static function letter(int $score): string {
+        if ($score >= 90) {
+            return 'A';
+        }
+        if ($score >= 50) {
+            return 'C';
+        }
+        return 'F';
+    }
+}
@@ -0,0 +1,14 @@
+<?php
+
+use PHPUnit\Framework\TestCase;
+use Grades\Grades;
+
+class GradesTest extends TestCase {
+    public function testExcellent() {
+        $this->assertSame('A', Grades::letter(95));
+    }
+
+    public function testAverage() {
+        $this->assertSame('C', Grades::letter(70));
+    }
+}
@@ -0,0 +1,9 @@
+<?php
+
+namespace Dice;
+
+class Dice {
+    public static function roll(int $min, int $max): int {
+        return random_int($min, $max);
+    }
+}
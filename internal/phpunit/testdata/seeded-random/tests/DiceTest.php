@@ -0,0 +1,45 @@
+<?php
+
+use PHPUnit\Framework\TestCase;
+use Dice\Dice;
+
+class DiceTest extends TestCase {
+    public function testNarrowRange() {
+        $this->seedRandom(42);
+        $inRange = true;
+        for ($i = 0; $i < 100; $i++) {
+            $n = Dice::roll(-3, 3);
+            if ($n < -3 || $n > 3) {
+                $inRange = false;
+            }
+        }
+        $this->assertTrue($inRange);
+    }
+
+    public function testWideRange() {
+        $this->seedRandom(42);
+        $large = false;
+        for ($i = 0; $i < 10; $i++) {
+            if (Dice::roll(0, PHP_INT_MAX) > 0x7fffffff) {
+                $large = true;
+            }
+        }
+        $this->assertTrue($large);
+
+        $this->seedRandom(7);
+        $first = Dice::roll(PHP_INT_MIN, PHP_INT_MAX);
+        $this->seedRandom(7);
+        $this->assertSame($first, Dice::roll(PHP_INT_MIN, PHP_INT_MAX));
+    }
+
+    public function testInvalidRange() {
+        $this->seedRandom(42);
+        $thrown = false;
+        try {
+            Dice::roll(5, 1);
+        } catch (\Exception $e) {
+            $thrown = true;
+        }
+        $this->assertTrue($thrown);
+    }
+}
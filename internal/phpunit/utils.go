@@ -1,20 +1,39 @@
 package phpunit
 
 import (
-	"encoding/json"
 	"os"
 	"path/filepath"
 	"strings"
 
-	"github.com/z7zmey/php-parser/pkg/ast"
+	"github.com/VKCOM/php-parser/pkg/ast"
+	"github.com/VKCOM/php-parser/pkg/errors"
 )
 
+// parserErrorLine returns a parse error line or 0 if it's unknown.
+func parserErrorLine(e *errors.Error) int {
+	if e.Pos == nil {
+		return 0
+	}
+	return e.Pos.StartLine
+}
+
 func astNameToString(name *ast.Name) string {
-	var parts []string
-	for _, p := range name.Parts {
-		parts = append(parts, string(p.(*ast.NamePart).Value))
+	return strings.Join(namePartsToStrings(name.Parts), `\`)
+}
+
+func namePartsToStrings(nameParts []ast.Vertex) []string {
+	parts := make([]string, len(nameParts))
+	for i, p := range nameParts {
+		parts[i] = string(p.(*ast.NamePart).Value)
+	}
+	return parts
+}
+
+func unquotePHPString(s []byte) string {
+	if len(s) < 2 {
+		return string(s)
 	}
-	return strings.Join(parts, `\`)
+	return string(s[1 : len(s)-1])
 }
 
 func findTestFiles(root string) ([]string, error) {
@@ -39,10 +58,16 @@ func findTestFiles(root string) ([]string, error) {
 	return out, nil
 }
 
-func jsonString(v interface{}) string {
-	b, err := json.Marshal(v)
-	if err != nil {
-		return err.Error()
+// escapePHPString escapes s to be used inside a single-quoted PHP string.
+func escapePHPString(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(s)
+}
+
+func containsString(list []string, s string) bool {
+	for _, x := range list {
+		if x == s {
+			return true
+		}
 	}
-	return string(b)
+	return false
 }
@@ -0,0 +1,147 @@
+package phpunit
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+)
+
+type valueKind int
+
+const (
+	valueNull valueKind = iota
+	valueBool
+	valueInt
+	valueFloat
+	valueString
+	valueArray
+)
+
+// phpValue is a PHP value received from the test binary.
+//
+// Unlike interface{} produced by the encoding/json package,
+// it preserves the array keys order and types, the int/float
+// distinction and the exact float representation.
+type phpValue struct {
+	kind valueKind
+
+	// repr is an exact scalar value representation:
+	// "true" or "false" for bools, digits for ints,
+	// var_export() result for floats, raw string for strings.
+	repr string
+
+	items []phpArrayItem
+}
+
+type phpArrayItem struct {
+	key   *phpValue
+	value *phpValue
+}
+
+// decodeTypedValue decodes a value encoded by the KTest\Runtime::encode.
+//
+// Every value is a JSON array with a type tag as the first element:
+//
+//	["null"]
+//	["bool", "true"]
+//	["int", "10"]
+//	["float", "1.5"]
+//	["string", "foo"]
+//	["bytes", base64]  (strings that are not valid UTF-8)
+//	["array", [[key, value], ...]]
+func decodeTypedValue(data []byte) (*phpValue, error) {
+	var fields []json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("missing type tag")
+	}
+	var tag string
+	if err := json.Unmarshal(fields[0], &tag); err != nil {
+		return nil, fmt.Errorf("decode type tag: %v", err)
+	}
+
+	if tag == "null" {
+		return &phpValue{kind: valueNull}, nil
+	}
+	if len(fields) != 2 {
+		return nil, fmt.Errorf("%s: expected 2 fields, found %d", tag, len(fields))
+	}
+
+	if tag == "array" {
+		var items [][2]json.RawMessage
+		if err := json.Unmarshal(fields[1], &items); err != nil {
+			return nil, fmt.Errorf("array: %v", err)
+		}
+		arr := &phpValue{kind: valueArray, items: make([]phpArrayItem, len(items))}
+		for i, item := range items {
+			key, err := decodeTypedValue(item[0])
+			if err != nil {
+				return nil, err
+			}
+			if key.kind != valueInt && key.kind != valueString {
+				return nil, fmt.Errorf("array: unexpected key type")
+			}
+			value, err := decodeTypedValue(item[1])
+			if err != nil {
+				return nil, err
+			}
+			arr.items[i] = phpArrayItem{key: key, value: value}
+		}
+		return arr, nil
+	}
+
+	var repr string
+	if err := json.Unmarshal(fields[1], &repr); err != nil {
+		return nil, fmt.Errorf("%s: %v", tag, err)
+	}
+	switch tag {
+	case "bool":
+		return &phpValue{kind: valueBool, repr: repr}, nil
+	case "int":
+		return &phpValue{kind: valueInt, repr: repr}, nil
+	case "float":
+		return &phpValue{kind: valueFloat, repr: repr}, nil
+	case "string":
+		return &phpValue{kind: valueString, repr: repr}, nil
+	case "bytes":
+		raw, err := base64.StdEncoding.DecodeString(repr)
+		if err != nil {
+			return nil, fmt.Errorf("bytes: %v", err)
+		}
+		return &phpValue{kind: valueString, repr: string(raw)}, nil
+	default:
+		return nil, fmt.Errorf("unexpected type tag %q", tag)
+	}
+}
+
+// RuntimeSource returns the KTest runtime PHP code.
+//
+// Other tools can require it to encode the values with KTest\Runtime::encode,
+// the results are decoded with ExportTypedValue and DecodeTypedString.
+func RuntimeSource() string {
+	return runtimeSource
+}
+
+// ExportTypedValue decodes a value encoded by the KTest\Runtime::encode
+// and renders it the way PHPUnit prints the values in its failure messages.
+func ExportTypedValue(data []byte) (string, error) {
+	v, err := decodeTypedValue(data)
+	if err != nil {
+		return "", err
+	}
+	return exportValue(v), nil
+}
+
+// DecodeTypedString decodes a string encoded by the KTest\Runtime::encode.
+func DecodeTypedString(data []byte) (string, error) {
+	v, err := decodeTypedValue(data)
+	if err != nil {
+		return "", err
+	}
+	if v.kind != valueString {
+		return "", fmt.Errorf("expected a string, found %s", exportValue(v))
+	}
+	return v.repr, nil
+}
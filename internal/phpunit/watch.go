@@ -0,0 +1,239 @@
+package phpunit
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/VKCOM/php-parser/pkg/version"
+	"github.com/quasilyte/ktest/internal/fileutil"
+	"github.com/quasilyte/ktest/internal/phpsyntax"
+)
+
+type WatchConfig struct {
+	// RunConfig describes the tests to run.
+	// The KPHP compatibility is checked only before the initial run.
+	RunConfig    *RunConfig
+	FormatConfig *FormatConfig
+
+	// PollInterval is a delay between the file system checks.
+	PollInterval time.Duration
+}
+
+// Watch runs the tests and then re-runs the affected tests every time
+// the test files or the sources they depend on change.
+//
+// The changes are detected by polling the file system.
+// Watch returns nil when the ctx is canceled.
+func Watch(ctx context.Context, conf *WatchConfig) error {
+	w := &watcher{ctx: ctx, conf: conf}
+	return w.Watch()
+}
+
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+type watcher struct {
+	ctx  context.Context
+	conf *WatchConfig
+
+	// sourceRoots are the watched source paths, see composerAutoload.sourceRoots.
+	sourceRoots []string
+
+	phpVersion *version.Version
+
+	stamps map[string]fileStamp
+}
+
+func (w *watcher) Watch() error {
+	runConf := *w.conf.RunConfig
+	phpVersion, err := phpsyntax.ParseVersion(runConf.PHPVersion)
+	if err != nil {
+		return err
+	}
+	w.phpVersion = phpVersion
+	if runConf.BuildDir == "" {
+		buildDir, err := ioutil.TempDir("", "kphpunit-build")
+		if err != nil {
+			return err
+		}
+		runConf.BuildDir = buildDir
+		if !runConf.NoCleanup {
+			defer func() {
+				if err := os.RemoveAll(buildDir); err != nil {
+					log.Printf("remove temp build dir: %v", err)
+				}
+			}()
+		}
+	}
+	autoload, err := loadComposerAutoload(runConf.ProjectRoot)
+	if err != nil {
+		return err
+	}
+	w.sourceRoots = autoload.sourceRoots(&runConf)
+
+	stamps, err := w.collectStamps()
+	if err != nil {
+		return err
+	}
+	w.stamps = stamps
+	w.runTests(&runConf, nil)
+
+	ticker := time.NewTicker(w.conf.PollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-w.ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+
+		stamps, err := w.collectStamps()
+		if err != nil {
+			log.Printf("watch: %v", err)
+			continue
+		}
+		changed := w.changedFiles(stamps)
+		w.stamps = stamps
+		if len(changed) == 0 {
+			continue
+		}
+		affected, err := w.affectedTests(changed)
+		if err != nil {
+			log.Printf("watch: %v", err)
+			continue
+		}
+		if len(affected) == 0 {
+			continue
+		}
+		w.runTests(&runConf, affected)
+	}
+}
+
+func (w *watcher) runTests(runConf *RunConfig, affected map[string]bool) {
+	fmt.Fprint(runConf.Output, "\033[H\033[2J")
+
+	conf := *runConf
+	if affected != nil {
+		// The initial run has checked the sources, the incompatible
+		// changes are reported by kphp2cpp as the build errors.
+		conf.NoCompatCheck = true
+		conf.TestFilter = func(filename string) bool {
+			return affected[filename]
+		}
+		names := make([]string, 0, len(affected))
+		for filename := range affected {
+			names = append(names, filepath.Base(filename))
+		}
+		sort.Strings(names)
+		fmt.Fprintf(conf.Output, "Re-running %s\n\n", strings.Join(names, ", "))
+	}
+
+	result, err := Run(w.ctx, &conf)
+	if w.ctx.Err() != nil {
+		return // Interrupted
+	}
+	if err != nil {
+		log.Printf("ktest phpunit: error: %v", err)
+	} else {
+		FormatResult(conf.Output, w.conf.FormatConfig, result)
+	}
+	fmt.Fprintf(conf.Output, "\nWaiting for changes...\n")
+}
+
+func (w *watcher) collectStamps() (map[string]fileStamp, error) {
+	stamps := make(map[string]fileStamp)
+	roots := append([]string{w.conf.RunConfig.TestTarget}, w.sourceRoots...)
+	for _, root := range roots {
+		if !fileutil.FileExists(root) {
+			continue
+		}
+		err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if info.IsDir() || !strings.HasSuffix(path, ".php") {
+				return nil
+			}
+			stamps[path] = fileStamp{modTime: info.ModTime(), size: info.Size()}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return stamps, nil
+}
+
+func (w *watcher) changedFiles(stamps map[string]fileStamp) map[string]bool {
+	changed := make(map[string]bool)
+	for path, stamp := range stamps {
+		if old, ok := w.stamps[path]; !ok || old != stamp {
+			changed[path] = true
+		}
+	}
+	for path := range w.stamps {
+		if _, ok := stamps[path]; !ok {
+			changed[path] = true
+		}
+	}
+	return changed
+}
+
+func (w *watcher) affectedTests(changed map[string]bool) (map[string]bool, error) {
+	testFiles, err := w.findTestFiles()
+	if err != nil {
+		return nil, err
+	}
+
+	index := newDepsIndex(w.phpVersion)
+	for _, root := range w.sourceRoots {
+		if err := index.AddDir(root); err != nil {
+			return nil, err
+		}
+	}
+	for _, f := range testFiles {
+		if err := index.AddFile(f); err != nil {
+			return nil, err
+		}
+	}
+
+	// A removed source file can't be matched against the current
+	// dependencies, so we re-run everything in that case.
+	for path := range changed {
+		if !fileutil.FileExists(path) {
+			all := make(map[string]bool, len(testFiles))
+			for _, f := range testFiles {
+				all[f] = true
+			}
+			return all, nil
+		}
+	}
+
+	affected := make(map[string]bool)
+	for _, f := range testFiles {
+		for dep := range index.Closure(f) {
+			if changed[dep] {
+				affected[f] = true
+				break
+			}
+		}
+	}
+	return affected, nil
+}
+
+func (w *watcher) findTestFiles() ([]string, error) {
+	target := w.conf.RunConfig.TestTarget
+	if strings.HasSuffix(target, ".php") {
+		return []string{target}, nil
+	}
+	return findTestFiles(target)
+}
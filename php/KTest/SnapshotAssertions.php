@@ -0,0 +1,56 @@
+<?php
+
+namespace KTest;
+
+use SebastianBergmann\Exporter\Exporter;
+
+/**
+ * SnapshotAssertions implements assertMatchesSnapshot for the PHPUnit runs.
+ *
+ * ktest rewrites the assertMatchesSnapshot calls to its own runtime, it
+ * declares an empty trait with the same name, so the test classes that use
+ * this trait can be compiled with KPHP too. Both implementations read the
+ * same snapshot files:
+ *
+ *   tests/FooTest.php
+ *   tests/__snapshots__/FooTest/testBar.1.txt
+ *
+ * The number is the snapshot assertion order inside the test method.
+ * A snapshot file contains the exported value followed by a newline.
+ * Set the KTEST_UPDATE_SNAPSHOTS environment variable to write
+ * the missing and mismatching snapshots instead of failing.
+ */
+trait SnapshotAssertions {
+    /** @var string */
+    private $ktestSnapshotTest = '';
+    /** @var int */
+    private $ktestSnapshotCounter = 0;
+
+    /** @param mixed $value */
+    public function assertMatchesSnapshot($value) {
+        $class = new \ReflectionClass($this);
+        $test = method_exists($this, 'name') ? $this->name() : $this->getName(false);
+        if ($this->ktestSnapshotTest !== $test) {
+            $this->ktestSnapshotTest = $test;
+            $this->ktestSnapshotCounter = 0;
+        }
+        $this->ktestSnapshotCounter++;
+        $filename = sprintf('%s/__snapshots__/%s/%s.%d.txt',
+            dirname($class->getFileName()), $class->getShortName(), $test, $this->ktestSnapshotCounter);
+        $actual = (new Exporter())->export($value);
+
+        if (getenv('KTEST_UPDATE_SNAPSHOTS')) {
+            if (!file_exists($filename) || rtrim(file_get_contents($filename), "\n") !== $actual) {
+                if (!is_dir(dirname($filename))) {
+                    mkdir(dirname($filename), 0777, true);
+                }
+                file_put_contents($filename, $actual . "\n");
+            }
+            $this->addToAssertionCount(1);
+            return;
+        }
+
+        $this->assertFileExists($filename);
+        $this->assertSame(rtrim(file_get_contents($filename), "\n"), $actual, "snapshot $filename");
+    }
+}
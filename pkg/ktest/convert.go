@@ -0,0 +1,473 @@
+package ktest
+
+import (
+	"github.com/quasilyte/ktest/internal/bench"
+	"github.com/quasilyte/ktest/internal/fuzzdiff"
+	"github.com/quasilyte/ktest/internal/phpunit"
+)
+
+// This file converts the package types to the runner implementation types and back.
+// The implementation types can change freely, only these conversions
+// should be updated to keep the package API intact.
+
+func toInternalRunConfig(conf *PhpunitConfig) *phpunit.RunConfig {
+	if conf == nil {
+		return nil
+	}
+	internal := &phpunit.RunConfig{
+		ProjectRoot:            conf.ProjectRoot,
+		TestTarget:             conf.TestTarget,
+		TestArgv:               conf.TestArgv,
+		SrcDir:                 conf.SrcDir,
+		KphpCommand:            conf.KphpCommand,
+		PHPVersion:             conf.PHPVersion,
+		OrderBy:                conf.OrderBy,
+		RandomOrderSeed:        conf.RandomOrderSeed,
+		ShardTotal:             conf.ShardTotal,
+		ShardIndex:             conf.ShardIndex,
+		ShardTimingsFile:       conf.ShardTimingsFile,
+		StateFile:              conf.StateFile,
+		RerunFailed:            conf.RerunFailed,
+		TestFilter:             conf.TestFilter,
+		NoCompatCheck:          conf.NoCompatCheck,
+		StopOnSharedBuildError: conf.StopOnSharedBuildError,
+		StopOnFailure:          conf.StopOnFailure,
+		StopOnError:            conf.StopOnError,
+		Coverage:               conf.Coverage,
+		Retries:                conf.Retries,
+		UpdateSnapshots:        conf.UpdateSnapshots,
+		PropertySeed:           conf.PropertySeed,
+		PropertyRuns:           conf.PropertyRuns,
+		MemoryLimitPerTest:     conf.MemoryLimitPerTest,
+		StubsFile:              conf.StubsFile,
+		BuildDir:               conf.BuildDir,
+		Output:                 conf.Output,
+		DebugPrint:             conf.DebugPrint,
+		NoCleanup:              conf.NoCleanup,
+	}
+	internal.Events.ClassStarted = conf.Events.ClassStarted
+	if classFinished := conf.Events.ClassFinished; classFinished != nil {
+		internal.Events.ClassFinished = func(result *phpunit.ClassResult) {
+			classFinished(fromInternalClassResult(result))
+		}
+	}
+	return internal
+}
+
+func toInternalFormatConfig(conf *PhpunitFormatConfig) *phpunit.FormatConfig {
+	if conf == nil {
+		return nil
+	}
+	return &phpunit.FormatConfig{
+		PrintTime:     conf.PrintTime,
+		ShortLocation: conf.ShortLocation,
+		Verbose:       conf.Verbose,
+		Slowest:       conf.Slowest,
+		CompactDiff:   conf.CompactDiff,
+	}
+}
+
+func toInternalWatchConfig(conf *WatchConfig) *phpunit.WatchConfig {
+	return &phpunit.WatchConfig{
+		RunConfig:    toInternalRunConfig(conf.RunConfig),
+		FormatConfig: toInternalFormatConfig(conf.FormatConfig),
+		PollInterval: conf.PollInterval,
+	}
+}
+
+func toInternalMutateConfig(conf *MutateConfig) *phpunit.MutateConfig {
+	return &phpunit.MutateConfig{
+		RunConfig: toInternalRunConfig(conf.RunConfig),
+		Timeout:   conf.Timeout,
+		Progress:  conf.Progress,
+	}
+}
+
+func toInternalBenchConfig(conf *BenchConfig) *bench.RunConfig {
+	return &bench.RunConfig{
+		ProjectRoot: conf.ProjectRoot,
+		BenchTarget: conf.BenchTarget,
+		KphpCommand: conf.KphpCommand,
+		PhpCommand:  conf.PhpCommand,
+		PHPVersion:  conf.PHPVersion,
+		Count:       conf.Count,
+		Output:      conf.Output,
+		DebugPrint:  conf.DebugPrint,
+		NoCleanup:   conf.NoCleanup,
+	}
+}
+
+func toInternalFuzzDiffConfig(conf *FuzzDiffConfig) *fuzzdiff.RunConfig {
+	return &fuzzdiff.RunConfig{
+		ProjectRoot:     conf.ProjectRoot,
+		SrcDir:          conf.SrcDir,
+		Target:          conf.Target,
+		KphpCommand:     conf.KphpCommand,
+		PhpCommand:      conf.PhpCommand,
+		PHPVersion:      conf.PHPVersion,
+		Count:           conf.Count,
+		Seed:            conf.Seed,
+		CorpusDir:       conf.CorpusDir,
+		TimeoutPerInput: conf.TimeoutPerInput,
+		Output:          conf.Output,
+		DebugPrint:      conf.DebugPrint,
+		NoCleanup:       conf.NoCleanup,
+	}
+}
+
+func fromInternalRunResult(result *phpunit.RunResult) *PhpunitResult {
+	converted := &PhpunitResult{
+		Tests:            result.Tests,
+		Assertions:       result.Assertions,
+		Failures:         fromInternalFailures(result.Failures),
+		Time:             result.Time,
+		RandomOrderSeed:  result.RandomOrderSeed,
+		BuildErrors:      fromInternalBuildErrors(result.BuildErrors),
+		NotRun:           result.NotRun,
+		Coverage:         fromInternalCoverage(result.Coverage),
+		Flaky:            fromInternalFlakyTests(result.Flaky),
+		PropertySeed:     result.PropertySeed,
+		UpdatedSnapshots: result.UpdatedSnapshots,
+	}
+	for _, class := range result.Classes {
+		converted.Classes = append(converted.Classes, fromInternalClassResult(class))
+	}
+	return converted
+}
+
+func toInternalRunResult(result *PhpunitResult) *phpunit.RunResult {
+	converted := &phpunit.RunResult{
+		Tests:            result.Tests,
+		Assertions:       result.Assertions,
+		Failures:         toInternalFailures(result.Failures),
+		Time:             result.Time,
+		RandomOrderSeed:  result.RandomOrderSeed,
+		BuildErrors:      toInternalBuildErrors(result.BuildErrors),
+		NotRun:           result.NotRun,
+		Coverage:         toInternalCoverage(result.Coverage),
+		Flaky:            toInternalFlakyTests(result.Flaky),
+		PropertySeed:     result.PropertySeed,
+		UpdatedSnapshots: result.UpdatedSnapshots,
+	}
+	for _, class := range result.Classes {
+		converted.Classes = append(converted.Classes, toInternalClassResult(class))
+	}
+	return converted
+}
+
+func fromInternalClassResult(result *phpunit.ClassResult) *PhpunitClassResult {
+	converted := &PhpunitClassResult{
+		File:        result.File,
+		ClassName:   result.ClassName,
+		Tests:       result.Tests,
+		Assertions:  result.Assertions,
+		Failures:    fromInternalFailures(result.Failures),
+		Flaky:       fromInternalFlakyTests(result.Flaky),
+		Err:         result.Err,
+		CompileTime: result.CompileTime,
+		RunTime:     result.RunTime,
+		MaxRSS:      result.MaxRSS,
+	}
+	if result.BuildError != nil {
+		buildError := fromInternalBuildError(*result.BuildError)
+		converted.BuildError = &buildError
+	}
+	for _, m := range result.Methods {
+		converted.Methods = append(converted.Methods, PhpunitMethodResult(m))
+	}
+	return converted
+}
+
+func toInternalClassResult(result *PhpunitClassResult) *phpunit.ClassResult {
+	converted := &phpunit.ClassResult{
+		File:        result.File,
+		ClassName:   result.ClassName,
+		Tests:       result.Tests,
+		Assertions:  result.Assertions,
+		Failures:    toInternalFailures(result.Failures),
+		Flaky:       toInternalFlakyTests(result.Flaky),
+		Err:         result.Err,
+		CompileTime: result.CompileTime,
+		RunTime:     result.RunTime,
+		MaxRSS:      result.MaxRSS,
+	}
+	if result.BuildError != nil {
+		buildError := toInternalBuildError(*result.BuildError)
+		converted.BuildError = &buildError
+	}
+	for _, m := range result.Methods {
+		converted.Methods = append(converted.Methods, phpunit.MethodResult(m))
+	}
+	return converted
+}
+
+func fromInternalFlakyTests(tests []phpunit.FlakyTest) []PhpunitFlakyTest {
+	var converted []PhpunitFlakyTest
+	for _, t := range tests {
+		converted = append(converted, PhpunitFlakyTest{
+			Name:     t.Name,
+			Retries:  t.Retries,
+			Failures: fromInternalFailures(t.Failures),
+		})
+	}
+	return converted
+}
+
+func toInternalFlakyTests(tests []PhpunitFlakyTest) []phpunit.FlakyTest {
+	var converted []phpunit.FlakyTest
+	for _, t := range tests {
+		converted = append(converted, phpunit.FlakyTest{
+			Name:     t.Name,
+			Retries:  t.Retries,
+			Failures: toInternalFailures(t.Failures),
+		})
+	}
+	return converted
+}
+
+func fromInternalFailures(failures []phpunit.TestFailure) []TestFailure {
+	var converted []TestFailure
+	for _, f := range failures {
+		failure := TestFailure{
+			Name:    f.Name,
+			Reason:  f.Reason,
+			Message: f.Message,
+			File:    f.File,
+			Line:    f.Line,
+			Output:  f.Output,
+		}
+		if f.Diff != nil {
+			diff := ValuesDiff(*f.Diff)
+			failure.Diff = &diff
+		}
+		if f.Property != nil {
+			property := PropertyFailure(*f.Property)
+			failure.Property = &property
+		}
+		converted = append(converted, failure)
+	}
+	return converted
+}
+
+func toInternalFailures(failures []TestFailure) []phpunit.TestFailure {
+	var converted []phpunit.TestFailure
+	for _, f := range failures {
+		failure := phpunit.TestFailure{
+			Name:    f.Name,
+			Reason:  f.Reason,
+			Message: f.Message,
+			File:    f.File,
+			Line:    f.Line,
+			Output:  f.Output,
+		}
+		if f.Diff != nil {
+			diff := phpunit.ValuesDiff(*f.Diff)
+			failure.Diff = &diff
+		}
+		if f.Property != nil {
+			property := phpunit.PropertyFailure(*f.Property)
+			failure.Property = &property
+		}
+		converted = append(converted, failure)
+	}
+	return converted
+}
+
+func fromInternalBuildErrors(errs []phpunit.BuildError) []BuildError {
+	var converted []BuildError
+	for _, e := range errs {
+		converted = append(converted, fromInternalBuildError(e))
+	}
+	return converted
+}
+
+func toInternalBuildErrors(errs []BuildError) []phpunit.BuildError {
+	var converted []phpunit.BuildError
+	for _, e := range errs {
+		converted = append(converted, toInternalBuildError(e))
+	}
+	return converted
+}
+
+func fromInternalBuildError(e phpunit.BuildError) BuildError {
+	converted := BuildError{
+		TestFile:  e.TestFile,
+		TestClass: e.TestClass,
+		Output:    e.Output,
+	}
+	for _, d := range e.Diagnostics {
+		converted.Diagnostics = append(converted.Diagnostics, BuildDiagnostic(d))
+	}
+	return converted
+}
+
+func toInternalBuildError(e BuildError) phpunit.BuildError {
+	converted := phpunit.BuildError{
+		TestFile:  e.TestFile,
+		TestClass: e.TestClass,
+		Output:    e.Output,
+	}
+	for _, d := range e.Diagnostics {
+		converted.Diagnostics = append(converted.Diagnostics, phpunit.BuildDiagnostic(d))
+	}
+	return converted
+}
+
+func fromInternalBuildErrorGroups(groups []*phpunit.BuildErrorGroup) []*BuildErrorGroup {
+	var converted []*BuildErrorGroup
+	for _, g := range groups {
+		group := &BuildErrorGroup{
+			Output:      g.Output,
+			TestClasses: g.TestClasses,
+		}
+		for _, d := range g.Diagnostics {
+			group.Diagnostics = append(group.Diagnostics, BuildDiagnostic(d))
+		}
+		converted = append(converted, group)
+	}
+	return converted
+}
+
+func fromInternalCoverage(profile *phpunit.CoverageProfile) *CoverageProfile {
+	if profile == nil {
+		return nil
+	}
+	converted := &CoverageProfile{}
+	for _, f := range profile.Files {
+		file := &FileCoverage{Filename: f.Filename}
+		for _, l := range f.Lines {
+			file.Lines = append(file.Lines, LineCoverage(l))
+		}
+		converted.Files = append(converted.Files, file)
+	}
+	return converted
+}
+
+func toInternalCoverage(profile *CoverageProfile) *phpunit.CoverageProfile {
+	if profile == nil {
+		return nil
+	}
+	converted := &phpunit.CoverageProfile{}
+	for _, f := range profile.Files {
+		file := &phpunit.FileCoverage{Filename: f.Filename}
+		for _, l := range f.Lines {
+			file.Lines = append(file.Lines, phpunit.LineCoverage(l))
+		}
+		converted.Files = append(converted.Files, file)
+	}
+	return converted
+}
+
+func fromInternalCompatIssues(issues []phpunit.CompatIssue) []CompatIssue {
+	var converted []CompatIssue
+	for _, issue := range issues {
+		converted = append(converted, CompatIssue(issue))
+	}
+	return converted
+}
+
+func toInternalCompatIssue(issue CompatIssue) phpunit.CompatIssue {
+	return phpunit.CompatIssue(issue)
+}
+
+func toInternalCompatIssues(issues []CompatIssue) []phpunit.CompatIssue {
+	var converted []phpunit.CompatIssue
+	for _, issue := range issues {
+		converted = append(converted, toInternalCompatIssue(issue))
+	}
+	return converted
+}
+
+func toInternalMutantStatus(s MutantStatus) phpunit.MutantStatus {
+	switch s {
+	case MutantKilled:
+		return phpunit.MutantKilled
+	case MutantSurvived:
+		return phpunit.MutantSurvived
+	case MutantTimedOut:
+		return phpunit.MutantTimedOut
+	case MutantBuildError:
+		return phpunit.MutantBuildError
+	case MutantNotCovered:
+		return phpunit.MutantNotCovered
+	default:
+		return -1
+	}
+}
+
+func fromInternalMutantStatus(s phpunit.MutantStatus) MutantStatus {
+	switch s {
+	case phpunit.MutantKilled:
+		return MutantKilled
+	case phpunit.MutantSurvived:
+		return MutantSurvived
+	case phpunit.MutantTimedOut:
+		return MutantTimedOut
+	case phpunit.MutantBuildError:
+		return MutantBuildError
+	case phpunit.MutantNotCovered:
+		return MutantNotCovered
+	default:
+		return -1
+	}
+}
+
+func fromInternalMutationResult(result *phpunit.MutationResult) *MutationResult {
+	converted := &MutationResult{Time: result.Time}
+	for _, m := range result.Mutants {
+		converted.Mutants = append(converted.Mutants, &Mutant{
+			File:        m.File,
+			Line:        m.Line,
+			Description: m.Description,
+			Status:      fromInternalMutantStatus(m.Status),
+		})
+	}
+	return converted
+}
+
+func toInternalMutationResult(result *MutationResult) *phpunit.MutationResult {
+	converted := &phpunit.MutationResult{Time: result.Time}
+	for _, m := range result.Mutants {
+		converted.Mutants = append(converted.Mutants, &phpunit.Mutant{
+			File:        m.File,
+			Line:        m.Line,
+			Description: m.Description,
+			Status:      toInternalMutantStatus(m.Status),
+		})
+	}
+	return converted
+}
+
+func fromInternalFuzzDiffResult(result *fuzzdiff.Result) *FuzzDiffResult {
+	converted := &FuzzDiffResult{
+		Time:         result.Time,
+		Inputs:       result.Inputs,
+		CorpusInputs: result.CorpusInputs,
+	}
+	for _, d := range result.Divergences {
+		converted.Divergences = append(converted.Divergences, Divergence{
+			Input:   d.Input,
+			PHP:     FuzzOutcome(d.PHP),
+			KPHP:    FuzzOutcome(d.KPHP),
+			SavedTo: d.SavedTo,
+		})
+	}
+	return converted
+}
+
+func toInternalFuzzDiffResult(result *FuzzDiffResult) *fuzzdiff.Result {
+	converted := &fuzzdiff.Result{
+		Time:         result.Time,
+		Inputs:       result.Inputs,
+		CorpusInputs: result.CorpusInputs,
+	}
+	for _, d := range result.Divergences {
+		converted.Divergences = append(converted.Divergences, fuzzdiff.Divergence{
+			Input:   d.Input,
+			PHP:     fuzzdiff.Outcome(d.PHP),
+			KPHP:    fuzzdiff.Outcome(d.KPHP),
+			SavedTo: d.SavedTo,
+		})
+	}
+	return converted
+}
@@ -0,0 +1,96 @@
+package ktest
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/quasilyte/ktest/internal/bench"
+	"github.com/quasilyte/ktest/internal/fuzzdiff"
+	"github.com/quasilyte/ktest/internal/phpunit"
+)
+
+func TestTypesMirrorImplementation(t *testing.T) {
+	// A field added to the implementation type should be added here too,
+	// otherwise the conversions silently drop it.
+	tests := []struct {
+		public   interface{}
+		internal interface{}
+	}{
+		{PhpunitConfig{}, phpunit.RunConfig{}},
+		{PhpunitEvents{}, phpunit.Events{}},
+		{PhpunitResult{}, phpunit.RunResult{}},
+		{PhpunitClassResult{}, phpunit.ClassResult{}},
+		{PhpunitFlakyTest{}, phpunit.FlakyTest{}},
+		{TestFailure{}, phpunit.TestFailure{}},
+		{PhpunitFormatConfig{}, phpunit.FormatConfig{}},
+		{BuildError{}, phpunit.BuildError{}},
+		{BuildErrorGroup{}, phpunit.BuildErrorGroup{}},
+		{CoverageProfile{}, phpunit.CoverageProfile{}},
+		{FileCoverage{}, phpunit.FileCoverage{}},
+		{WatchConfig{}, phpunit.WatchConfig{}},
+		{MutateConfig{}, phpunit.MutateConfig{}},
+		{Mutant{}, phpunit.Mutant{}},
+		{MutationResult{}, phpunit.MutationResult{}},
+		{BenchConfig{}, bench.RunConfig{}},
+		{FuzzDiffConfig{}, fuzzdiff.RunConfig{}},
+		{FuzzDiffResult{}, fuzzdiff.Result{}},
+		{Divergence{}, fuzzdiff.Divergence{}},
+	}
+
+	for _, test := range tests {
+		publicType := reflect.TypeOf(test.public)
+		internalType := reflect.TypeOf(test.internal)
+		have := exportedFields(publicType)
+		want := exportedFields(internalType)
+		if !reflect.DeepEqual(have, want) {
+			t.Errorf("%s fields mismatch %s:\nhave: %q\nwant: %q", publicType, internalType, have, want)
+		}
+	}
+}
+
+func TestConvertRunResult(t *testing.T) {
+	buildError := phpunit.BuildError{
+		TestFile:    "/project/tests/BarTest.php",
+		TestClass:   "BarTest",
+		Diagnostics: []phpunit.BuildDiagnostic{{Severity: "error", File: "/project/src/Bar.php", Line: 3, Message: "oops"}},
+	}
+	failures := []phpunit.TestFailure{{
+		Name:     "FooTest::testFoo",
+		Reason:   "Failed asserting that 1 is identical to 2",
+		File:     "/project/tests/FooTest.php",
+		Line:     10,
+		Diff:     &phpunit.ValuesDiff{Reason: "identical", Expected: "2", Actual: "1"},
+		Property: &phpunit.PropertyFailure{Seed: 1, Runs: 2, Shrinks: 3, Counterexample: "[]"},
+	}}
+	result := &phpunit.RunResult{
+		Tests:       3,
+		Assertions:  5,
+		Failures:    failures,
+		BuildErrors: []phpunit.BuildError{buildError},
+		Coverage: &phpunit.CoverageProfile{
+			Files: []*phpunit.FileCoverage{{Filename: "src/Foo.php", Lines: []phpunit.LineCoverage{{Line: 1, Hits: 2}}}},
+		},
+		Classes: []*phpunit.ClassResult{
+			{File: "/project/tests/FooTest.php", ClassName: "FooTest", Tests: 2, Failures: failures,
+				Methods: []phpunit.MethodResult{{Name: "testFoo", PeakMemory: 100}}},
+			{File: "/project/tests/BarTest.php", ClassName: "BarTest", BuildError: &buildError},
+		},
+		Flaky:            []phpunit.FlakyTest{{Name: "FooTest::testBar", Retries: 1, Failures: failures}},
+		UpdatedSnapshots: []string{"tests/__snapshots__/FooTest.testFoo.1.txt"},
+	}
+
+	have := toInternalRunResult(fromInternalRunResult(result))
+	if !reflect.DeepEqual(have, result) {
+		t.Errorf("round trip mismatch:\nhave: %+v\nwant: %+v", have, result)
+	}
+}
+
+func exportedFields(typ reflect.Type) []string {
+	var fields []string
+	for i := 0; i < typ.NumField(); i++ {
+		if f := typ.Field(i); f.PkgPath == "" {
+			fields = append(fields, f.Name)
+		}
+	}
+	return fields
+}
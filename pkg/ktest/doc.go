@@ -0,0 +1,26 @@
+// Package ktest is a Go API for the ktest runners.
+//
+// It makes it possible to run the KPHP tests and benchmarks from the
+// other Go programs without executing the ktest binary and parsing its output.
+// The ktest command itself is implemented on top of this package.
+//
+// Every runner accepts a context.Context; canceling it interrupts
+// the running kphp2cpp and test binary processes.
+// The test run progress can be observed via PhpunitEvents callbacks.
+//
+// # Compatibility
+//
+// The package API only grows. Between the module versions, the exported
+// functions, types, fields and constants may be added, but they are never
+// removed, renamed or given a different meaning.
+// New config fields are added so that their zero values keep the old behavior.
+//
+// The types are owned by this package, they're not shared with the runners
+// implementation, so the implementation changes don't leak into this API.
+// To keep your program compatible with the new fields, use the keyed
+// struct literals and don't compare the structs with ==.
+//
+// The text produced by the Format functions and the runners progress output
+// is meant for humans and may change between any versions.
+// Use the result structs if you need a machine-readable representation.
+package ktest
@@ -0,0 +1,33 @@
+package ktest_test
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/quasilyte/ktest/pkg/ktest"
+)
+
+func ExampleRunPhpunit() {
+	conf := &ktest.PhpunitConfig{
+		ProjectRoot: "/path/to/project/",
+		SrcDir:      "src",
+		TestTarget:  "/path/to/project/tests",
+		KphpCommand: ktest.FindKphpBinary(),
+		Output:      os.Stderr,
+		Events: ktest.PhpunitEvents{
+			ClassFinished: func(result *ktest.PhpunitClassResult) {
+				fmt.Printf("%s: %d tests, %d failures\n", result.ClassName, result.Tests, len(result.Failures))
+			},
+		},
+	}
+
+	result, err := ktest.RunPhpunit(context.Background(), conf)
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, failure := range result.Failures {
+		fmt.Printf("%s:%d: %s\n", failure.File, failure.Line, failure.Reason)
+	}
+}
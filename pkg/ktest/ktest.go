@@ -0,0 +1,131 @@
+package ktest
+
+import (
+	"context"
+	"io"
+
+	"github.com/quasilyte/ktest/internal/bench"
+	"github.com/quasilyte/ktest/internal/fuzzdiff"
+	"github.com/quasilyte/ktest/internal/kenv"
+	"github.com/quasilyte/ktest/internal/phpsyntax"
+	"github.com/quasilyte/ktest/internal/phpunit"
+)
+
+// DefaultPHPVersion is a PHP language version that is used when the config doesn't specify any.
+const DefaultPHPVersion = phpsyntax.DefaultVersion
+
+// SupportedPHPVersions describes the PHP language versions that can be parsed.
+const SupportedPHPVersions = phpsyntax.SupportedVersions
+
+// FindKphpBinary returns the kphp2cpp binary path.
+// It looks into the $PATH first, then into the $KPHP_ROOT and ~/kphp.
+// An empty string is returned if the binary can't be found.
+func FindKphpBinary() string {
+	return kenv.FindKphpBinary()
+}
+
+// RunPhpunit compiles the phpunit tests with KPHP and runs them.
+//
+// The tests that fail are reported inside the result;
+// the error is returned only if the run itself can't be performed.
+func RunPhpunit(ctx context.Context, conf *PhpunitConfig) (*PhpunitResult, error) {
+	result, err := phpunit.Run(ctx, toInternalRunConfig(conf))
+	if err != nil {
+		return nil, err
+	}
+	return fromInternalRunResult(result), nil
+}
+
+// WatchPhpunit runs the tests and re-runs the affected ones after every file change.
+// It returns nil after the ctx is canceled.
+func WatchPhpunit(ctx context.Context, conf *WatchConfig) error {
+	return phpunit.Watch(ctx, toInternalWatchConfig(conf))
+}
+
+// CheckPhpunit reports the KPHP compatibility issues in the test
+// files and the sources they depend on without compiling them.
+func CheckPhpunit(conf *PhpunitConfig) ([]CompatIssue, error) {
+	issues, err := phpunit.Check(toInternalRunConfig(conf))
+	if err != nil {
+		return nil, err
+	}
+	return fromInternalCompatIssues(issues), nil
+}
+
+// Mutate runs the tests against the mutated sources.
+func Mutate(ctx context.Context, conf *MutateConfig) (*MutationResult, error) {
+	result, err := phpunit.Mutate(ctx, toInternalMutateConfig(conf))
+	if err != nil {
+		return nil, err
+	}
+	return fromInternalMutationResult(result), nil
+}
+
+// RunBench compiles and runs the benchmarks.
+// The results are written to the conf.Output in the Go benchmarks format.
+func RunBench(ctx context.Context, conf *BenchConfig) error {
+	return bench.Run(ctx, toInternalBenchConfig(conf))
+}
+
+// FuzzDiff evaluates a method with the same generated inputs
+// using PHP and KPHP and reports the differences.
+func FuzzDiff(ctx context.Context, conf *FuzzDiffConfig) (*FuzzDiffResult, error) {
+	result, err := fuzzdiff.Run(ctx, toInternalFuzzDiffConfig(conf))
+	if err != nil {
+		return nil, err
+	}
+	return fromInternalFuzzDiffResult(result), nil
+}
+
+// DefaultPhpunitStateFile returns the default PhpunitConfig.StateFile location
+// for the project. It's located inside the user cache directory.
+func DefaultPhpunitStateFile(projectRoot string) (string, error) {
+	return phpunit.DefaultStateFile(projectRoot)
+}
+
+// GroupBuildErrors combines the identical build errors.
+// The groups are ordered by their first appearance.
+func GroupBuildErrors(errs []BuildError) []*BuildErrorGroup {
+	return fromInternalBuildErrorGroups(phpunit.GroupBuildErrors(toInternalBuildErrors(errs)))
+}
+
+// FormatPhpunitResult prints the result in a PHPUnit-like format.
+func FormatPhpunitResult(w io.Writer, conf *PhpunitFormatConfig, result *PhpunitResult) {
+	phpunit.FormatResult(w, toInternalFormatConfig(conf), toInternalRunResult(result))
+}
+
+// WritePhpunitJSONReport writes the tests run result as a JSON document.
+func WritePhpunitJSONReport(w io.Writer, result *PhpunitResult) error {
+	return phpunit.WriteJSONReport(w, toInternalRunResult(result))
+}
+
+// WritePhpunitJUnitReport writes the tests run result in the JUnit XML format.
+func WritePhpunitJUnitReport(w io.Writer, result *PhpunitResult) error {
+	return phpunit.WriteJUnitReport(w, toInternalRunResult(result))
+}
+
+// FormatCompatIssues prints the compatibility issues, one per line.
+func FormatCompatIssues(w io.Writer, issues []CompatIssue) {
+	phpunit.FormatCompatIssues(w, toInternalCompatIssues(issues))
+}
+
+// FormatMutationResult prints the survived mutants and the mutation score.
+func FormatMutationResult(w io.Writer, result *MutationResult) {
+	phpunit.FormatMutationResult(w, toInternalMutationResult(result))
+}
+
+// FormatFuzzDiffResult prints the divergences and the inputs summary.
+func FormatFuzzDiffResult(w io.Writer, result *FuzzDiffResult) {
+	fuzzdiff.FormatResult(w, toInternalFuzzDiffResult(result))
+}
+
+// WriteCoverageLcov writes the coverage profile in the lcov tracefile format.
+// root is used to turn the relative source file names into absolute paths.
+func WriteCoverageLcov(w io.Writer, root string, profile *CoverageProfile) error {
+	return phpunit.WriteCoverageLcov(w, root, toInternalCoverage(profile))
+}
+
+// WriteCoverageCobertura writes the coverage profile in the Cobertura XML format.
+func WriteCoverageCobertura(w io.Writer, root string, profile *CoverageProfile) error {
+	return phpunit.WriteCoverageCobertura(w, root, toInternalCoverage(profile))
+}
@@ -0,0 +1,534 @@
+package ktest
+
+import (
+	"io"
+	"time"
+)
+
+// PhpunitConfig configures the phpunit tests run.
+type PhpunitConfig struct {
+	ProjectRoot string
+	TestTarget  string
+	TestArgv    []string
+
+	// SrcDir is a project sources root. It's used along with the
+	// composer.json "autoload" paths, so it can be left empty
+	// for the projects that declare all of their sources there.
+	SrcDir string
+
+	KphpCommand string
+
+	// PHPVersion is a "major.minor" PHP language version used to parse
+	// the test files and sources. If empty, DefaultPHPVersion is used.
+	PHPVersion string
+
+	// OrderBy controls the test execution order.
+	// Supported values are "default" and "random".
+	OrderBy string
+
+	// RandomOrderSeed is used to shuffle the tests when OrderBy is "random".
+	// If 0, a new seed is generated for every run.
+	RandomOrderSeed int64
+
+	// ShardTotal splits the test classes into the specified number of shards,
+	// only the classes from the ShardIndex shard are executed.
+	// If 0, all classes are executed.
+	ShardTotal int
+
+	// ShardIndex is a shard to execute, starting from 0.
+	ShardIndex int
+
+	// ShardTimingsFile is a JSON report of a previous run (see WritePhpunitJSONReport).
+	// If set, the classes are distributed over the shards by their
+	// compile and run time, otherwise every class has the same weight.
+	ShardTimingsFile string
+
+	// StateFile is a file that keeps the failed tests between the runs.
+	// If empty, the run state is not saved. See DefaultPhpunitStateFile.
+	StateFile string
+
+	// RerunFailed executes only the tests that failed during the previous
+	// runs according to the StateFile.
+	RerunFailed bool
+
+	// TestFilter selects the test files to run.
+	// If nil, all test files are executed.
+	TestFilter func(filename string) bool
+
+	// NoCompatCheck disables the KPHP compatibility checks
+	// that are performed before the tests compilation.
+	NoCompatCheck bool
+
+	// StopOnSharedBuildError stops the run after the first build error
+	// that is reported for the code outside of the test files.
+	StopOnSharedBuildError bool
+
+	// StopOnFailure stops the run after the first failed test.
+	StopOnFailure bool
+
+	// StopOnError stops the run after the first test class
+	// that can't be compiled or executed.
+	StopOnError bool
+
+	// Coverage enables the line coverage collection.
+	Coverage bool
+
+	// Retries is a number of times a failed test method is re-executed.
+	// The methods that pass on a retry are reported as flaky.
+	Retries int
+
+	// UpdateSnapshots makes assertMatchesSnapshot write the missing
+	// and mismatching snapshots instead of reporting a failure.
+	UpdateSnapshots bool
+
+	// PropertySeed is a seed for the forAll generators.
+	// If 0, a new seed is generated for every run.
+	PropertySeed int64
+
+	// PropertyRuns is a number of the generated inputs every forAll checks.
+	// If 0, the runtime default of 100 is used.
+	PropertyRuns int
+
+	// MemoryLimitPerTest is a peak memory usage limit in bytes.
+	// The tests that exceed it are reported as failed.
+	// If 0, the memory usage is not limited.
+	MemoryLimitPerTest int64
+
+	// StubsFile is a PHP file with the @ktest-stub declarations.
+	// If empty, nothing is stubbed.
+	StubsFile string
+
+	// BuildDir is a build directory to use instead of a temporary one.
+	// It's never removed by the runner, so it can be re-used between runs.
+	BuildDir string
+
+	// Events are called as the test classes are processed.
+	Events PhpunitEvents
+
+	Output     io.Writer
+	DebugPrint func(string)
+
+	NoCleanup bool
+}
+
+// PhpunitEvents holds the optional test run callbacks.
+// They're called synchronously, from the goroutine that executes the run.
+type PhpunitEvents struct {
+	// ClassStarted is called before a test class is compiled.
+	ClassStarted func(filename, className string)
+
+	// ClassFinished is called after a test class is compiled and executed.
+	ClassFinished func(result *PhpunitClassResult)
+}
+
+// PhpunitResult is a phpunit tests run result.
+type PhpunitResult struct {
+	Tests      int
+	Assertions int
+	Failures   []TestFailure
+	Time       time.Duration
+
+	// RandomOrderSeed is a seed that was used to shuffle the tests.
+	// It's 0 unless the random order was requested.
+	RandomOrderSeed int64
+
+	// BuildErrors lists the test files that can't be compiled.
+	BuildErrors []BuildError
+
+	// NotRun is a number of tests that were skipped due to the early stop.
+	NotRun int
+
+	// Coverage is nil unless the coverage collection was requested.
+	Coverage *CoverageProfile
+
+	// Classes lists the executed test classes in the execution order.
+	Classes []*PhpunitClassResult
+
+	// Flaky lists the tests that failed, but passed on a retry.
+	// They're not included into the Failures.
+	Flaky []PhpunitFlakyTest
+
+	// PropertySeed is a seed that was used by the forAll generators.
+	PropertySeed int64
+
+	// UpdatedSnapshots lists the snapshot files written in the update mode.
+	// The paths are relative to the project root.
+	UpdatedSnapshots []string
+}
+
+// PhpunitClassResult describes a single test class run.
+type PhpunitClassResult struct {
+	File      string
+	ClassName string
+
+	// Tests is a number of the test methods inside the class.
+	Tests      int
+	Assertions int
+	Failures   []TestFailure
+
+	// Flaky lists the test methods that passed on a retry.
+	Flaky []PhpunitFlakyTest
+
+	// BuildError is set if the class can't be compiled.
+	BuildError *BuildError
+
+	// Err is set if the compiled tests can't be executed
+	// or their results can't be collected.
+	Err error
+
+	// CompileTime is the kphp2cpp execution time.
+	CompileTime time.Duration
+
+	// RunTime is the compiled test binary execution time,
+	// the failed tests retries are included.
+	RunTime time.Duration
+
+	// MaxRSS is the test binary maximum resident set size in bytes.
+	// It's 0 if the platform doesn't report it.
+	MaxRSS int64
+
+	// Methods lists the executed test methods in the execution order.
+	Methods []PhpunitMethodResult
+}
+
+// PhpunitMethodResult describes a single test method run.
+type PhpunitMethodResult struct {
+	Name string
+
+	// Time is the test method execution time measured inside the test binary.
+	Time time.Duration
+
+	// MemoryUsage is the memory_get_usage() difference between the test
+	// start and finish, in bytes.
+	MemoryUsage int64
+
+	// PeakMemory is the test peak memory usage in bytes relative to the
+	// test start memory usage. It's 0 if it can't be measured.
+	PeakMemory int64
+}
+
+// PhpunitFlakyTest describes a test that passed only after a retry.
+type PhpunitFlakyTest struct {
+	Name string
+
+	// Retries is a number of retries it took to pass the test.
+	Retries int
+
+	// Failures are the failures of the initial test run.
+	Failures []TestFailure
+}
+
+// TestFailure describes a failed test assertion.
+type TestFailure struct {
+	Name    string
+	Reason  string
+	Message string
+	File    string
+	Line    int
+
+	// Diff is set for the failed comparisons of arrays and multi-line strings.
+	Diff *ValuesDiff
+
+	// Output is everything the test printed to the stdout.
+	Output string
+
+	// Property is set if the failure is caused by a falsified forAll property.
+	Property *PropertyFailure
+}
+
+// ValuesDiff holds the pretty-printed values of a failed comparison.
+type ValuesDiff struct {
+	Reason   string
+	Expected string
+	Actual   string
+}
+
+// PropertyFailure describes a minimal forAll counterexample.
+type PropertyFailure struct {
+	// Seed reproduces the failure when used as PhpunitConfig.PropertySeed.
+	Seed int64
+
+	// Runs is a number of the generated inputs that were checked.
+	Runs int
+
+	// Shrinks is a number of the successful simplification steps.
+	Shrinks int
+
+	// Counterexample is the exported array of the property arguments.
+	Counterexample string
+}
+
+// PhpunitFormatConfig controls the FormatPhpunitResult output.
+type PhpunitFormatConfig struct {
+	PrintTime     bool
+	ShortLocation bool
+
+	// Verbose enables the per-class and per-method timings listing.
+	Verbose bool
+
+	// Slowest is a number of the slowest test methods to report.
+	// If 0, the report is not printed.
+	Slowest int
+
+	// CompactDiff disables the unified diffs for the failed comparisons,
+	// one-line reasons are printed instead.
+	CompactDiff bool
+}
+
+// BuildError describes a test class that can't be compiled.
+type BuildError struct {
+	// TestFile is a test file that was being built.
+	TestFile  string
+	TestClass string
+
+	Diagnostics []BuildDiagnostic
+
+	// Output is a raw kphp2cpp output.
+	// It's useful when the output can't be parsed into diagnostics.
+	Output string
+}
+
+// IsShared reports whether the build error comes from the code
+// that is not a part of the test file, like the project sources.
+func (e *BuildError) IsShared() bool {
+	internal := toInternalBuildError(*e)
+	return internal.IsShared()
+}
+
+// BuildDiagnostic is a single kphp2cpp error or warning.
+type BuildDiagnostic struct {
+	Severity string
+
+	// File is a path to the original file, not the build dir file.
+	File     string
+	Line     int
+	Function string
+
+	Message string
+}
+
+// BuildErrorGroup is a build error that is shared by several test classes.
+type BuildErrorGroup struct {
+	// Diagnostics is a normalized diagnostics list.
+	// If it's empty, Output should be used instead.
+	Diagnostics []BuildDiagnostic
+	Output      string
+
+	TestClasses []string
+}
+
+// CoverageProfile is a line coverage collected from the instrumented sources.
+type CoverageProfile struct {
+	Files []*FileCoverage
+}
+
+// FileCoverage is a line coverage of a single source file.
+type FileCoverage struct {
+	// Filename is a path relative to the project root.
+	Filename string
+
+	Lines []LineCoverage
+}
+
+// CoveredLines returns the number of lines that were executed at least once.
+func (f *FileCoverage) CoveredLines() int {
+	n := 0
+	for _, l := range f.Lines {
+		if l.Hits != 0 {
+			n++
+		}
+	}
+	return n
+}
+
+// LineCoverage is a number of times a source line was executed.
+type LineCoverage struct {
+	Line int
+	Hits int
+}
+
+// CompatIssue is a PHP construct that is known to be unsupported by KPHP.
+type CompatIssue struct {
+	File    string
+	Line    int
+	Message string
+
+	// Warning marks the heuristic findings: the code may compile fine,
+	// but it's likely to behave differently in KPHP.
+	Warning bool
+}
+
+func (issue CompatIssue) String() string {
+	return toInternalCompatIssue(issue).String()
+}
+
+// WatchConfig configures the WatchPhpunit.
+type WatchConfig struct {
+	// RunConfig describes the tests to run.
+	// The KPHP compatibility is checked only before the initial run.
+	RunConfig    *PhpunitConfig
+	FormatConfig *PhpunitFormatConfig
+
+	// PollInterval is a delay between the file system checks.
+	PollInterval time.Duration
+}
+
+// MutateConfig configures the mutation testing.
+type MutateConfig struct {
+	// RunConfig describes the tests to run against the mutants.
+	// Its StateFile, RerunFailed and UpdateSnapshots are ignored.
+	// The KPHP compatibility is checked only before the original sources run.
+	RunConfig *PhpunitConfig
+
+	// Timeout is a time budget for a single mutant run (build + tests).
+	// Mutants that exceed it are reported as timed out.
+	Timeout time.Duration
+
+	// Progress is used to report the mutants that were checked.
+	// Can be nil.
+	Progress io.Writer
+}
+
+// MutantStatus is a mutant run outcome.
+type MutantStatus int
+
+const (
+	MutantKilled MutantStatus = iota
+	MutantSurvived
+	MutantTimedOut
+	MutantBuildError
+	MutantNotCovered
+)
+
+func (s MutantStatus) String() string {
+	return toInternalMutantStatus(s).String()
+}
+
+// Mutant is a single source code mutation.
+type Mutant struct {
+	// File is a mutated source file path relative to the project root.
+	File string
+	Line int
+
+	Description string
+
+	Status MutantStatus
+}
+
+// MutationResult is a mutation testing result.
+type MutationResult struct {
+	Mutants []*Mutant
+	Time    time.Duration
+}
+
+// Score returns the percentage of the killed mutants.
+// Timed out mutants are counted as killed, mutants that can't
+// be compiled are not counted at all.
+func (result *MutationResult) Score() float64 {
+	return toInternalMutationResult(result).Score()
+}
+
+// BenchConfig configures the benchmarks run.
+type BenchConfig struct {
+	ProjectRoot string
+	BenchTarget string
+
+	KphpCommand string
+	PhpCommand  string
+
+	// PHPVersion is a "major.minor" PHP language version used to parse
+	// the benchmark files. If empty, DefaultPHPVersion is used.
+	PHPVersion string
+
+	Count int
+
+	Output     io.Writer
+	DebugPrint func(string)
+
+	NoCleanup bool
+}
+
+// FuzzDiffConfig configures the differential fuzzing.
+type FuzzDiffConfig struct {
+	ProjectRoot string
+
+	// SrcDir is a directory inside the ProjectRoot
+	// that is searched for the target class.
+	SrcDir string
+
+	// Target is a "Class::method" to fuzz.
+	// The class name can be either fully qualified or a short one.
+	Target string
+
+	KphpCommand string
+	PhpCommand  string
+
+	// PHPVersion is a "major.minor" PHP language version used to parse
+	// the source files. If empty, DefaultPHPVersion is used.
+	PHPVersion string
+
+	// Count is a number of the generated inputs.
+	Count int
+
+	// Seed is used to generate the inputs.
+	// The same seed results in the same inputs.
+	Seed int64
+
+	// CorpusDir is a directory where the diverging inputs are saved.
+	// The inputs found there are evaluated before the generated ones.
+	// If empty, testdata/fuzz-diff/<Class>.<method> inside the ProjectRoot is used.
+	CorpusDir string
+
+	// TimeoutPerInput limits a single input evaluation by PHP or KPHP.
+	// The timed out evaluation is reported as a crash.
+	// If 0, the evaluation time is not limited.
+	TimeoutPerInput time.Duration
+
+	Output     io.Writer
+	DebugPrint func(string)
+
+	NoCleanup bool
+}
+
+// FuzzDiffResult is a differential fuzzing result.
+type FuzzDiffResult struct {
+	Time time.Duration
+
+	// Inputs is a number of the evaluated inputs,
+	// CorpusInputs of them were loaded from the corpus.
+	Inputs       int
+	CorpusInputs int
+
+	Divergences []Divergence
+}
+
+// Divergence is an input the PHP and KPHP evaluate differently.
+type Divergence struct {
+	// Input is a JSON-encoded arguments list.
+	Input string
+
+	PHP  FuzzOutcome
+	KPHP FuzzOutcome
+
+	// SavedTo is a corpus file the input was saved to.
+	// It's empty for the inputs that were loaded from the corpus.
+	SavedTo string
+}
+
+// FuzzOutcome is a result of the target method evaluation.
+type FuzzOutcome struct {
+	// Result is the returned value exported the way PHPUnit prints it,
+	// null for the void methods.
+	Result string
+
+	Output string
+
+	// Exception is a "Class: message" of the thrown exception, if any.
+	Exception string
+
+	// Warnings contains the stderr contents.
+	Warnings string
+
+	// Crash describes an abnormal process termination, if any.
+	Crash string
+}